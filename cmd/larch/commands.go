@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AlexGustafsson/larch/internal/config"
+)
+
+// commands holds the commands of the CLI by name. Commands are passed the
+// arguments following the command's name.
+var commands = map[string]func(context.Context, *config.Config, []string) error{
+	"delete":     deleteCommand,
+	"fsck":       fsckCommand,
+	"gc":         gcCommand,
+	"replicate":  replicateCommand,
+	"retention":  retentionCommand,
+	"rotate-key": rotateKeyCommand,
+}
+
+func runCommand(ctx context.Context, cfg *config.Config, name string, args []string) error {
+	command, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command: %s", name)
+	}
+
+	return command(ctx, cfg, args)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/AlexGustafsson/larch/internal/config"
+)
+
+// deleteCommand deletes a snapshot, or all snapshots of an origin, from a
+// library.
+//
+//	larch delete -library <library> <origin> [<snapshot>]
+func deleteCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	libraryID := flags.String("library", "", "library to delete from")
+	flags.Parse(args)
+
+	if *libraryID == "" || flags.NArg() < 1 || flags.NArg() > 2 {
+		return fmt.Errorf("usage: larch delete -library <library> <origin> [<snapshot>]")
+	}
+	origin := flags.Arg(0)
+
+	libraryReaders, libraryWriters := openLibraries(cfg)
+	defer closeLibraries(libraryReaders)
+
+	if _, ok := libraryReaders[*libraryID]; !ok {
+		return fmt.Errorf("no such library: %s", *libraryID)
+	}
+
+	deleter, err := newDeleter(cfg, libraryReaders, libraryWriters, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	deleted := []string{flags.Arg(1)}
+	if flags.NArg() == 2 {
+		err = deleter.DeleteSnapshot(ctx, *libraryID, origin, flags.Arg(1), "cli")
+	} else {
+		deleted, err = deleter.DeleteOrigin(ctx, *libraryID, origin, "cli")
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no such snapshot: %s/%s", origin, flags.Arg(1))
+	} else if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]any{
+		"library":   *libraryID,
+		"origin":    origin,
+		"snapshots": deleted,
+	})
+}
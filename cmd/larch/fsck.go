@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/AlexGustafsson/larch/internal/config"
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+// fsckCommand verifies the integrity of a library and writes a report to
+// stdout. Fails if any problems are found.
+//
+//	larch fsck [-repair] [-quarantine] <library>
+func fsckCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "repair problems that can be repaired without loss, such as symlinks")
+	quarantine := flags.Bool("quarantine", false, "move corrupt blobs out of the library")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: larch fsck [flags] <library>")
+	}
+	libraryID := flags.Arg(0)
+
+	libraryReaders, libraryWriters := openLibraries(cfg)
+	defer closeLibraries(libraryReaders)
+
+	library, ok := libraryReaders[libraryID]
+	if !ok {
+		return fmt.Errorf("no such library: %s", libraryID)
+	}
+
+	if _, ok := libraryWriters[libraryID]; !ok && (*repair || *quarantine) {
+		return fmt.Errorf("library is read-only: %s", libraryID)
+	}
+
+	report, err := libraries.Verify(ctx, library, libraries.VerifyOptions{
+		Repair:     *repair,
+		Quarantine: *quarantine,
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("found %d problems", len(report.Problems))
+	}
+
+	return nil
+}
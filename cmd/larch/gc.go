@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/AlexGustafsson/larch/internal/config"
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+// gcCommand collects garbage of a library.
+//
+//	larch gc [-dry-run] [-grace-period 24h] <library>
+func gcCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report collectable blobs without removing them")
+	gracePeriod := flags.Duration("grace-period", libraries.DefaultGracePeriod, "minimum age of unreferenced blobs to collect")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: larch gc [flags] <library>")
+	}
+	libraryID := flags.Arg(0)
+
+	libraryReaders, libraryWriters := openLibraries(cfg)
+	defer closeLibraries(libraryReaders)
+
+	library, ok := libraryReaders[libraryID]
+	if !ok {
+		return fmt.Errorf("no such library: %s", libraryID)
+	}
+
+	if _, ok := libraryWriters[libraryID]; !ok && !*dryRun {
+		return fmt.Errorf("library is read-only: %s", libraryID)
+	}
+
+	// Blobs are written before the index referencing them, possibly by a
+	// running server, so recent blobs must be left alone
+	if *gracePeriod < libraries.MinGracePeriod && !*dryRun {
+		return fmt.Errorf("grace period must be at least %s", libraries.MinGracePeriod)
+	}
+
+	collector, ok := library.(libraries.GarbageCollector)
+	if !ok {
+		return fmt.Errorf("library does not support garbage collection: %s", libraryID)
+	}
+
+	report, err := collector.CollectGarbage(ctx, libraries.GarbageCollectionOptions{
+		GracePeriod: *gracePeriod,
+		DryRun:      *dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
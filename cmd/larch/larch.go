@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"os"
+	"regexp"
 	"time"
 
 	"net/http"
@@ -11,9 +15,10 @@ import (
 	"github.com/AlexGustafsson/larch/internal/api"
 	"github.com/AlexGustafsson/larch/internal/config"
 	"github.com/AlexGustafsson/larch/internal/indexers"
-	"github.com/AlexGustafsson/larch/internal/libraries"
-	"github.com/AlexGustafsson/larch/internal/libraries/archivebox"
-	"github.com/AlexGustafsson/larch/internal/libraries/disk"
+	"github.com/AlexGustafsson/larch/internal/quota"
+	"github.com/AlexGustafsson/larch/internal/replication"
+	"github.com/AlexGustafsson/larch/internal/rules"
+	"github.com/AlexGustafsson/larch/internal/sources"
 	"github.com/AlexGustafsson/larch/internal/worker"
 	"golang.org/x/sync/errgroup"
 )
@@ -26,58 +31,17 @@ func main() {
 		panic(err)
 	}
 
-	libraryReaders := make(map[string]libraries.LibraryReader)
-	libraryWriters := make(map[string]libraries.LibraryWriter)
-	for libraryID, library := range cfg.Libraries {
-		switch library.Type {
-		case "disk":
-			var options config.DiskLibraryOptions
-			if err := library.Options.As(&options); err != nil {
-				panic(err)
-			}
-
-			// TODO: Path relative to config file
-			lib, err := disk.NewLibrary(options.Path)
-			if err != nil {
-				panic(err)
-			}
-
-			libraryReaders[libraryID] = lib
-			if !options.ReadOnly {
-				libraryWriters[libraryID] = lib
-			}
-		case "archivebox":
-			var options config.ArchiveBoxLibraryOptions
-			if err := library.Options.As(&options); err != nil {
-				panic(err)
-			}
-
-			if !options.ReadOnly {
-				panic(fmt.Errorf("ArchiveBox libraries must be read-only"))
-			}
-
-			// TODO: Persist index
-			indexer, err := archivebox.NewIndexer(options.Path)
-			if err != nil {
-				panic(err)
-			}
-
-			index, err := indexer.Index(context.Background())
-			if err != nil {
-				panic(err)
-			}
-			fmt.Printf("%+v\n", index)
-
-			// TODO: Path relative to config file
-			lib, err := archivebox.NewLibrary(options.Path, index)
-			if err != nil {
-				panic(err)
-			}
-
-			libraryReaders[libraryID] = lib
+	// Run a command, if specified. Otherwise serve
+	if len(os.Args) > 1 {
+		if err := runCommand(context.Background(), cfg, os.Args[1], os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		return
 	}
 
+	libraryReaders, libraryWriters := openLibraries(cfg)
+
 	strategies := make(map[string]worker.Strategy)
 	for strategyID, strategy := range cfg.Strategies {
 		archivers := make([]worker.Archiver, 0)
@@ -111,12 +75,65 @@ func main() {
 				})
 			}
 		}
+		libraries := strategy.Libraries
+		if strategy.Library != "" {
+			libraries = append([]string{strategy.Library}, libraries...)
+		}
+
+		for _, library := range libraries {
+			if _, ok := libraryWriters[library]; !ok {
+				panic("invalid library")
+			}
+		}
+
 		strategies[strategyID] = worker.Strategy{
-			Library:   strategy.Library,
+			ID:        strategyID,
+			Libraries: libraries,
 			Archivers: archivers,
 		}
 	}
 
+	rewriter := &rules.Rewriter{}
+	if cfg.Rewrite != nil {
+		rewriter.StripParameters = cfg.Rewrite.StripParameters
+		rewriter.StripFragment = cfg.Rewrite.StripFragment
+		rewriter.StripHostPrefixes = cfg.Rewrite.StripHostPrefixes
+		for _, rewrite := range cfg.Rewrite.Rewrites {
+			rewriter.Rewrites = append(rewriter.Rewrites, rules.Rewrite{
+				Pattern:     regexp.MustCompile(rewrite.Pattern),
+				Replacement: rewrite.Replacement,
+			})
+		}
+	}
+
+	router := &rules.Router{
+		Rules: make([]rules.Rule, 0),
+	}
+	for _, rule := range cfg.Rules {
+		if _, ok := strategies[rule.Strategy]; !ok {
+			panic("invalid strategy")
+		}
+
+		if rule.Library != "" {
+			if _, ok := libraryWriters[rule.Library]; !ok {
+				panic("invalid library")
+			}
+		}
+
+		var path *regexp.Regexp
+		if rule.Path != "" {
+			path = regexp.MustCompile(rule.Path)
+		}
+
+		router.Rules = append(router.Rules, rules.Rule{
+			Host:        rule.Host,
+			Path:        path,
+			ContentType: rule.ContentType,
+			Strategy:    rule.Strategy,
+			Library:     rule.Library,
+		})
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 
@@ -127,11 +144,33 @@ func main() {
 		}
 	}
 
-	scheduler := worker.NewScheduler(index, libraryReaders, libraryWriters)
+	quotas := make(map[string]quota.Quota)
+	for libraryID, library := range cfg.Libraries {
+		if library.Quota != nil {
+			quotas[libraryID] = quota.Quota{
+				Soft: int64(library.Quota.Soft),
+				Hard: int64(library.Quota.Hard),
+			}
+		}
+	}
+
+	usage := quota.NewTracker(libraryReaders, quotas)
+
+	replicas, err := worker.NewReplicaStore(statePath(cfg))
+	if err != nil {
+		panic(err)
+	}
+
+	scheduler := worker.NewScheduler(index, libraryReaders, libraryWriters, usage, replicas)
+
+	deleter, err := newDeleter(cfg, libraryReaders, libraryWriters, index, usage)
+	if err != nil {
+		panic(err)
+	}
 
 	webMux := http.NewServeMux()
 
-	webMux.Handle("/api/v1/", api.NewServer(index, libraryReaders))
+	webMux.Handle("/api/v1/", api.NewServer(index, libraryReaders, libraryWriters, scheduler, strategies, rewriter, router, deleter, usage, cfg.API != nil && cfg.API.Admin))
 
 	webServer := http.Server{
 		Addr:    ":8080",
@@ -167,14 +206,67 @@ func main() {
 		return nil
 	})
 
+	// Track library usage
+	wg.Go(func() error {
+		// TODO: Configurable interval
+		err := usage.Run(context.Background(), time.Hour)
+		if err != context.Canceled {
+			return err
+		}
+
+		return nil
+	})
+
 	// Run a default worker
 	wg.Go(func() error {
 		worker := worker.NewWorker("http://localhost:8081")
 		return worker.Work(context.Background())
 	})
 
-	// Trigger test job
-	for _, source := range cfg.Sources {
+	stateStore, err := sources.NewStateStore(statePath(cfg))
+	if err != nil {
+		panic(err)
+	}
+
+	runner := sources.NewRunner(stateStore, func(ctx context.Context, item sources.Item, strategyID string) error {
+		url, err := rewriter.Rewrite(item.URL)
+		if err != nil {
+			return err
+		}
+
+		route, err := router.Route(ctx, url, strategyID)
+		if err != nil {
+			return err
+		}
+
+		strategy, ok := strategies[route.Strategy]
+		if !ok {
+			return fmt.Errorf("no such strategy: %s", route.Strategy)
+		}
+
+		if route.Library != "" {
+			strategy.Libraries = []string{route.Library}
+		}
+
+		_, err = scheduler.ScheduleSnapshot(ctx, url, &strategy, &worker.ScheduleSnapshotOptions{
+			Annotations: item.Annotations,
+			OriginalURL: item.URL,
+		})
+		return err
+	})
+
+	for i, source := range cfg.Sources {
+		if _, ok := strategies[source.Strategy]; !ok {
+			panic("invalid strategy")
+		}
+
+		// NOTE: The id is used to persist state, renaming a source will make it
+		// start over
+		id := source.Name
+		if id == "" {
+			id = fmt.Sprintf("%s#%d", source.Type, i)
+		}
+
 		switch source.Type {
 		case "url":
 			var options config.URLSourceOptions
@@ -182,18 +274,249 @@ func main() {
 				panic(err)
 			}
 
-			strategy, ok := strategies[source.Strategy]
-			if !ok {
-				panic("invalid strategy")
+			runner.Register(sources.RunnerSource{
+				ID: id,
+				Source: &sources.URLSource{
+					URL: options.URL,
+				},
+				Strategy:  source.Strategy,
+				Interval:  options.Interval,
+				Recurring: true,
+			})
+		case "feed":
+			var options config.FeedSourceOptions
+			if err := source.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			runner.Register(sources.RunnerSource{
+				ID: id,
+				Source: &sources.FeedSource{
+					URL:    options.URL,
+					Client: http.DefaultClient,
+				},
+				Strategy: source.Strategy,
+				Interval: options.Interval,
+			})
+		case "opml":
+			var options config.OPMLSourceOptions
+			if err := source.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			// TODO: Path relative to config file
+			feeds, err := config.ReadOPMLFile(options.Path, source.Strategy, options.Interval)
+			if err != nil {
+				panic(err)
+			}
+
+			for _, feed := range feeds {
+				var options config.FeedSourceOptions
+				if err := feed.Options.As(&options); err != nil {
+					panic(err)
+				}
+
+				// NOTE: Feeds are keyed by their URL, as titles are not unique. Changing
+				// a feed's URL will make it start over
+				hash := sha256.Sum256([]byte(options.URL))
+				runner.Register(sources.RunnerSource{
+					ID: id + "/" + hex.EncodeToString(hash[:8]),
+					Source: &sources.FeedSource{
+						URL:    options.URL,
+						Client: http.DefaultClient,
+					},
+					Strategy: feed.Strategy,
+					Interval: options.Interval,
+				})
+			}
+		case "netscape-bookmarks":
+			var options config.NetscapeBookmarksSourceOptions
+			if err := source.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			// TODO: Path relative to config file
+			runner.Register(sources.RunnerSource{
+				ID: id,
+				Source: &sources.NetscapeBookmarksSource{
+					Path: options.Path,
+				},
+				Strategy: source.Strategy,
+				Interval: options.Interval,
+			})
+		case "sitemap":
+			var options config.SitemapSourceOptions
+			if err := source.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			runner.Register(sources.RunnerSource{
+				ID: id,
+				Source: &sources.SitemapSource{
+					URL:    options.URL,
+					Client: http.DefaultClient,
+				},
+				Strategy: source.Strategy,
+				Interval: options.Interval,
+			})
+		case "inbox":
+			var options config.InboxSourceOptions
+			if err := source.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			interval := options.Interval
+			if interval == 0 {
+				interval = time.Minute
+			}
+
+			// TODO: Path relative to config file
+			runner.Register(sources.RunnerSource{
+				ID: id,
+				Source: &sources.InboxSource{
+					Path: options.Path,
+				},
+				Strategy:  source.Strategy,
+				Interval:  interval,
+				Untracked: true,
+			})
+		case "mail":
+			var options config.MailSourceOptions
+			if err := source.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			include := make([]*regexp.Regexp, 0)
+			for _, pattern := range options.Include {
+				include = append(include, regexp.MustCompile(pattern))
 			}
 
-			err := scheduler.ScheduleSnapshot(context.Background(), options.URL, &strategy)
+			exclude := make([]*regexp.Regexp, 0)
+			for _, pattern := range options.Exclude {
+				exclude = append(exclude, regexp.MustCompile(pattern))
+			}
+
+			// TODO: Path relative to config file
+			runner.Register(sources.RunnerSource{
+				ID: id,
+				Source: &sources.MailSource{
+					Path:    options.Path,
+					Include: include,
+					Exclude: exclude,
+				},
+				Strategy: source.Strategy,
+				Interval: options.Interval,
+			})
+		case "json":
+			var options config.JSONSourceOptions
+			if err := source.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			path, err := sources.CompileJSONPath(options.Path)
 			if err != nil {
 				panic(err)
 			}
+
+			headers := make(map[string]string)
+			for k, v := range options.Headers {
+				headers[k] = os.ExpandEnv(v)
+			}
+
+			var pagination sources.JSONPagination
+			if options.Pagination != nil {
+				pagination.CursorParameter = options.Pagination.CursorParameter
+				pagination.LinkHeader = options.Pagination.LinkHeader
+				pagination.MaxPages = options.Pagination.MaxPages
+
+				if options.Pagination.Next != "" {
+					pagination.Next, err = sources.CompileJSONPath(options.Pagination.Next)
+					if err != nil {
+						panic(err)
+					}
+				}
+
+				if options.Pagination.Cursor != "" {
+					pagination.Cursor, err = sources.CompileJSONPath(options.Pagination.Cursor)
+					if err != nil {
+						panic(err)
+					}
+				}
+			}
+
+			runner.Register(sources.RunnerSource{
+				ID: id,
+				Source: &sources.JSONSource{
+					URL:        options.URL,
+					Client:     http.DefaultClient,
+					Headers:    headers,
+					Path:       path,
+					Pagination: pagination,
+				},
+				Strategy: source.Strategy,
+				Interval: options.Interval,
+			})
+		default:
+			panic(fmt.Errorf("unsupported source type: %s", source.Type))
 		}
 	}
 
+	for i, mirror := range cfg.Mirrors {
+		source, ok := libraryReaders[mirror.Source]
+		if !ok {
+			panic("invalid library")
+		}
+
+		target, ok := libraryWriters[mirror.Target].(replication.Target)
+		if !ok {
+			panic("invalid library")
+		}
+
+		id := mirror.Name
+		if id == "" {
+			id = fmt.Sprintf("%s->%s#%d", mirror.Source, mirror.Target, i)
+		}
+
+		mirror := &replication.Mirror{
+			ID:       id,
+			Source:   source,
+			Target:   target,
+			Interval: mirror.Interval,
+		}
+
+		// Run mirror
+		wg.Go(func() error {
+			err := mirror.Run(context.Background())
+			if err != context.Canceled {
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	if job, ok := newRetentionJob(cfg, deleter); ok {
+		// Apply retention policies
+		wg.Go(func() error {
+			err := job.Run(context.Background())
+			if err != context.Canceled {
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	// Run sources
+	wg.Go(func() error {
+		err := runner.Run(context.Background())
+		if err != context.Canceled {
+			return err
+		}
+
+		return nil
+	})
+
 	if err := wg.Wait(); err != nil {
 		panic(err)
 	}
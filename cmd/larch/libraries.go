@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/AlexGustafsson/larch/internal/config"
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/libraries/archivebox"
+	"github.com/AlexGustafsson/larch/internal/libraries/disk"
+	"github.com/AlexGustafsson/larch/internal/libraries/oci"
+	"github.com/AlexGustafsson/larch/internal/libraries/registry"
+	"github.com/AlexGustafsson/larch/internal/libraries/s3"
+	"github.com/AlexGustafsson/larch/internal/libraries/warc"
+)
+
+// openLibraries opens all configured libraries. Read-only libraries are not
+// part of the returned writers.
+func openLibraries(cfg *config.Config) (map[string]libraries.LibraryReader, map[string]libraries.LibraryWriter) {
+	libraryReaders := make(map[string]libraries.LibraryReader)
+	libraryWriters := make(map[string]libraries.LibraryWriter)
+	for libraryID, library := range cfg.Libraries {
+		switch library.Type {
+		case "disk":
+			var options config.DiskLibraryOptions
+			if err := library.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			// TODO: Path relative to config file
+			var keys [][]byte
+			if options.Encryption != nil {
+				var err error
+				keys, err = readKeys(options.Encryption)
+				if err != nil {
+					panic(err)
+				}
+			}
+
+			lib, err := disk.NewLibrary(options.Path, &disk.LibraryOptions{
+				ContentEncoding: options.ContentEncoding(),
+				Keys:            keys,
+			})
+			if err != nil {
+				panic(err)
+			}
+
+			libraryReaders[libraryID] = lib
+			if !options.ReadOnly {
+				libraryWriters[libraryID] = lib
+			}
+		case "warc":
+			var options config.WARCLibraryOptions
+			if err := library.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			// TODO: Path relative to config file
+			lib, err := warc.NewLibrary(options.Path)
+			if err != nil {
+				panic(err)
+			}
+
+			libraryReaders[libraryID] = lib
+			if !options.ReadOnly {
+				libraryWriters[libraryID] = lib
+			}
+		case "oci":
+			var options config.OCILibraryOptions
+			if err := library.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			// TODO: Path relative to config file
+			lib, err := oci.NewLibrary(options.Path)
+			if err != nil {
+				panic(err)
+			}
+
+			libraryReaders[libraryID] = lib
+			if !options.ReadOnly {
+				libraryWriters[libraryID] = lib
+			}
+		case "registry":
+			var options config.RegistryLibraryOptions
+			if err := library.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			lib := registry.NewLibrary(&registry.Client{
+				Endpoint: options.Endpoint,
+				Username: options.Username,
+				Password: os.ExpandEnv(options.Password),
+			}, options.Repository)
+
+			libraryReaders[libraryID] = lib
+			if !options.ReadOnly {
+				libraryWriters[libraryID] = lib
+			}
+		case "s3":
+			var options config.S3LibraryOptions
+			if err := library.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			lib := s3.NewLibrary(&s3.Client{
+				Endpoint:        options.Endpoint,
+				Region:          options.Region,
+				Bucket:          options.Bucket,
+				AccessKeyID:     options.AccessKeyID,
+				SecretAccessKey: os.ExpandEnv(options.SecretAccessKey),
+				PathStyle:       options.PathStyle,
+			}, &s3.LibraryOptions{
+				Prefix:   options.Prefix,
+				Redirect: options.Redirect,
+			})
+
+			libraryReaders[libraryID] = lib
+			if !options.ReadOnly {
+				libraryWriters[libraryID] = lib
+			}
+		case "archivebox":
+			var options config.ArchiveBoxLibraryOptions
+			if err := library.Options.As(&options); err != nil {
+				panic(err)
+			}
+
+			if !options.ReadOnly {
+				panic(fmt.Errorf("ArchiveBox libraries must be read-only"))
+			}
+
+			// TODO: Persist index
+			indexer, err := archivebox.NewIndexer(options.Path)
+			if err != nil {
+				panic(err)
+			}
+
+			index, err := indexer.Index(context.Background())
+			if err != nil {
+				panic(err)
+			}
+			slog.Debug("Indexed ArchiveBox library", slog.String("library", libraryID), slog.Any("index", index))
+
+			// TODO: Path relative to config file
+			lib, err := archivebox.NewLibrary(options.Path, index)
+			if err != nil {
+				panic(err)
+			}
+
+			libraryReaders[libraryID] = lib
+		}
+	}
+
+	return libraryReaders, libraryWriters
+}
+
+// readKeys returns the keys of an encrypted disk library, the key to encrypt
+// with first.
+func readKeys(options *config.DiskEncryptionOptions) ([][]byte, error) {
+	values := make([]string, 0)
+
+	if options.Key != "" {
+		values = append(values, os.ExpandEnv(options.Key))
+	} else if options.KeyFile != "" {
+		// TODO: Path relative to config file
+		data, err := os.ReadFile(options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, string(data))
+	} else {
+		return nil, fmt.Errorf("encryption requires a key or key file")
+	}
+
+	for _, key := range options.PreviousKeys {
+		values = append(values, os.ExpandEnv(key))
+	}
+
+	for _, path := range options.PreviousKeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, string(data))
+	}
+
+	keys := make([][]byte, 0)
+	for _, value := range values {
+		key, err := disk.ParseKey(value)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// closeLibraries closes all libraries, logging any errors.
+func closeLibraries(libraryReaders map[string]libraries.LibraryReader) {
+	for libraryID, library := range libraryReaders {
+		if err := library.Close(); err != nil {
+			slog.Error("Failed to close library", slog.String("library", libraryID), slog.Any("error", err))
+		}
+	}
+}
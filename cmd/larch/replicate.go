@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/AlexGustafsson/larch/internal/config"
+	"github.com/AlexGustafsson/larch/internal/replication"
+)
+
+// replicateCommand copies snapshots missing from the target library from the
+// source library.
+//
+//	larch replicate <source> <target>
+func replicateCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("replicate", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: larch replicate <source> <target>")
+	}
+	sourceID := flags.Arg(0)
+	targetID := flags.Arg(1)
+
+	libraryReaders, libraryWriters := openLibraries(cfg)
+	defer closeLibraries(libraryReaders)
+
+	source, ok := libraryReaders[sourceID]
+	if !ok {
+		return fmt.Errorf("no such library: %s", sourceID)
+	}
+
+	if _, ok := libraryReaders[targetID]; !ok {
+		return fmt.Errorf("no such library: %s", targetID)
+	}
+
+	target, ok := libraryWriters[targetID].(replication.Target)
+	if !ok {
+		return fmt.Errorf("library is read-only: %s", targetID)
+	}
+
+	report, err := replication.Replicate(ctx, source, target)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("failed to replicate %d snapshots", len(report.Failed))
+	}
+
+	return nil
+}
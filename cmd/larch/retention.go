@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/config"
+	"github.com/AlexGustafsson/larch/internal/indexers"
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/quota"
+	"github.com/AlexGustafsson/larch/internal/retention"
+)
+
+// statePath returns the path to store state in.
+func statePath(cfg *config.Config) string {
+	if cfg.State != nil && cfg.State.Path != "" {
+		// TODO: Path relative to config file
+		return cfg.State.Path
+	}
+
+	return "./data/state"
+}
+
+// newDeleter returns a deleter recording deletions in the audit log of the
+// state directory. The index is optional.
+func newDeleter(cfg *config.Config, libraryReaders map[string]libraries.LibraryReader, libraryWriters map[string]libraries.LibraryWriter, index indexers.Indexer, quotas *quota.Tracker) (*retention.Deleter, error) {
+	audit, err := retention.NewAuditLog(filepath.Join(statePath(cfg), "audit.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &retention.Deleter{
+		LibraryReaders: libraryReaders,
+		LibraryWriters: libraryWriters,
+		Index:          index,
+		Audit:          audit,
+		Quotas:         quotas,
+	}, nil
+}
+
+// newRetentionJob returns a job applying the configured retention policies.
+// Returns false if no policies are configured.
+func newRetentionJob(cfg *config.Config, deleter *retention.Deleter) (*retention.Job, bool) {
+	job := &retention.Job{
+		Deleter:          deleter,
+		LibraryPolicies:  make(map[string]*retention.Policy),
+		StrategyPolicies: make(map[string]*retention.Policy),
+		Interval:         24 * time.Hour,
+	}
+
+	if cfg.Retention != nil && cfg.Retention.Interval > 0 {
+		job.Interval = cfg.Retention.Interval
+	}
+
+	for libraryID, library := range cfg.Libraries {
+		if library.Retention != nil {
+			job.LibraryPolicies[libraryID] = newPolicy(library.Retention)
+		}
+	}
+
+	for strategyID, strategy := range cfg.Strategies {
+		if strategy.Retention != nil {
+			job.StrategyPolicies[strategyID] = newPolicy(strategy.Retention)
+		}
+	}
+
+	return job, len(job.LibraryPolicies)+len(job.StrategyPolicies) > 0
+}
+
+func newPolicy(policy *config.RetentionPolicy) *retention.Policy {
+	return &retention.Policy{
+		KeepLast:    policy.KeepLast,
+		KeepWithin:  policy.KeepWithin,
+		KeepDaily:   policy.KeepDaily,
+		KeepWeekly:  policy.KeepWeekly,
+		KeepMonthly: policy.KeepMonthly,
+		MaxAge:      policy.MaxAge,
+	}
+}
+
+// retentionCommand applies the configured retention policies once.
+//
+//	larch retention [-dry-run]
+func retentionCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("retention", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report snapshots to delete without deleting them")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: larch retention [flags]")
+	}
+
+	libraryReaders, libraryWriters := openLibraries(cfg)
+	defer closeLibraries(libraryReaders)
+
+	deleter, err := newDeleter(cfg, libraryReaders, libraryWriters, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	job, ok := newRetentionJob(cfg, deleter)
+	if !ok {
+		return fmt.Errorf("no retention policies configured")
+	}
+
+	report, err := job.Apply(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("failed to delete %d snapshots", len(report.Failed))
+	}
+
+	return nil
+}
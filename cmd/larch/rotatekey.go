@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/AlexGustafsson/larch/internal/config"
+	"github.com/AlexGustafsson/larch/internal/libraries/disk"
+)
+
+// rotateKeyCommand re-encrypts a disk library using a new key. The library's
+// configured keys must be able to decrypt all content. Once rotated, configure
+// the new key as the library's key. Content written by larch using the old key
+// while rotating, such as by a running server, is re-encrypted by running the
+// command again.
+//
+// Rotating to a key of a library without encryption encrypts it.
+//
+//	larch rotate-key -key-file <path> <library>
+func rotateKeyCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	keyFile := flags.String("key-file", "", "path to a file holding the new base64-encoded key")
+	flags.Parse(args)
+
+	if *keyFile == "" || flags.NArg() != 1 {
+		return fmt.Errorf("usage: larch rotate-key -key-file <path> <library>")
+	}
+	libraryID := flags.Arg(0)
+
+	data, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	key, err := disk.ParseKey(string(data))
+	if err != nil {
+		return err
+	}
+
+	libraryReaders, libraryWriters := openLibraries(cfg)
+	defer closeLibraries(libraryReaders)
+
+	if _, ok := libraryReaders[libraryID]; !ok {
+		return fmt.Errorf("no such library: %s", libraryID)
+	}
+
+	if _, ok := libraryWriters[libraryID]; !ok {
+		return fmt.Errorf("library is read-only: %s", libraryID)
+	}
+
+	library, ok := libraryWriters[libraryID].(*disk.Library)
+	if !ok {
+		return fmt.Errorf("library does not support encryption: %s", libraryID)
+	}
+
+	report, err := library.RotateKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
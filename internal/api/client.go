@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -62,6 +63,40 @@ func (c *Client) GetSnapshot(ctx context.Context, origin string, snapshot string
 	return &result, nil
 }
 
+func (c *Client) CreateSnapshot(ctx context.Context, url string, strategy string) (*ScheduledSnapshot, error) {
+	body, err := json.Marshal(CreateSnapshotRequest{
+		URL:      url,
+		Strategy: strategy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint+"/api/v1/snapshots", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	var result ScheduledSnapshot
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
 func (c *Client) CopyBlob(ctx context.Context, w http.ResponseWriter, digest string) error {
 	algorithm, digest, ok := strings.Cut(digest, ":")
 	if !ok {
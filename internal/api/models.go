@@ -76,3 +76,102 @@ type ArtifactPageEmbedded struct {
 type ArtifactEmbedded struct {
 	Artifacts []Artifact `json:"larch:artifact"`
 }
+
+type CreateSnapshotRequest struct {
+	URL string `json:"url"`
+	// Strategy is the strategy to use. If empty, the strategy is chosen by the
+	// configured rules.
+	Strategy string `json:"strategy,omitempty"`
+}
+
+type ScheduledSnapshot struct {
+	ID       string                    `json:"id"`
+	URL      string                    `json:"url"`
+	Origin   string                    `json:"origin"`
+	Embedded ScheduledSnapshotEmbedded `json:"_embedded"`
+	Links    ScheduledSnapshotLinks    `json:"_links"`
+}
+
+type ScheduledSnapshotEmbedded struct {
+	Jobs []Job `json:"larch:job"`
+}
+
+type ScheduledSnapshotLinks struct {
+	Curies []Link `json:"curies"`
+	Self   Link   `json:"self"`
+	Origin Link   `json:"larch:origin"`
+	Jobs   []Link `json:"larch:job"`
+}
+
+type Job struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Status    string    `json:"status"`
+	Requested time.Time `json:"requested,omitzero"`
+	Accepted  time.Time `json:"accepted,omitzero"`
+	Started   time.Time `json:"started,omitzero"`
+	Ended     time.Time `json:"ended,omitzero"`
+	Error     string    `json:"error,omitempty"`
+	// Library is the library the job writes to.
+	Library string `json:"library"`
+	// Replicas holds the libraries the job's writes are replicated to.
+	Replicas []Replica `json:"replicas"`
+	Links    JobLinks  `json:"_links"`
+}
+
+type Replica struct {
+	Library string `json:"library"`
+	// Status is either "ok" or "failed". Failed replicas may be retried.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type JobLinks struct {
+	Curies   []Link `json:"curies"`
+	Self     Link   `json:"self"`
+	Snapshot Link   `json:"larch:snapshot"`
+}
+
+type CollectGarbageRequest struct {
+	// DryRun reports collectable blobs without removing them.
+	DryRun bool `json:"dryRun,omitempty"`
+	// GracePeriod is the minimum age of unreferenced blobs to collect, such as
+	// 24h. Defaults to [libraries.DefaultGracePeriod]. Must be at least
+	// [libraries.MinGracePeriod], unless it's a dry run.
+	GracePeriod string `json:"gracePeriod,omitempty"`
+}
+
+type GarbageCollection struct {
+	Library        string   `json:"library"`
+	DryRun         bool     `json:"dryRun"`
+	Blobs          int      `json:"blobs"`
+	Referenced     int      `json:"referenced"`
+	Collected      []string `json:"collected"`
+	CollectedBytes int64    `json:"collectedBytes"`
+}
+
+type Deletion struct {
+	Origin string `json:"origin"`
+	// Snapshots holds the deleted snapshots, by library.
+	Snapshots map[string][]string `json:"snapshots"`
+}
+
+type LibraryUsage struct {
+	Library string `json:"library"`
+	// Bytes is the number of bytes stored, with blobs shared between snapshots
+	// counted once.
+	Bytes int64 `json:"bytes"`
+	// LogicalBytes is the size of all snapshots' artifacts, as if no blobs were
+	// shared.
+	LogicalBytes int64 `json:"logicalBytes"`
+	Blobs        int   `json:"blobs"`
+	Snapshots    int   `json:"snapshots"`
+	// Origins holds the logical bytes of snapshots, by origin.
+	Origins           map[string]int64 `json:"origins"`
+	SoftQuota         int64            `json:"softQuota,omitempty"`
+	HardQuota         int64            `json:"hardQuota,omitempty"`
+	SoftQuotaExceeded bool             `json:"softQuotaExceeded"`
+	HardQuotaExceeded bool             `json:"hardQuotaExceeded"`
+	// Updated is the time the usage was last computed.
+	Updated time.Time `json:"updated"`
+}
@@ -1,26 +1,206 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/AlexGustafsson/larch/internal/indexers"
 	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/quota"
+	"github.com/AlexGustafsson/larch/internal/retention"
+	"github.com/AlexGustafsson/larch/internal/rules"
+	"github.com/AlexGustafsson/larch/internal/worker"
 )
 
+// scheduleTimeout is the time to wait for a snapshot to be scheduled, such as
+// when the job queue is full.
+var scheduleTimeout = 10 * time.Second
+
 type Server struct {
 	mux *http.ServeMux
 }
 
-func NewServer(index indexers.Indexer, libraryReaders map[string]libraries.LibraryReader) *Server {
+func NewServer(index indexers.Indexer, libraryReaders map[string]libraries.LibraryReader, libraryWriters map[string]libraries.LibraryWriter, scheduler *worker.Scheduler, strategies map[string]worker.Strategy, rewriter *rules.Rewriter, router *rules.Router, deleter *retention.Deleter, quotas *quota.Tracker, admin bool) *Server {
 	mux := http.NewServeMux()
 
+	// The API is unauthenticated, endpoints deleting content must be enabled
+	adminOnly := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !admin {
+				http.Error(w, "admin endpoints are disabled", http.StatusForbidden)
+				return
+			}
+
+			handler(w, r)
+		}
+	}
+
+	mux.HandleFunc("POST /api/v1/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		// TODO: Auth
+
+		var request CreateSnapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		u, err := url.Parse(request.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "invalid url", http.StatusBadRequest)
+			return
+		}
+
+		snapshotURL := u.String()
+		if rewriter != nil {
+			snapshotURL, err = rewriter.Rewrite(snapshotURL)
+			if err != nil {
+				slog.Error("Failed to rewrite url", slog.Any("error", err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Route the URL using the configured rules unless a strategy is explicitly
+		// requested
+		strategyID := request.Strategy
+		libraryID := ""
+		if strategyID == "" && router != nil {
+			route, err := router.Route(r.Context(), snapshotURL, "")
+			if err != nil {
+				slog.Error("Failed to route snapshot", slog.Any("error", err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			strategyID = route.Strategy
+			libraryID = route.Library
+		}
+
+		strategy, ok := strategies[strategyID]
+		if !ok {
+			http.Error(w, "invalid strategy", http.StatusBadRequest)
+			return
+		}
+
+		if libraryID != "" {
+			strategy.Libraries = []string{libraryID}
+		}
+
+		// Don't wait for long if the job queue is full
+		ctx, cancel := context.WithTimeout(r.Context(), scheduleTimeout)
+		defer cancel()
+
+		scheduled, err := scheduler.ScheduleSnapshot(ctx, snapshotURL, &strategy, &worker.ScheduleSnapshotOptions{
+			OriginalURL: request.URL,
+		})
+		// The refused jobs are returned, to make the refusal visible
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			slog.Warn("Refused to schedule snapshot", slog.Any("error", err))
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			slog.Error("Failed to schedule snapshot", slog.Any("error", err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		embeddedJobs := make([]Job, 0)
+		jobLinks := make([]Link, 0)
+		for _, job := range scheduled.Jobs {
+			embeddedJobs = append(embeddedJobs, newJob(job, scheduler.GetReplicas(job.Library, job.Origin, job.SnapshotID)))
+			jobLinks = append(jobLinks, Link{
+				Href: fmt.Sprintf("/api/v1/jobs/%s", job.ID),
+			})
+		}
+
+		res := ScheduledSnapshot{
+			ID:     scheduled.SnapshotID,
+			URL:    scheduled.URL,
+			Origin: scheduled.Origin,
+			Embedded: ScheduledSnapshotEmbedded{
+				Jobs: embeddedJobs,
+			},
+			Links: ScheduledSnapshotLinks{
+				Curies: []Link{
+					{
+						Href:      "https://github.com/AlexGustafsson/larch/blob/main/docs/api.md#{rel}",
+						Name:      "larch",
+						Templated: true,
+					},
+				},
+				Self: Link{
+					Href: fmt.Sprintf("/api/v1/snapshots/%s/%s", scheduled.Origin, scheduled.SnapshotID),
+				},
+				Origin: Link{
+					Href: fmt.Sprintf("/api/v1/snapshots/%s", scheduled.Origin),
+				},
+				Jobs: jobLinks,
+			},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if exceeded != nil {
+			w.WriteHeader(http.StatusInsufficientStorage)
+		} else {
+			w.Header().Set("Location", res.Links.Self.Href)
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+
+	mux.HandleFunc("GET /api/v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		job, err := scheduler.GetJob(r.Context(), id)
+		if err == worker.ErrNotFound {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newJob(*job, scheduler.GetReplicas(job.Library, job.Origin, job.SnapshotID)))
+	})
+
+	// Retry writing the job's snapshot to replicas that failed, without
+	// re-running the job
+	mux.HandleFunc("POST /api/v1/jobs/{id}/retry", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		job, err := scheduler.GetJob(r.Context(), id)
+		if err == worker.ErrNotFound {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		// Replicas still failing are part of the response
+		if err := scheduler.RetryReplicas(r.Context(), job.Library, job.Origin, job.SnapshotID); err != nil {
+			slog.Warn("Failed to retry replicas", slog.String("jobId", job.ID), slog.Any("error", err))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newJob(*job, scheduler.GetReplicas(job.Library, job.Origin, job.SnapshotID)))
+	})
+
 	mux.HandleFunc("GET /api/v1/snapshots", func(w http.ResponseWriter, r *http.Request) {
 		snapshots, err := index.ListSnapshots(r.Context(), nil)
 		if err != nil {
@@ -326,6 +506,86 @@ func NewServer(index indexers.Indexer, libraryReaders map[string]libraries.Libra
 		json.NewEncoder(w).Encode(res)
 	})
 
+	// Delete a snapshot from all writable libraries storing it
+	mux.HandleFunc("DELETE /api/v1/snapshots/{origin}/{id}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.PathValue("origin")
+		id := r.PathValue("id")
+
+		res := Deletion{
+			Origin:    origin,
+			Snapshots: make(map[string][]string),
+		}
+
+		stored := false
+		for libraryID, library := range libraryReaders {
+			snapshotReader, err := library.ReadSnapshot(r.Context(), origin, id)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				slog.Warn("Failed to read snapshot", slog.String("library", libraryID), slog.Any("error", err))
+				continue
+			}
+			snapshotReader.Close()
+			stored = true
+
+			if _, ok := libraryWriters[libraryID]; !ok {
+				continue
+			}
+
+			err = deleter.DeleteSnapshot(r.Context(), libraryID, origin, id, "api")
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				slog.Error("Failed to delete snapshot", slog.Any("error", err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			res.Snapshots[libraryID] = []string{id}
+		}
+
+		if !stored {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if len(res.Snapshots) == 0 {
+			http.Error(w, "snapshot is only stored in read-only libraries", http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}))
+
+	// Delete all snapshots of an origin from all writable libraries
+	mux.HandleFunc("DELETE /api/v1/snapshots/{origin}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.PathValue("origin")
+
+		res := Deletion{
+			Origin:    origin,
+			Snapshots: make(map[string][]string),
+		}
+
+		for libraryID := range libraryWriters {
+			deleted, err := deleter.DeleteOrigin(r.Context(), libraryID, origin, "api")
+			if len(deleted) > 0 {
+				res.Snapshots[libraryID] = deleted
+			}
+			if err != nil {
+				slog.Error("Failed to delete origin", slog.String("library", libraryID), slog.Any("error", err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if len(res.Snapshots) == 0 {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}))
+
 	mux.HandleFunc("GET /api/v1/snapshots/{origin}/{id}/artifacts", func(w http.ResponseWriter, r *http.Request) {
 		origin := r.PathValue("origin")
 		id := r.PathValue("id")
@@ -490,12 +750,38 @@ func NewServer(index indexers.Indexer, libraryReaders map[string]libraries.Libra
 			return
 		}
 
+		// TODO: Which library to pick? Prioritize read speed?
+		libraryID := blob.Libraries[0]
+		library, ok := libraryReaders[libraryID]
+		if !ok {
+			slog.Error("Failed to find a library for blob")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
 		w.Header().Set("Content-Type", blob.ContentType)
-		w.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
-		// TODO: Content encoding, if Accept supports the content encoding, just
-		// provide it, otherwise we'll need to decompress, decrypt etc.
+		w.Header().Set("Vary", "Accept-Encoding")
 		// TODO: Date, cache headers
 		// TODO: Content-Digest?
+
+		// Describe the blob as GET requests would serve it. Encoded blobs are
+		// served without a known length
+		if encodedLibrary, ok := library.(libraries.EncodedLibraryReader); ok {
+			encodedReader, contentEncoding, err := encodedLibrary.ReadEncodedArtifact(r.Context(), digest)
+			if err != nil {
+				slog.Error("Failed to read blob", slog.Any("error", err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			encodedReader.Close()
+
+			if contentEncoding != "" && acceptsEncoding(r.Header.Get("Accept-Encoding"), contentEncoding) {
+				w.Header().Set("Content-Encoding", contentEncoding)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
 	})
 
 	mux.HandleFunc("GET /api/v1/blobs/{algorithm}/{digest}", func(w http.ResponseWriter, r *http.Request) {
@@ -526,6 +812,44 @@ func NewServer(index indexers.Indexer, libraryReaders map[string]libraries.Libra
 			return
 		}
 
+		// Let the client read the blob directly from the library, if supported
+		if redirectLibrary, ok := library.(libraries.RedirectLibraryReader); ok {
+			url, err := redirectLibrary.ArtifactURL(r.Context(), digest, blob.ContentType)
+			if err != nil {
+				slog.Error("Failed to get blob URL", slog.Any("error", err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			if url != "" {
+				http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", blob.ContentType)
+		w.Header().Set("Vary", "Accept-Encoding")
+
+		// Serve the blob as stored if the client supports its encoding, otherwise
+		// decode it
+		if encodedLibrary, ok := library.(libraries.EncodedLibraryReader); ok {
+			encodedReader, contentEncoding, err := encodedLibrary.ReadEncodedArtifact(r.Context(), digest)
+			if err != nil {
+				slog.Error("Failed to read blob", slog.Any("error", err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			if contentEncoding != "" && acceptsEncoding(r.Header.Get("Accept-Encoding"), contentEncoding) {
+				w.Header().Set("Content-Encoding", contentEncoding)
+				_, _ = io.Copy(w, encodedReader)
+				encodedReader.Close()
+				return
+			}
+
+			encodedReader.Close()
+		}
+
 		// TODO: Support multiple libraries
 		blobReader, err := library.ReadArtifact(r.Context(), digest)
 		if err != nil {
@@ -534,10 +858,7 @@ func NewServer(index indexers.Indexer, libraryReaders map[string]libraries.Libra
 			return
 		}
 
-		w.Header().Set("Content-Type", blob.ContentType)
 		w.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
-		// TODO: Content encoding, if Accept supports the content encoding, just
-		// provide it, otherwise we'll need to decompress, decrypt etc.
 		// TODO: Date, cache headers
 		// TODO: Content-Digest?
 		if _, err := io.Copy(w, blobReader); err != nil {
@@ -555,11 +876,206 @@ func NewServer(index indexers.Indexer, libraryReaders map[string]libraries.Libra
 		}
 	})
 
+	mux.HandleFunc("POST /api/v1/libraries/{library}/gc", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		libraryID := r.PathValue("library")
+
+		var request CollectGarbageRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		gracePeriod := libraries.DefaultGracePeriod
+		if request.GracePeriod != "" {
+			var err error
+			gracePeriod, err = time.ParseDuration(request.GracePeriod)
+			if err != nil || gracePeriod < 0 {
+				http.Error(w, "invalid grace period", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Blobs of snapshots being written are not yet referenced
+		if gracePeriod < libraries.MinGracePeriod && !request.DryRun {
+			http.Error(w, fmt.Sprintf("grace period must be at least %s", libraries.MinGracePeriod), http.StatusBadRequest)
+			return
+		}
+
+		library, ok := libraryReaders[libraryID]
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		// Read-only libraries may only be inspected
+		if _, ok := libraryWriters[libraryID]; !ok && !request.DryRun {
+			http.Error(w, "library is read-only", http.StatusConflict)
+			return
+		}
+
+		collector, ok := library.(libraries.GarbageCollector)
+		if !ok {
+			http.Error(w, "library does not support garbage collection", http.StatusNotImplemented)
+			return
+		}
+
+		report, err := collector.CollectGarbage(r.Context(), libraries.GarbageCollectionOptions{
+			GracePeriod: gracePeriod,
+			DryRun:      request.DryRun,
+		})
+		if err != nil {
+			slog.Error("Failed to collect garbage", slog.Any("error", err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("Collected garbage", slog.String("library", libraryID), slog.Bool("dryRun", report.DryRun), slog.Int("blobs", len(report.Collected)), slog.Int64("bytes", report.CollectedBytes))
+
+		// Collected blobs no longer count towards the library's usage
+		if quotas != nil && !report.DryRun && len(report.Collected) > 0 {
+			if err := quotas.RefreshLibrary(r.Context(), libraryID); err != nil {
+				slog.Warn("Failed to compute library usage after collecting garbage", slog.String("library", libraryID), slog.Any("error", err))
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GarbageCollection{
+			Library:        libraryID,
+			DryRun:         report.DryRun,
+			Blobs:          report.Blobs,
+			Referenced:     report.Referenced,
+			Collected:      report.Collected,
+			CollectedBytes: report.CollectedBytes,
+		})
+	}))
+
+	mux.HandleFunc("GET /api/v1/libraries/{library}/usage", func(w http.ResponseWriter, r *http.Request) {
+		libraryID := r.PathValue("library")
+
+		if _, ok := libraryReaders[libraryID]; !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		if quotas == nil {
+			http.Error(w, "usage is not tracked", http.StatusNotImplemented)
+			return
+		}
+
+		// Compute the usage if not yet computed, such as right after startup
+		status, ok := quotas.Status(libraryID)
+		if !ok {
+			if err := quotas.RefreshLibrary(r.Context(), libraryID); err != nil {
+				slog.Error("Failed to compute library usage", slog.Any("error", err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			status, _ = quotas.Status(libraryID)
+		}
+
+		res := LibraryUsage{
+			Library:           libraryID,
+			Bytes:             status.Usage.Bytes,
+			LogicalBytes:      status.Usage.LogicalBytes,
+			Blobs:             status.Usage.Blobs,
+			Snapshots:         status.Usage.Snapshots,
+			Origins:           status.Usage.Origins,
+			SoftQuota:         status.Quota.Soft,
+			HardQuota:         status.Quota.Hard,
+			SoftQuotaExceeded: status.SoftQuotaExceeded(),
+			HardQuotaExceeded: status.HardQuotaExceeded(),
+			Updated:           status.Updated,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+
 	return &Server{
 		mux: mux,
 	}
 }
 
+// acceptsEncoding returns whether or not an Accept-Encoding header value
+// accepts the content encoding.
+//
+// SEE: https://www.rfc-editor.org/rfc/rfc9110#name-accept-encoding.
+func acceptsEncoding(header string, contentEncoding string) bool {
+	accepted := false
+	for value := range strings.SplitSeq(header, ",") {
+		coding, params, _ := strings.Cut(value, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != contentEncoding && coding != "*" {
+			continue
+		}
+
+		// A weight of 0 means "not acceptable"
+		weight := 1.0
+		for param := range strings.SplitSeq(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if key == "q" {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					weight = q
+				}
+			}
+		}
+
+		// An explicit coding takes precedence over the wildcard
+		if coding == contentEncoding {
+			return weight > 0
+		}
+		accepted = weight > 0
+	}
+
+	return accepted
+}
+
+func newJob(job worker.Job, replicas []worker.Replica) Job {
+	res := Job{
+		ID:        job.ID,
+		URL:       job.URL,
+		Status:    job.Status,
+		Requested: job.Requested,
+		Accepted:  job.Accepted,
+		Started:   job.Started,
+		Ended:     job.Ended,
+		Error:     job.Error,
+		Library:   job.Library,
+		Replicas:  make([]Replica, 0),
+		Links: JobLinks{
+			Curies: []Link{
+				{
+					Href:      "https://github.com/AlexGustafsson/larch/blob/main/docs/api.md#{rel}",
+					Name:      "larch",
+					Templated: true,
+				},
+			},
+			Self: Link{
+				Href: fmt.Sprintf("/api/v1/jobs/%s", job.ID),
+			},
+			Snapshot: Link{
+				Href: fmt.Sprintf("/api/v1/snapshots/%s/%s", job.Origin, job.SnapshotID),
+			},
+		},
+	}
+
+	for _, replica := range replicas {
+		status := "ok"
+		if replica.Error != "" {
+			status = "failed"
+		}
+
+		res.Replicas = append(res.Replicas, Replica{
+			Library: replica.Library,
+			Status:  status,
+			Error:   replica.Error,
+		})
+	}
+
+	return res
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/indexers"
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/libraries/disk"
+	"github.com/AlexGustafsson/larch/internal/quota"
+	"github.com/AlexGustafsson/larch/internal/retention"
+	"github.com/AlexGustafsson/larch/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	testCases := []struct {
+		Header   string
+		Expected bool
+	}{
+		{Header: "", Expected: false},
+		{Header: "gzip", Expected: true},
+		{Header: "gzip, deflate, br", Expected: true},
+		{Header: "br;q=1.0, gzip;q=0.8", Expected: true},
+		{Header: "gzip;q=0", Expected: false},
+		{Header: "*", Expected: true},
+		{Header: "*;q=0", Expected: false},
+		{Header: "*, gzip;q=0", Expected: false},
+		{Header: "identity", Expected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Header, func(t *testing.T) {
+			assert.Equal(t, testCase.Expected, acceptsEncoding(testCase.Header, "gzip"))
+		})
+	}
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	library, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": library}
+	libraryWriters := map[string]libraries.LibraryWriter{"local": library}
+
+	index := indexers.NewInMemoryIndex()
+	scheduler := worker.NewScheduler(index, libraryReaders, libraryWriters, nil, nil)
+	strategies := map[string]worker.Strategy{
+		"default": {
+			ID:        "default",
+			Libraries: []string{"local"},
+			Archivers: []worker.Archiver{{OpenGraphArchiver: &worker.OpenGraphArchiver{}}},
+		},
+	}
+
+	server := httptest.NewServer(NewServer(index, libraryReaders, libraryWriters, scheduler, strategies, nil, nil, nil, nil, false))
+	defer server.Close()
+
+	client := &Client{Endpoint: server.URL}
+
+	scheduled, err := client.CreateSnapshot(context.TODO(), "https://example.com/page", "default")
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://example.com/page", scheduled.URL)
+	assert.Equal(t, "example.com", scheduled.Origin)
+	require.Len(t, scheduled.Embedded.Jobs, 1)
+	assert.Equal(t, "requested", scheduled.Embedded.Jobs[0].Status)
+	assert.Equal(t, "local", scheduled.Embedded.Jobs[0].Library)
+
+	// The scheduled snapshot is written and indexed right away
+	snapshot, err := client.GetSnapshot(context.TODO(), scheduled.Origin, scheduled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, scheduled.ID, snapshot.ID)
+
+	// Jobs can be followed
+	res, err := http.Get(server.URL + scheduled.Links.Jobs[0].Href)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var job Job
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&job))
+	assert.Equal(t, scheduled.Embedded.Jobs[0].ID, job.ID)
+	assert.Equal(t, "requested", job.Status)
+
+	res, err = http.Get(server.URL + "/api/v1/jobs/unknown")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// Invalid requests are rejected
+	_, err = client.CreateSnapshot(context.TODO(), "file:///etc/passwd", "default")
+	assert.ErrorContains(t, err, "400")
+
+	_, err = client.CreateSnapshot(context.TODO(), "https://example.com", "unknown")
+	assert.ErrorContains(t, err, "400")
+}
+
+func TestCreateSnapshotQueueFull(t *testing.T) {
+	library, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": library}
+	libraryWriters := map[string]libraries.LibraryWriter{"local": library}
+
+	index := indexers.NewInMemoryIndex()
+	scheduler := worker.NewScheduler(index, libraryReaders, libraryWriters, nil, nil)
+
+	// Fill the queue, without any worker taking jobs
+	archivers := make([]worker.Archiver, 32)
+	for i := range archivers {
+		archivers[i] = worker.Archiver{OpenGraphArchiver: &worker.OpenGraphArchiver{}}
+	}
+	strategies := map[string]worker.Strategy{
+		"default": {
+			Libraries: []string{"local"},
+			Archivers: archivers,
+		},
+	}
+	strategy := strategies["default"]
+	_, err = scheduler.ScheduleSnapshot(context.TODO(), "https://example.com", &strategy, nil)
+	require.NoError(t, err)
+
+	timeout := scheduleTimeout
+	scheduleTimeout = 10 * time.Millisecond
+	defer func() { scheduleTimeout = timeout }()
+
+	server := httptest.NewServer(NewServer(index, libraryReaders, libraryWriters, scheduler, strategies, nil, nil, nil, nil, false))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/api/v1/snapshots", "application/json", strings.NewReader(`{"url": "https://example.org", "strategy": "default"}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.NotEmpty(t, res.Header.Get("Retry-After"))
+
+	// Nothing is written unless there's room for the snapshot's jobs
+	origins, err := library.GetOrigins(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, origins)
+
+	snapshots, err := index.ListSnapshots(context.TODO(), &indexers.ListSnapshotsOptions{Origin: "example.org"})
+	require.NoError(t, err)
+	assert.Empty(t, snapshots)
+}
+
+func TestCreateSnapshotOverQuota(t *testing.T) {
+	library, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": library}
+	libraryWriters := map[string]libraries.LibraryWriter{"local": library}
+
+	quotas := quota.NewTracker(libraryReaders, map[string]quota.Quota{"local": {Hard: 1}})
+	require.NoError(t, quotas.Refresh(context.TODO()))
+	quotas.Add("local", 1)
+
+	index := indexers.NewInMemoryIndex()
+	scheduler := worker.NewScheduler(index, libraryReaders, libraryWriters, quotas, nil)
+	strategies := map[string]worker.Strategy{
+		"default": {
+			Libraries: []string{"local"},
+			Archivers: []worker.Archiver{{OpenGraphArchiver: &worker.OpenGraphArchiver{}}},
+		},
+	}
+
+	server := httptest.NewServer(NewServer(index, libraryReaders, libraryWriters, scheduler, strategies, nil, nil, nil, quotas, false))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/api/v1/snapshots", "application/json", strings.NewReader(`{"url": "https://example.com", "strategy": "default"}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusInsufficientStorage, res.StatusCode)
+
+	// The refused jobs are returned
+	var scheduled ScheduledSnapshot
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&scheduled))
+	require.Len(t, scheduled.Embedded.Jobs, 1)
+	assert.Equal(t, "refused", scheduled.Embedded.Jobs[0].Status)
+	assert.Contains(t, scheduled.Embedded.Jobs[0].Error, "over its hard quota")
+}
+
+func TestBlobEncoding(t *testing.T) {
+	library, err := disk.NewLibrary(t.TempDir(), &disk.LibraryOptions{ContentEncoding: "zstd"})
+	require.NoError(t, err)
+	defer library.Close()
+
+	snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", "1")
+	require.NoError(t, err)
+
+	data := []byte(strings.Repeat("<p>Hello, World!</p>\n", 1000))
+	size, digest, err := snapshotWriter.WriteArtifact(context.TODO(), "singlefile.html", data)
+	require.NoError(t, err)
+	require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+		ContentType: "text/html",
+		Digest:      digest,
+		Size:        size,
+	}))
+	require.NoError(t, snapshotWriter.Close())
+
+	index := indexers.NewInMemoryIndex()
+	snapshotReader, err := library.ReadSnapshot(context.TODO(), "example.com", "1")
+	require.NoError(t, err)
+	require.NoError(t, index.IndexSnapshot(context.TODO(), "local", "example.com", "1", snapshotReader))
+	snapshotReader.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": library}
+	server := httptest.NewServer(NewServer(index, libraryReaders, nil, nil, nil, nil, nil, nil, nil, false))
+	defer server.Close()
+
+	blobURL := server.URL + "/api/v1/blobs/" + strings.Replace(digest, ":", "/", 1)
+
+	testCases := []struct {
+		Name                    string
+		Method                  string
+		AcceptEncoding          string
+		ExpectedContentEncoding string
+		ExpectedContentLength   int64
+	}{
+		{Name: "HEAD, encoded", Method: http.MethodHead, AcceptEncoding: "zstd, gzip", ExpectedContentEncoding: "zstd", ExpectedContentLength: -1},
+		{Name: "HEAD, decoded", Method: http.MethodHead, AcceptEncoding: "gzip", ExpectedContentLength: size},
+		// The length of small responses is set by the server
+		{Name: "GET, encoded", Method: http.MethodGet, AcceptEncoding: "zstd", ExpectedContentEncoding: "zstd"},
+		{Name: "GET, decoded", Method: http.MethodGet, AcceptEncoding: "identity", ExpectedContentLength: size},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			req, err := http.NewRequest(testCase.Method, blobURL, nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept-Encoding", testCase.AcceptEncoding)
+
+			res, err := http.DefaultTransport.RoundTrip(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, "text/html", res.Header.Get("Content-Type"))
+			assert.Equal(t, testCase.ExpectedContentEncoding, res.Header.Get("Content-Encoding"))
+			if testCase.ExpectedContentLength != 0 {
+				assert.Equal(t, testCase.ExpectedContentLength, res.ContentLength)
+			}
+		})
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	library, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": library}
+	libraryWriters := map[string]libraries.LibraryWriter{"local": library}
+
+	testCases := []struct {
+		Name     string
+		Admin    bool
+		Body     string
+		Expected int
+	}{
+		{Name: "Disabled", Body: `{}`, Expected: http.StatusForbidden},
+		{Name: "Default grace period", Admin: true, Body: `{}`, Expected: http.StatusOK},
+		{Name: "Zero grace period", Admin: true, Body: `{"gracePeriod":"0s"}`, Expected: http.StatusBadRequest},
+		{Name: "Zero grace period, dry run", Admin: true, Body: `{"gracePeriod":"0s","dryRun":true}`, Expected: http.StatusOK},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			server := httptest.NewServer(NewServer(indexers.NewInMemoryIndex(), libraryReaders, libraryWriters, nil, nil, nil, nil, nil, nil, testCase.Admin))
+			defer server.Close()
+
+			res, err := http.Post(server.URL+"/api/v1/libraries/local/gc", "application/json", strings.NewReader(testCase.Body))
+			require.NoError(t, err)
+			res.Body.Close()
+
+			assert.Equal(t, testCase.Expected, res.StatusCode)
+		})
+	}
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Admin     bool
+		Path      string
+		Expected  int
+		Remaining []string
+	}{
+		{Name: "Disabled", Path: "/api/v1/snapshots/example.com/1", Expected: http.StatusForbidden, Remaining: []string{"1", "2"}},
+		{Name: "Disabled origin", Path: "/api/v1/snapshots/example.com", Expected: http.StatusForbidden, Remaining: []string{"1", "2"}},
+		{Name: "Snapshot", Admin: true, Path: "/api/v1/snapshots/example.com/1", Expected: http.StatusOK, Remaining: []string{"2"}},
+		{Name: "Missing snapshot", Admin: true, Path: "/api/v1/snapshots/example.com/3", Expected: http.StatusNotFound, Remaining: []string{"1", "2"}},
+		{Name: "Origin", Admin: true, Path: "/api/v1/snapshots/example.com", Expected: http.StatusOK, Remaining: []string{}},
+		{Name: "Missing origin", Admin: true, Path: "/api/v1/snapshots/example.org", Expected: http.StatusNotFound, Remaining: []string{"1", "2"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			library, err := disk.NewLibrary(t.TempDir(), nil)
+			require.NoError(t, err)
+			defer library.Close()
+
+			for _, id := range []string{"1", "2"} {
+				snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", id)
+				require.NoError(t, err)
+				require.NoError(t, snapshotWriter.Close())
+			}
+
+			libraryReaders := map[string]libraries.LibraryReader{"local": library}
+			libraryWriters := map[string]libraries.LibraryWriter{"local": library}
+			deleter := &retention.Deleter{LibraryReaders: libraryReaders, LibraryWriters: libraryWriters}
+
+			server := httptest.NewServer(NewServer(indexers.NewInMemoryIndex(), libraryReaders, libraryWriters, nil, nil, nil, nil, deleter, nil, testCase.Admin))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodDelete, server.URL+testCase.Path, nil)
+			require.NoError(t, err)
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+
+			assert.Equal(t, testCase.Expected, res.StatusCode)
+
+			// Nothing is deleted unless enabled
+			snapshots, err := library.GetSnapshots(context.TODO(), "example.com")
+			if len(testCase.Remaining) > 0 {
+				require.NoError(t, err)
+			}
+			assert.ElementsMatch(t, testCase.Remaining, snapshots)
+		})
+	}
+}
+
+func TestLibraryUsage(t *testing.T) {
+	library, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": library}
+	libraryWriters := map[string]libraries.LibraryWriter{"local": library}
+
+	testCases := []struct {
+		Name     string
+		Quotas   *quota.Tracker
+		Library  string
+		Expected int
+	}{
+		{Name: "Usage", Quotas: quota.NewTracker(libraryReaders, nil), Library: "local", Expected: http.StatusOK},
+		{Name: "Missing library", Quotas: quota.NewTracker(libraryReaders, nil), Library: "missing", Expected: http.StatusNotFound},
+		{Name: "Not tracked", Library: "local", Expected: http.StatusNotImplemented},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			server := httptest.NewServer(NewServer(indexers.NewInMemoryIndex(), libraryReaders, libraryWriters, nil, nil, nil, nil, nil, testCase.Quotas, false))
+			defer server.Close()
+
+			res, err := http.Get(server.URL + "/api/v1/libraries/" + testCase.Library + "/usage")
+			require.NoError(t, err)
+			res.Body.Close()
+
+			assert.Equal(t, testCase.Expected, res.StatusCode)
+		})
+	}
+}
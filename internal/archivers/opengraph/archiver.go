@@ -16,6 +16,10 @@ import (
 
 var _ archivers.Archiver = (*Archiver)(nil)
 
+// maxImageSize is the maximum size of an image to store. Images are read into
+// memory.
+const maxImageSize = 10 << 20
+
 type Archiver struct {
 }
 
@@ -106,6 +110,13 @@ func (a *Archiver) Archive(ctx context.Context, snapshotWriter libraries.Snapsho
 			return err
 		}
 
+		mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		if !strings.HasPrefix(mediaType, "image/") {
+			slog.Debug("Skipping OpenGraph image as URL doesn't seem to point to an image", slog.String("contentType", res.Header.Get("Content-Type")))
+			res.Body.Close()
+			continue
+		}
+
 		extensions, _ := mime.ExtensionsByType(res.Header.Get("Content-Type"))
 
 		extension := ""
@@ -113,22 +124,24 @@ func (a *Archiver) Archive(ctx context.Context, snapshotWriter libraries.Snapsho
 			extension = extensions[0]
 		}
 
-		artifactWriter, err := snapshotWriter.NextArtifactWriter(ctx, "opengraph/image"+extension)
+		// Images are commonly shared between pages and snapshots. Read the image
+		// into memory so that it need not be written if it's already stored
+		image, err := io.ReadAll(io.LimitReader(res.Body, maxImageSize+1))
+		res.Body.Close()
 		if err != nil {
-			res.Body.Close()
 			return err
 		}
 
-		size, err := io.Copy(artifactWriter, res.Body)
-		res.Body.Close()
+		if len(image) > maxImageSize {
+			slog.Debug("Skipping OpenGraph image as it's too large", slog.String("url", imageURL))
+			continue
+		}
 
-		err = artifactWriter.Close()
+		size, digest, err := snapshotWriter.WriteArtifact(ctx, "opengraph/image"+extension, image)
 		if err != nil {
 			return err
 		}
 
-		digest := artifactWriter.Digest()
-
 		err = snapshotWriter.WriteArtifactManifest(ctx, libraries.ArtifactManifest{
 			Digest:      digest,
 			ContentType: res.Header.Get("Content-Type"),
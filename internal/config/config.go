@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"path"
+	"regexp"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,6 +19,10 @@ func Read(r io.Reader) (*Config, error) {
 		return nil, err
 	}
 
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
 
@@ -28,3 +35,68 @@ func ReadFile(name string) (*Config, error) {
 
 	return Read(file)
 }
+
+// validate returns an error for options which would otherwise only fail once
+// used.
+func (c *Config) validate() error {
+	for i, source := range c.Sources {
+		name := source.Name
+		if name == "" {
+			name = fmt.Sprintf("%s#%d", source.Type, i)
+		}
+
+		switch source.Type {
+		case "json":
+			if source.Options == nil {
+				continue
+			}
+
+			var options JSONSourceOptions
+			if err := source.Options.As(&options); err != nil {
+				return fmt.Errorf("invalid source %s: %w", name, err)
+			}
+
+			if pagination := options.Pagination; pagination != nil && pagination.Cursor != "" && pagination.CursorParameter == "" {
+				return fmt.Errorf("invalid source %s: pagination cursor requires cursorParameter", name)
+			}
+		}
+	}
+
+	for i, rule := range c.Rules {
+		if err := validateGlob(rule.Host); err != nil {
+			return fmt.Errorf("invalid rule #%d: invalid host pattern %q: %w", i, rule.Host, err)
+		}
+
+		if err := validateGlob(rule.ContentType); err != nil {
+			return fmt.Errorf("invalid rule #%d: invalid content type pattern %q: %w", i, rule.ContentType, err)
+		}
+
+		if _, err := regexp.Compile(rule.Path); err != nil {
+			return fmt.Errorf("invalid rule #%d: invalid path pattern: %w", i, err)
+		}
+	}
+
+	if c.Rewrite != nil {
+		for _, pattern := range c.Rewrite.StripParameters {
+			if err := validateGlob(pattern); err != nil {
+				return fmt.Errorf("invalid rewrite: invalid parameter pattern %q: %w", pattern, err)
+			}
+		}
+
+		for _, rewrite := range c.Rewrite.Rewrites {
+			if _, err := regexp.Compile(rewrite.Pattern); err != nil {
+				return fmt.Errorf("invalid rewrite: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateGlob returns an error if the pattern is not a valid pattern, as
+// supported by [path.Match].
+func validateGlob(pattern string) error {
+	// Malformed patterns are detected even if they don't match
+	_, err := path.Match(pattern, "")
+	return err
+}
@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestRead(t *testing.T) {
@@ -28,3 +31,151 @@ func TestRead(t *testing.T) {
 	v, _ := json.MarshalIndent(config, "", "  ")
 	fmt.Printf("%s\n", v)
 }
+
+func TestDiskLibraryOptionsContentEncoding(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Options  string
+		Expected string
+	}{
+		{Name: "None", Options: "path: /var/data/disk", Expected: ""},
+		{Name: "Encoding", Options: "encoding: zstd", Expected: "zstd"},
+		{Name: "Compress", Options: "compress: true", Expected: "gzip"},
+		{Name: "Encoding takes precedence", Options: "compress: true\nencoding: br", Expected: "br"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var options DiskLibraryOptions
+			require.NoError(t, yaml.Unmarshal([]byte(testCase.Options), &options))
+			assert.Equal(t, testCase.Expected, options.ContentEncoding())
+		})
+	}
+}
+
+func TestReadOPML(t *testing.T) {
+	file, err := os.Open("testdata/subscriptions.opml")
+	require.NoError(t, err)
+	defer file.Close()
+
+	sources, err := ReadOPML(file, "bookmark", 24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, sources, 3)
+
+	expected := []struct {
+		Name string
+		URL  string
+	}{
+		{Name: "Maurycy's blog", URL: "https://maurycyz.com/index.xml"},
+		{Name: "The Go Blog", URL: "https://go.dev/blog/feed.atom"},
+		{Name: "Example", URL: "https://example.com/feed.xml"},
+	}
+
+	for i, source := range sources {
+		assert.Equal(t, "feed", source.Type)
+		assert.Equal(t, "bookmark", source.Strategy)
+		assert.Equal(t, expected[i].Name, source.Name)
+		assert.Empty(t, source.Description)
+
+		var options FeedSourceOptions
+		require.NoError(t, source.Options.As(&options))
+		assert.Equal(t, expected[i].URL, options.URL)
+		assert.Equal(t, 24*time.Hour, options.Interval)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	testCases := []struct {
+		Value    string
+		Expected ByteSize
+		Error    bool
+	}{
+		{Value: "1024", Expected: 1024},
+		{Value: "1024B", Expected: 1024},
+		{Value: "512MB", Expected: 512e6},
+		{Value: "1.5 GiB", Expected: 1.5 * (1 << 30)},
+		{Value: "10TB", Expected: 10e12},
+		{Value: "-1", Error: true},
+		{Value: "ten", Error: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Value, func(t *testing.T) {
+			var actual ByteSize
+			err := yaml.Unmarshal([]byte(testCase.Value), &actual)
+			if testCase.Error {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, testCase.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Config string
+		Error  string
+	}{
+		{
+			Name: "JSON source cursor without parameter",
+			Config: `
+sources:
+  - type: json
+    name: bookmarks
+    strategy: default
+    options:
+      url: https://example.com/api/bookmarks
+      path: results[*].url
+      pagination:
+        cursor: next_cursor
+`,
+			Error: "cursorParameter",
+		},
+		{
+			Name: "Rule host pattern",
+			Config: `
+rules:
+  - host: "[a-"
+    strategy: default
+`,
+			Error: "invalid host pattern",
+		},
+		{
+			Name: "Rule content type pattern",
+			Config: `
+rules:
+  - contentType: "image/[png"
+    strategy: default
+`,
+			Error: "invalid content type pattern",
+		},
+		{
+			Name: "Rule path pattern",
+			Config: `
+rules:
+  - path: "^/(watch"
+    strategy: default
+`,
+			Error: "invalid path pattern",
+		},
+		{
+			Name: "Rewrite parameter pattern",
+			Config: `
+rewrite:
+  stripParameters:
+    - "utm_[*"
+`,
+			Error: "invalid parameter pattern",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(testCase.Config))
+			assert.ErrorContains(t, err, testCase.Error)
+		})
+	}
+}
@@ -1,15 +1,38 @@
 package config
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
+	State      *State              `yaml:"state,omitempty"`
 	Sources    []Source            `yaml:"sources"`
+	Rewrite    *Rewrite            `yaml:"rewrite,omitempty"`
+	Rules      []Rule              `yaml:"rules,omitempty"`
 	Strategies map[string]Strategy `yaml:"strategies"`
 	Libraries  map[string]Library  `yaml:"libraries"`
+	Mirrors    []Mirror            `yaml:"mirrors,omitempty"`
+	Retention  *Retention          `yaml:"retention,omitempty"`
+	API        *API                `yaml:"api,omitempty"`
+}
+
+// API configures the HTTP API.
+type API struct {
+	// Admin enables endpoints deleting content, such as deleting snapshots and
+	// collecting garbage. The API is unauthenticated, only enable them if the
+	// API is not reachable by untrusted clients.
+	Admin bool `yaml:"admin,omitempty"`
+}
+
+type State struct {
+	// Path is the directory in which to store state, such as what items sources
+	// have already seen.
+	Path string `yaml:"path"`
 }
 
 type Source struct {
@@ -21,7 +44,8 @@ type Source struct {
 }
 
 type URLSourceOptions struct {
-	URL string `yaml:"url"`
+	URL      string        `yaml:"url"`
+	Interval time.Duration `yaml:"interval,omitempty"`
 }
 
 type FeedSourceOptions struct {
@@ -29,11 +53,115 @@ type FeedSourceOptions struct {
 	Interval time.Duration `yaml:"interval"`
 }
 
+type NetscapeBookmarksSourceOptions struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+type SitemapSourceOptions struct {
+	URL      string        `yaml:"url"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+type OPMLSourceOptions struct {
+	Path string `yaml:"path"`
+	// Interval is the interval of each feed in the subscription list.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+type InboxSourceOptions struct {
+	Path string `yaml:"path"`
+	// Interval is the time between checking the directory for new files.
+	// Defaults to one minute.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+type MailSourceOptions struct {
+	// Path is the path to an mbox file or a Maildir directory.
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Include holds regular expressions of which at least one must match a URL
+	// for it to be included. If empty, all URLs are included.
+	Include []string `yaml:"include,omitempty"`
+	// Exclude holds regular expressions of which none may match a URL for it to
+	// be included.
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+type JSONSourceOptions struct {
+	URL      string        `yaml:"url"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Headers holds additional request headers, such as for authentication.
+	// Environment variables in values are expanded.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Path is an expression selecting the URLs of a response, such as
+	// results[*].url.
+	Path       string                       `yaml:"path"`
+	Pagination *JSONSourcePaginationOptions `yaml:"pagination,omitempty"`
+}
+
+type JSONSourcePaginationOptions struct {
+	// Next is an expression selecting the URL of the next page.
+	Next string `yaml:"next,omitempty"`
+	// Cursor is an expression selecting the cursor of the next page, sent as
+	// the CursorParameter query parameter.
+	Cursor          string `yaml:"cursor,omitempty"`
+	CursorParameter string `yaml:"cursorParameter,omitempty"`
+	// LinkHeader follows the next link of the Link header.
+	LinkHeader bool `yaml:"linkHeader,omitempty"`
+	MaxPages   int  `yaml:"maxPages,omitempty"`
+}
+
+// Rewrite configures how URLs are normalized and rewritten before they are
+// routed and scheduled.
+type Rewrite struct {
+	// StripParameters holds glob patterns of query parameters to remove, such
+	// as utm_*.
+	StripParameters []string `yaml:"stripParameters,omitempty"`
+	StripFragment   bool     `yaml:"stripFragment,omitempty"`
+	// StripHostPrefixes holds prefixes to remove from hosts, such as www.
+	StripHostPrefixes []string `yaml:"stripHostPrefixes,omitempty"`
+	// Rewrites are regular expression replacements applied in order.
+	Rewrites []URLRewrite `yaml:"rewrites,omitempty"`
+}
+
+type URLRewrite struct {
+	Pattern string `yaml:"pattern"`
+	// Replacement may refer to submatches of the pattern, such as $1.
+	Replacement string `yaml:"replacement"`
+}
+
+// Rule routes matching URLs to a strategy, no matter which source supplied
+// them. Rules are evaluated in order and the first matching rule wins. A rule
+// without conditions matches all URLs and can be used as a default. URLs not
+// matching any rule use their source's strategy.
+type Rule struct {
+	// Host is a glob pattern matched against the URL's host name, such as
+	// *.youtube.com.
+	Host string `yaml:"host,omitempty"`
+	// Path is a regular expression matched against the URL's path.
+	Path string `yaml:"path,omitempty"`
+	// ContentType is a glob pattern matched against the media type of the URL,
+	// such as application/pdf or image/*. Resolved using a HEAD request.
+	ContentType string `yaml:"contentType,omitempty"`
+	Strategy    string `yaml:"strategy"`
+	// Library overrides the strategy's library, if set.
+	Library string `yaml:"library,omitempty"`
+}
+
 type Strategy struct {
-	Name        string     `yaml:"name"`
-	Description string     `yaml:"description"`
-	Library     string     `yaml:"library"`
-	Archivers   []Archiver `yaml:"archivers"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Library is the library to write snapshots to. Shorthand for a single
+	// library in Libraries.
+	Library string `yaml:"library,omitempty"`
+	// Libraries holds libraries to write snapshots to. Workers write to the
+	// first library, writes are replicated to the other libraries.
+	Libraries []string   `yaml:"libraries,omitempty"`
+	Archivers []Archiver `yaml:"archivers"`
+	// Retention is the retention policy of snapshots scheduled using the
+	// strategy. Takes precedence over the policy of the library.
+	Retention *RetentionPolicy `yaml:"retention,omitempty"`
 }
 
 type Archiver struct {
@@ -65,12 +193,171 @@ type Library struct {
 	Name        string   `yaml:"name,omitempty"`
 	Description string   `yaml:"description,omitempty"`
 	Options     *RawNode `yaml:"options,omitempty"`
+	// Retention is the retention policy of the library's snapshots.
+	Retention *RetentionPolicy `yaml:"retention,omitempty"`
+	Quota     *Quota           `yaml:"quota,omitempty"`
+}
+
+// Quota limits the number of bytes stored in a library.
+type Quota struct {
+	// Soft is the number of bytes after which warnings are logged.
+	Soft ByteSize `yaml:"soft,omitempty"`
+	// Hard is the number of bytes after which no new snapshots are written to
+	// the library.
+	Hard ByteSize `yaml:"hard,omitempty"`
+}
+
+// ByteSize is a number of bytes, such as 1024, 512MB or 10GiB.
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	Suffix string
+	Size   int64
+}{
+	// Longer suffixes first
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	value := strings.TrimSpace(node.Value)
+
+	unit := int64(1)
+	for _, u := range byteSizeUnits {
+		if number, ok := strings.CutSuffix(value, u.Suffix); ok {
+			value = strings.TrimSpace(number)
+			unit = u.Size
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid byte size: %s", node.Value)
+	}
+
+	*b = ByteSize(size * float64(unit))
+	return nil
+}
+
+type Retention struct {
+	// Interval is the time between applying retention policies. Defaults to
+	// 24h.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// RetentionPolicy decides what snapshots to keep, per URL. Snapshots are kept
+// if any of the keep rules keep them. Snapshots older than the max age are
+// removed, except for the last snapshots kept by keepLast. The last snapshot
+// of a URL is never removed.
+type RetentionPolicy struct {
+	// KeepLast keeps the last n snapshots.
+	KeepLast int `yaml:"keepLast,omitempty"`
+	// KeepWithin keeps all snapshots newer than the duration.
+	KeepWithin time.Duration `yaml:"keepWithin,omitempty"`
+	// KeepDaily keeps the last snapshot of each of the last n days.
+	KeepDaily int `yaml:"keepDaily,omitempty"`
+	// KeepWeekly keeps the last snapshot of each of the last n weeks.
+	KeepWeekly int `yaml:"keepWeekly,omitempty"`
+	// KeepMonthly keeps the last snapshot of each of the last n months.
+	KeepMonthly int `yaml:"keepMonthly,omitempty"`
+	// MaxAge removes snapshots older than the duration. Takes precedence over
+	// all keep rules but KeepLast.
+	MaxAge time.Duration `yaml:"maxAge,omitempty"`
+}
+
+// Mirror continuously replicates snapshots from one library to another.
+type Mirror struct {
+	Name   string `yaml:"name,omitempty"`
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+	// Interval is the time between replications. If zero, the source is only
+	// replicated once on start.
+	Interval time.Duration `yaml:"interval,omitempty"`
 }
 
 type DiskLibraryOptions struct {
 	Path     string `yaml:"path"`
 	ReadOnly bool   `yaml:"readOnly,omitempty"`
-	Compress bool   `yaml:"compress,omitempty"`
+	// Encoding is the content encoding to store blobs with, one of gzip, zstd
+	// and br. Blobs are stored as-is if encoding doesn't make them smaller.
+	Encoding string `yaml:"encoding,omitempty"`
+	// Compress stores blobs compressed using gzip. Kept for compatibility, use
+	// encoding instead, which takes precedence.
+	Compress   bool                   `yaml:"compress,omitempty"`
+	Encryption *DiskEncryptionOptions `yaml:"encryption,omitempty"`
+}
+
+// ContentEncoding returns the content encoding to store blobs with, if any.
+func (o DiskLibraryOptions) ContentEncoding() string {
+	if o.Encoding == "" && o.Compress {
+		return "gzip"
+	}
+
+	return o.Encoding
+}
+
+// DiskEncryptionOptions configures encryption of blobs and snapshot indexes at
+// rest. Keys are base64-encoded 32-byte keys, such as generated by
+// `openssl rand -base64 32`. Keys are expanded using environment variables.
+// Unencrypted snapshot indexes are rejected once enabled, existing libraries
+// are encrypted by rotating the key.
+type DiskEncryptionOptions struct {
+	// Key is the key to encrypt content with.
+	Key string `yaml:"key,omitempty"`
+	// KeyFile is the path to a file holding the key, if not set using key.
+	KeyFile string `yaml:"keyFile,omitempty"`
+	// PreviousKeys holds keys only used to decrypt content, such as content
+	// encrypted before the key was rotated.
+	PreviousKeys []string `yaml:"previousKeys,omitempty"`
+	// PreviousKeyFiles holds paths to files of previous keys.
+	PreviousKeyFiles []string `yaml:"previousKeyFiles,omitempty"`
+}
+
+type WARCLibraryOptions struct {
+	Path     string `yaml:"path"`
+	ReadOnly bool   `yaml:"readOnly,omitempty"`
+}
+
+type OCILibraryOptions struct {
+	// Path is the path to an OCI image layout.
+	Path     string `yaml:"path"`
+	ReadOnly bool   `yaml:"readOnly,omitempty"`
+}
+
+type RegistryLibraryOptions struct {
+	// Endpoint is the registry's base URL, such as
+	// https://registry.home.internal.
+	Endpoint string `yaml:"endpoint"`
+	// Repository is the prefix of the repositories to use, such as larch.
+	Repository string `yaml:"repository"`
+	Username   string `yaml:"username,omitempty"`
+	// Password is the password or token to authenticate with. Environment
+	// variables are expanded.
+	Password string `yaml:"password,omitempty"`
+	ReadOnly bool   `yaml:"readOnly,omitempty"`
+}
+
+type S3LibraryOptions struct {
+	// Endpoint is the service's base URL, such as
+	// https://s3.eu-north-1.amazonaws.com or http://minio:9000.
+	Endpoint string `yaml:"endpoint"`
+	Region   string `yaml:"region"`
+	Bucket   string `yaml:"bucket"`
+	// Prefix is the key prefix of all objects, such as larch.
+	Prefix      string `yaml:"prefix,omitempty"`
+	AccessKeyID string `yaml:"accessKeyId,omitempty"`
+	// SecretAccessKey is the secret key to authenticate with. Environment
+	// variables are expanded.
+	SecretAccessKey string `yaml:"secretAccessKey,omitempty"`
+	// PathStyle addresses the bucket as part of the path, as required by some
+	// S3-compatible services.
+	PathStyle bool `yaml:"pathStyle,omitempty"`
+	// Redirect lets clients read blobs directly from the bucket using presigned
+	// URLs valid for the duration.
+	Redirect time.Duration `yaml:"redirect,omitempty"`
+	ReadOnly bool          `yaml:"readOnly,omitempty"`
 }
 
 type ArchiveBoxLibraryOptions struct {
@@ -80,6 +367,16 @@ type ArchiveBoxLibraryOptions struct {
 
 type RawNode struct{ node *yaml.Node }
 
+// NewRawNode returns a node holding the encoded value.
+func NewRawNode(v any) (*RawNode, error) {
+	var node yaml.Node
+	if err := node.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return &RawNode{node: &node}, nil
+}
+
 func (n *RawNode) MarshalYAML() (any, error) {
 	return n.node, nil
 }
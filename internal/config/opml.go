@@ -0,0 +1,92 @@
+package config
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"time"
+)
+
+type opmlDocument struct {
+	Body struct {
+		Outlines []opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr"`
+	Type     string        `xml:"type,attr"`
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// ReadOPML reads an OPML subscription list and expands each of its feeds into
+// a feed source using the given strategy and interval.
+//
+// SEE: https://opml.org/spec2.opml.
+func ReadOPML(r io.Reader, strategy string, interval time.Duration) ([]Source, error) {
+	var document opmlDocument
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	sources := make([]Source, 0)
+
+	var expand func(outlines []opmlOutline) error
+	expand = func(outlines []opmlOutline) error {
+		for _, outline := range outlines {
+			name := outline.Title
+			if name == "" {
+				name = outline.Text
+			}
+
+			if outline.XMLURL == "" {
+				// Outlines without a feed are folders
+				if err := expand(outline.Outlines); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if name == "" {
+				name = outline.XMLURL
+			}
+
+			options, err := NewRawNode(FeedSourceOptions{
+				URL:      outline.XMLURL,
+				Interval: interval,
+			})
+			if err != nil {
+				return err
+			}
+
+			sources = append(sources, Source{
+				Type:     "feed",
+				Name:     name,
+				Strategy: strategy,
+				Options:  options,
+			})
+		}
+
+		return nil
+	}
+
+	if err := expand(document.Body.Outlines); err != nil {
+		return nil, err
+	}
+
+	return sources, nil
+}
+
+func ReadOPMLFile(name string, strategy string, interval time.Duration) ([]Source, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadOPML(file, strategy, interval)
+}
@@ -15,6 +15,7 @@ var (
 type Indexer interface {
 	IndexLibrary(context.Context, string, libraries.LibraryReader) error
 	IndexSnapshot(context.Context, string, string, string, libraries.SnapshotReader) error
+	RemoveSnapshot(context.Context, string, string, string) error
 	ListSnapshots(context.Context, *ListSnapshotsOptions) ([]Snapshot, error)
 	GetSnapshot(context.Context, string, string) (*Snapshot, error)
 	GetArtifact(context.Context, string, string, string) (*Artifact, error)
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/AlexGustafsson/larch/internal/libraries"
@@ -12,6 +14,7 @@ import (
 var _ Indexer = (*InMemoryIndex)(nil)
 
 type InMemoryIndex struct {
+	mutex     sync.RWMutex
 	snapshots map[string]Snapshot
 	blobs     map[string]Blob
 }
@@ -37,12 +40,33 @@ func (i *InMemoryIndex) IndexLibrary(ctx context.Context, libraryID string, libr
 		}
 
 		for _, snapshotID := range snapshots {
+			// Skip snapshots that can't be read, such as snapshots whose writing
+			// was interrupted before anything was written
 			snapshotReader, err := libraryReader.ReadSnapshot(ctx, origin, snapshotID)
 			if err != nil {
-				return fmt.Errorf("failed to read snapshot: %w", err)
+				slog.Warn("Skipping unreadable snapshot", slog.String("library", libraryID), slog.String("origin", origin), slog.String("snapshotId", snapshotID), slog.Any("error", err))
+				continue
 			}
 
-			if err := i.IndexSnapshot(ctx, libraryID, origin, snapshotID, snapshotReader); err != nil {
+			index := snapshotReader.Index()
+			if len(index.Artifacts) == 0 {
+				slog.Warn("Skipping empty snapshot", slog.String("library", libraryID), slog.String("origin", origin), slog.String("snapshotId", snapshotID))
+				snapshotReader.Close()
+				continue
+			}
+
+			// Snapshots are only partial on startup if their writing was
+			// interrupted. Their artifacts are intact, but artifacts may be missing.
+			// Use larch fsck -repair to accept them as they are
+			if index.Partial {
+				slog.Warn("Skipping partial snapshot", slog.String("library", libraryID), slog.String("origin", origin), slog.String("snapshotId", snapshotID))
+				snapshotReader.Close()
+				continue
+			}
+
+			err = i.IndexSnapshot(ctx, libraryID, origin, snapshotID, snapshotReader)
+			snapshotReader.Close()
+			if err != nil {
 				return fmt.Errorf("failed to index snapshot: %w", err)
 			}
 		}
@@ -54,6 +78,9 @@ func (i *InMemoryIndex) IndexLibrary(ctx context.Context, libraryID string, libr
 // IndexSnapshot implements Indexer.
 func (i *InMemoryIndex) IndexSnapshot(ctx context.Context, libraryID string, origin string, snapshotID string, snapshotReader libraries.SnapshotReader) error {
 	index := snapshotReader.Index()
+	if len(index.Artifacts) == 0 {
+		return fmt.Errorf("empty snapshot")
+	}
 
 	// TODO: Fault tolerance
 	url := index.Artifacts[0].Annotations["larch.snapshot.url"]
@@ -81,6 +108,7 @@ func (i *InMemoryIndex) IndexSnapshot(ctx context.Context, libraryID string, ori
 
 		snapshot.Artifacts = append(snapshot.Artifacts, artifact)
 
+		i.mutex.Lock()
 		blob, ok := i.blobs[artifact.Digest]
 		if !ok {
 			blob = Blob{
@@ -93,6 +121,7 @@ func (i *InMemoryIndex) IndexSnapshot(ctx context.Context, libraryID string, ori
 		}
 		blob.Libraries = append(blob.Libraries, libraryID)
 		i.blobs[artifact.Digest] = blob
+		i.mutex.Unlock()
 
 		// Try to parse additional information from the Open Graph data
 		if manifest.Annotations["larch.artifact.type"] == "vnd.larch.opengraph.meta.v1" {
@@ -111,12 +140,34 @@ func (i *InMemoryIndex) IndexSnapshot(ctx context.Context, libraryID string, ori
 		}
 	}
 
+	i.mutex.Lock()
 	i.snapshots[origin+"/"+snapshotID] = snapshot
+	i.mutex.Unlock()
+	return nil
+}
+
+// RemoveSnapshot implements Indexer. The snapshot is only removed if it's
+// indexed from the library.
+//
+// NOTE: Blobs are left indexed, they may still be stored in the library until
+// garbage collected.
+func (i *InMemoryIndex) RemoveSnapshot(ctx context.Context, libraryID string, origin string, snapshotID string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	snapshot, ok := i.snapshots[origin+"/"+snapshotID]
+	if ok && snapshot.LibraryID == libraryID {
+		delete(i.snapshots, origin+"/"+snapshotID)
+	}
+
 	return nil
 }
 
 // ListSnapshots implements Indexer.
 func (i *InMemoryIndex) ListSnapshots(ctx context.Context, options *ListSnapshotsOptions) ([]Snapshot, error) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
 	snapshots := make([]Snapshot, 0)
 	for _, snapshot := range i.snapshots {
 		if options != nil {
@@ -132,6 +183,9 @@ func (i *InMemoryIndex) ListSnapshots(ctx context.Context, options *ListSnapshot
 
 // GetSnapshot implements Indexer.
 func (i *InMemoryIndex) GetSnapshot(ctx context.Context, origin string, id string) (*Snapshot, error) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
 	snapshot, ok := i.snapshots[origin+"/"+id]
 	if !ok {
 		return nil, ErrNotFound
@@ -141,6 +195,9 @@ func (i *InMemoryIndex) GetSnapshot(ctx context.Context, origin string, id strin
 }
 
 func (i *InMemoryIndex) GetArtifact(ctx context.Context, origin string, id string, digest string) (*Artifact, error) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
 	snapshot, ok := i.snapshots[origin+"/"+id]
 	if !ok {
 		return nil, ErrNotFound
@@ -160,6 +217,9 @@ func (i *InMemoryIndex) GetArtifact(ctx context.Context, origin string, id strin
 }
 
 func (i *InMemoryIndex) GetBlob(ctx context.Context, digest string) (*Blob, error) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
 	blob, ok := i.blobs[digest]
 	if !ok {
 		return nil, ErrNotFound
@@ -3,12 +3,9 @@ package disk
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"hash"
 	"io"
 	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/AlexGustafsson/larch/internal/libraries"
 )
@@ -21,14 +18,23 @@ type ArtifactReader struct {
 	reader io.Reader
 }
 
-func NewArtifactReader(blobsRoot *os.Root, digest string) (*ArtifactReader, error) {
-	algorithm, digest, ok := strings.Cut(digest, ":")
-	if !ok {
-		return nil, fmt.Errorf("invalid digest")
+// NewArtifactReader returns a reader of the decrypted, decoded content of a
+// blob.
+func NewArtifactReader(blobsRoot *os.Root, keys *Keyring, digest string) (*ArtifactReader, error) {
+	file, contentEncoding, err := openBlob(blobsRoot, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypter, err := keys.Decrypt(file)
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
 
-	file, err := blobsRoot.Open(filepath.Join(algorithm, digest[0:2], digest[2:4], digest))
+	decoder, err := libraries.NewDecoder(decrypter, contentEncoding)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
@@ -37,7 +43,7 @@ func NewArtifactReader(blobsRoot *os.Root, digest string) (*ArtifactReader, erro
 	return &ArtifactReader{
 		file:   file,
 		hash:   hash,
-		reader: io.TeeReader(file, hash),
+		reader: io.TeeReader(decoder, hash),
 	}, nil
 }
 
@@ -3,6 +3,7 @@ package disk
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"hash"
 	"io"
 	"os"
@@ -11,20 +12,33 @@ import (
 	"github.com/AlexGustafsson/larch/internal/libraries"
 )
 
-var _ libraries.ArtifactWriter = (*ArtifactWriter)(nil)
+var _ libraries.AbortableArtifactWriter = (*ArtifactWriter)(nil)
 
 type ArtifactWriter struct {
 	name         string
 	snapshotRoot *os.Root
 	blobsRoot    *os.Root
-	tempFile     *os.File
+	keys         *Keyring
+	temp         *sealedTemp
 	hash         hash.Hash
 	digest       string
 	writer       io.Writer
+	// contentEncoding is the preferred content encoding of the blob
+	contentEncoding string
 }
 
-func NewArtifactWriter(snapshotRoot *os.Root, blobsRoot *os.Root, name string) (*ArtifactWriter, error) {
-	tempFile, err := os.CreateTemp("", "larch-temp-*")
+// NewArtifactWriter returns a writer of an artifact. Content is written to a
+// temp file until closed, when it's stored as a blob. Temp files of encrypted
+// libraries are encrypted, see [sealedTemp].
+func NewArtifactWriter(snapshotRoot *os.Root, blobsRoot *os.Root, keys *Keyring, name string, contentEncoding string) (*ArtifactWriter, error) {
+	// Fail early rather than when the blob is written
+	if _, err := blobExtension(contentEncoding); err != nil {
+		return nil, err
+	}
+
+	// Write to a temp file next to the blobs, so that the blob can be renamed
+	// into place once its digest is known
+	temp, err := createSealedTemp(blobsRoot, keys.Encrypted())
 	if err != nil {
 		return nil, err
 	}
@@ -32,12 +46,14 @@ func NewArtifactWriter(snapshotRoot *os.Root, blobsRoot *os.Root, name string) (
 	hash := sha256.New()
 
 	return &ArtifactWriter{
-		name:         name,
-		snapshotRoot: snapshotRoot,
-		blobsRoot:    blobsRoot,
-		tempFile:     tempFile,
-		hash:         hash,
-		writer:       io.MultiWriter(tempFile, hash),
+		name:            name,
+		snapshotRoot:    snapshotRoot,
+		blobsRoot:       blobsRoot,
+		keys:            keys,
+		temp:            temp,
+		hash:            hash,
+		writer:          io.MultiWriter(temp, hash),
+		contentEncoding: contentEncoding,
 	}, nil
 }
 
@@ -46,61 +62,145 @@ func (a *ArtifactWriter) Write(p []byte) (n int, err error) {
 	return a.writer.Write(p)
 }
 
+// Abort implements libraries.AbortableArtifactWriter.
+func (a *ArtifactWriter) Abort() error {
+	return a.temp.Remove()
+}
+
 // Close implements libraries.DigestWriteCloser.
 func (a *ArtifactWriter) Close() error {
-	_, err := a.tempFile.Seek(0, 0)
+	// The temp file is removed unless renamed into place
+	defer a.temp.Remove()
+
+	a.digest = string(hex.EncodeToString(a.hash.Sum(nil)))
+
+	blobPath, err := blobPath("sha256:" + a.digest)
 	if err != nil {
-		_ = a.tempFile.Close()
-		_ = os.Remove(a.tempFile.Name())
 		return err
 	}
 
-	a.digest = string(hex.EncodeToString(a.hash.Sum(nil)))
-
-	blobPath := filepath.Join("sha256", a.digest[0:2], a.digest[2:4], a.digest)
+	// Blobs are content-addressed, there's no need to write a stored blob again
+	_, contentEncoding, err := statBlob(a.blobsRoot, a.Digest())
+	if err == nil {
+		return linkBlob(a.snapshotRoot, a.blobsRoot, a.keys, a.name, a.Digest(), contentEncoding)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
 
 	err = a.blobsRoot.MkdirAll(filepath.Dir(blobPath), 0755)
 	if err != nil {
-		_ = a.tempFile.Close()
-		_ = os.Remove(a.tempFile.Name())
 		return err
 	}
 
-	file, err := a.blobsRoot.OpenFile(blobPath, os.O_CREATE|os.O_WRONLY, 0644)
+	// Store the blob encoded if it's preferred and makes the blob smaller,
+	// there's no point in compressing already compressed content such as images
+	contentEncoding = ""
+	if a.contentEncoding != "" {
+		ok, err := a.writeEncodedBlob(blobPath, a.contentEncoding)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			contentEncoding = a.contentEncoding
+		}
+	}
+
+	if contentEncoding == "" {
+		if err := a.storeBlob(a.temp, blobPath); err != nil {
+			return err
+		}
+	}
+
+	return linkBlob(a.snapshotRoot, a.blobsRoot, a.keys, a.name, a.Digest(), contentEncoding)
+}
+
+// writeEncodedBlob writes the temp file encoded to the blob path. Returns
+// whether or not the blob was written. If the encoded blob would not be smaller
+// than the decoded content, nothing is written.
+func (a *ArtifactWriter) writeEncodedBlob(blobPath string, contentEncoding string) (bool, error) {
+	extension, err := blobExtension(contentEncoding)
 	if err != nil {
-		_ = a.tempFile.Close()
-		_ = os.Remove(a.tempFile.Name())
-		return err
+		return false, err
 	}
 
-	_, err = io.Copy(file, a.tempFile)
-	_ = a.tempFile.Close()
-	_ = os.Remove(a.tempFile.Name())
+	reader, err := a.temp.Reader()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if err := file.Close(); err != nil {
-		return err
+	encoded, err := createSealedTemp(a.blobsRoot, a.keys.Encrypted())
+	if err != nil {
+		return false, err
 	}
+	defer encoded.Remove()
 
-	if err := a.snapshotRoot.MkdirAll(filepath.Dir(a.name), 0755); err != nil {
-		return err
+	encoder, err := libraries.NewEncoder(encoded, contentEncoding)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := io.Copy(encoder, reader); err != nil {
+		return false, err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return false, err
+	}
+
+	if encoded.size >= a.temp.size {
+		return false, nil
 	}
 
-	// NOTE: This can escape the root, but that's OK as the feature is meant for
-	// humans / other tools - not larch itself. The symlinks are never used by us
-	relativeBlobsDir, err := filepath.Rel(filepath.Join(a.snapshotRoot.Name(), filepath.Dir(a.name)), a.blobsRoot.Name())
+	if err := a.storeBlob(encoded, blobPath+extension); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// storeBlob moves a temp file into place as a blob. The blob is encrypted if
+// the library is.
+func (a *ArtifactWriter) storeBlob(temp *sealedTemp, name string) error {
+	reader, err := temp.Reader()
 	if err != nil {
 		return err
 	}
 
-	err = a.snapshotRoot.Symlink(filepath.Join(relativeBlobsDir, blobPath), a.name)
-	if err != nil {
+	file := temp.file
+	if a.keys.Encrypted() {
+		encryptedFile, err := createTemp(a.blobsRoot)
+		if err != nil {
+			return err
+		}
+		defer a.blobsRoot.Remove(tempName(encryptedFile))
+		defer encryptedFile.Close()
+
+		encrypter, err := a.keys.Encrypt(encryptedFile)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(encrypter, reader); err != nil {
+			return err
+		}
+
+		if err := encrypter.Close(); err != nil {
+			return err
+		}
+
+		file = encryptedFile
+	}
+
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	if err := a.blobsRoot.Rename(tempName(file), name); err != nil {
 		return err
 	}
 
-	return nil
+	return syncDir(a.blobsRoot, filepath.Dir(name))
 }
 
 // Digest implements libraries.DigestWriteCloser.
@@ -0,0 +1,160 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+// blobEncodings holds the content encodings blobs may be stored with and the
+// extension of the blob's file name. Blobs are always named by the digest of
+// their decoded content.
+var blobEncodings = []struct {
+	ContentEncoding string
+	Extension       string
+}{
+	{ContentEncoding: "", Extension: ""},
+	{ContentEncoding: "gzip", Extension: ".gz"},
+	{ContentEncoding: "zstd", Extension: ".zst"},
+	{ContentEncoding: "br", Extension: ".br"},
+}
+
+// blobExtension returns the file extension of blobs stored with the content
+// encoding.
+func blobExtension(contentEncoding string) (string, error) {
+	for _, encoding := range blobEncodings {
+		if encoding.ContentEncoding == contentEncoding {
+			return encoding.Extension, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", libraries.ErrUnsupportedContentEncoding, contentEncoding)
+}
+
+// blobPath returns the path of a blob relative to the blobs root, excluding
+// any extension.
+func blobPath(digest string) (string, error) {
+	algorithm, digest, ok := strings.Cut(digest, ":")
+	if !ok || len(digest) < 4 || strings.ContainsAny(digest, `/\.`) {
+		return "", fmt.Errorf("invalid digest")
+	}
+
+	return filepath.Join(algorithm, digest[0:2], digest[2:4], digest), nil
+}
+
+// blobDigest returns the digest and content encoding of a blob by its path
+// relative to the blobs root.
+func blobDigest(name string) (string, string, bool) {
+	base := path.Base(name)
+	contentEncoding := ""
+	for _, encoding := range blobEncodings {
+		if encoding.Extension == "" {
+			continue
+		}
+
+		if trimmed, ok := strings.CutSuffix(base, encoding.Extension); ok {
+			base = trimmed
+			contentEncoding = encoding.ContentEncoding
+			break
+		}
+	}
+
+	algorithm, _, _ := strings.Cut(name, "/")
+	digest := algorithm + ":" + base
+
+	// Only accept canonical paths
+	expected, err := blobPath(digest)
+	if err != nil || path.Dir(name) != path.Dir(filepath.ToSlash(expected)) {
+		return "", "", false
+	}
+
+	return digest, contentEncoding, true
+}
+
+// openBlob opens a blob as stored and returns its content encoding.
+func openBlob(blobsRoot *os.Root, digest string) (*os.File, string, error) {
+	path, err := blobPath(digest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, encoding := range blobEncodings {
+		file, err := blobsRoot.Open(path + encoding.Extension)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+
+		return file, encoding.ContentEncoding, nil
+	}
+
+	return nil, "", fmt.Errorf("open %s: %w", path, os.ErrNotExist)
+}
+
+// statBlob returns the file info and content encoding of a stored blob.
+func statBlob(blobsRoot *os.Root, digest string) (os.FileInfo, string, error) {
+	path, err := blobPath(digest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, encoding := range blobEncodings {
+		info, err := blobsRoot.Stat(path + encoding.Extension)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+
+		return info, encoding.ContentEncoding, nil
+	}
+
+	return nil, "", fmt.Errorf("stat %s: %w", path, os.ErrNotExist)
+}
+
+// linkBlob creates a convenience symlink to a stored blob in the snapshot, by
+// the artifact's name. The link is named after the blob's extension, if any.
+// Encrypted libraries have no symlinks, as they would only reveal the names of
+// artifacts - the blobs are of no use to other tools.
+func linkBlob(snapshotRoot *os.Root, blobsRoot *os.Root, keys *Keyring, name string, digest string, contentEncoding string) error {
+	path, err := blobPath(digest)
+	if err != nil {
+		return err
+	}
+
+	extension, err := blobExtension(contentEncoding)
+	if err != nil {
+		return err
+	}
+
+	// Blobs are stored before they are referenced by the snapshot's index.
+	// Touch the blob so that it's not garbage collected in the meantime
+	now := time.Now()
+	if err := blobsRoot.Chtimes(path+extension, now, now); err != nil {
+		return err
+	}
+
+	if keys.Encrypted() {
+		return nil
+	}
+
+	if err := snapshotRoot.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	// NOTE: This can escape the root, but that's OK as the feature is meant for
+	// humans / other tools - not larch itself. The symlinks are never used by us
+	relativeBlobsDir, err := filepath.Rel(filepath.Join(snapshotRoot.Name(), filepath.Dir(name)), blobsRoot.Name())
+	if err != nil {
+		return err
+	}
+
+	return snapshotRoot.Symlink(filepath.Join(relativeBlobsDir, path+extension), name+extension)
+}
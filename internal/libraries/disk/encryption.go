@@ -0,0 +1,444 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Encrypted content starts with a header identifying the key it was encrypted
+// with, followed by chunks of at most chunkSize bytes of plaintext. Each chunk
+// is sealed using AES-256-GCM with a key derived from the library's key and
+// the salt, unique to the file. Nonces hold the index of the chunk and whether
+// or not it's the last chunk, so that chunks can't be reordered and content
+// can't be truncated without detection.
+//
+//	magic (8 bytes) | version (1 byte) | key id (8 bytes) | salt (32 bytes)
+//	chunk 0 (ciphertext + tag)
+//	...
+//	chunk n (ciphertext + tag)
+const (
+	encryptionMagic   = "LARCHENC"
+	encryptionVersion = 1
+	keyIDSize         = 8
+	saltSize          = 32
+	headerSize        = len(encryptionMagic) + 1 + keyIDSize + saltSize
+	chunkSize         = 64 * 1024
+)
+
+// ErrUnknownKey is returned when content is encrypted with a key that is not
+// part of the keyring.
+var ErrUnknownKey = errors.New("content is encrypted with an unknown key")
+
+// ErrUnencrypted is returned when reading an unencrypted snapshot index of a
+// library with keys, see [Keyring.DecryptIndex].
+var ErrUnencrypted = errors.New("content is not encrypted")
+
+// KeySize is the size of keys, in bytes.
+const KeySize = 32
+
+// ParseKey parses a base64-encoded key, such as generated by
+// `openssl rand -base64 32`.
+func ParseKey(value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key: expected %d bytes, got %d", KeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// Keyring holds the keys of a library. Content is encrypted using the first
+// key, the primary key, and may be decrypted using any key. A keyring without
+// keys leaves content unencrypted.
+type Keyring struct {
+	mutex sync.RWMutex
+	keys  [][]byte
+}
+
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	for _, key := range keys {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("invalid key: expected %d bytes, got %d", KeySize, len(key))
+		}
+	}
+
+	return &Keyring{
+		keys: keys,
+	}, nil
+}
+
+// Encrypted returns whether or not new content is encrypted.
+func (k *Keyring) Encrypted() bool {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return len(k.keys) > 0
+}
+
+// Rotate makes the key the primary key. Previous keys are kept for
+// decryption.
+func (k *Keyring) Rotate(key []byte) error {
+	if len(key) != KeySize {
+		return fmt.Errorf("invalid key: expected %d bytes, got %d", KeySize, len(key))
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	keys := [][]byte{key}
+	for _, other := range k.keys {
+		if !bytes.Equal(other, key) {
+			keys = append(keys, other)
+		}
+	}
+	k.keys = keys
+	return nil
+}
+
+// primary returns the primary key, or nil if the keyring has no keys.
+func (k *Keyring) primary() []byte {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if len(k.keys) == 0 {
+		return nil
+	}
+
+	return k.keys[0]
+}
+
+// find returns the key of the id, or nil if the keyring does not hold it.
+func (k *Keyring) find(id []byte) []byte {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	for _, key := range k.keys {
+		if bytes.Equal(keyID(key), id) {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// encryptedWithPrimary returns whether or not content, by its header, is
+// encrypted with the primary key.
+func (k *Keyring) encryptedWithPrimary(header []byte) bool {
+	primary := k.primary()
+	if primary == nil || !isEncrypted(header) {
+		return false
+	}
+
+	return bytes.Equal(header[len(encryptionMagic)+1:len(encryptionMagic)+1+keyIDSize], keyID(primary))
+}
+
+// Encrypt returns a writer encrypting content written to it using the primary
+// key. The writer must be closed to write the last chunk, closing it does not
+// close w. If the keyring has no keys, content is written as-is.
+func (k *Keyring) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	key := k.primary()
+	if key == nil {
+		return nopWriteCloser{w}, nil
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, encryptionMagic...)
+	header = append(header, encryptionVersion)
+	header = append(header, keyID(key)...)
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	header = append(header, salt...)
+
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		writer: w,
+		aead:   aead,
+		buffer: make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// Decrypt returns a reader of the decrypted content of r. Content that is not
+// encrypted, such as written before encryption was enabled, is read as-is.
+// Use [Keyring.DecryptIndex] to read snapshot indexes.
+func (k *Keyring) Decrypt(r io.Reader) (io.Reader, error) {
+	reader := bufio.NewReaderSize(r, chunkSize+headerSize)
+
+	header, err := reader.Peek(headerSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if !isEncrypted(header) {
+		return reader, nil
+	}
+
+	if header[len(encryptionMagic)] != encryptionVersion {
+		return nil, fmt.Errorf("unsupported encryption version: %d", header[len(encryptionMagic)])
+	}
+
+	id := header[len(encryptionMagic)+1 : len(encryptionMagic)+1+keyIDSize]
+	key := k.find(id)
+	if key == nil {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownKey, id)
+	}
+
+	aead, err := newAEAD(key, header[len(encryptionMagic)+1+keyIDSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := reader.Discard(headerSize); err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		reader: reader,
+		aead:   aead,
+		buffer: make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// DecryptIndex returns a reader of the decrypted snapshot index of r. Unlike
+// blobs, indexes are not addressed by their digest. Unencrypted indexes are
+// therefore rejected with [ErrUnencrypted] if the keyring has keys, as they
+// could have been written by anyone with access to the library. Indexes
+// written before encryption was enabled are encrypted by rotating the key, see
+// [Library.RotateKey].
+func (k *Keyring) DecryptIndex(r io.Reader) (io.Reader, error) {
+	reader := bufio.NewReaderSize(r, chunkSize+headerSize)
+
+	header, err := reader.Peek(headerSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if k.Encrypted() && !isEncrypted(header) {
+		return nil, ErrUnencrypted
+	}
+
+	return k.Decrypt(reader)
+}
+
+// seal encrypts content, see [Keyring.Encrypt].
+func (k *Keyring) seal(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := k.Encrypt(&buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// open decrypts content, see [Keyring.Decrypt].
+func (k *Keyring) open(data []byte) ([]byte, error) {
+	reader, err := k.Decrypt(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+// openIndex decrypts a snapshot index, see [Keyring.DecryptIndex].
+func (k *Keyring) openIndex(data []byte) ([]byte, error) {
+	if k.Encrypted() && !isEncrypted(data) {
+		return nil, ErrUnencrypted
+	}
+
+	return k.open(data)
+}
+
+// isEncrypted returns whether or not content is encrypted, by its header.
+func isEncrypted(header []byte) bool {
+	return len(header) >= headerSize && string(header[:len(encryptionMagic)]) == encryptionMagic
+}
+
+// keyID returns the id of a key. The id identifies the key content was
+// encrypted with, without revealing the key.
+func keyID(key []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte("larch key id\x00"))
+	hash.Write(key)
+	return hash.Sum(nil)[:keyIDSize]
+}
+
+// newAEAD returns the AEAD of a file, keyed by the key derived from the
+// library's key and the file's salt.
+func newAEAD(key []byte, salt []byte) (cipher.AEAD, error) {
+	fileKey, err := hkdf.Key(sha256.New, key, salt, "larch disk encryption v1", KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of a chunk.
+func chunkNonce(aead cipher.AEAD, index uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	writer io.Writer
+	aead   cipher.AEAD
+	buffer []byte
+	index  uint64
+	closed bool
+}
+
+// Write implements io.Writer.
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, fmt.Errorf("write to closed writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// Only seal full chunks once more content is written, the last chunk is
+		// sealed on close
+		if len(e.buffer) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(e.buffer[len(e.buffer):chunkSize], p)
+		e.buffer = e.buffer[:len(e.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	ciphertext := e.aead.Seal(nil, chunkNonce(e.aead, e.index, last), e.buffer, nil)
+	if _, err := e.writer.Write(ciphertext); err != nil {
+		return err
+	}
+
+	e.index++
+	e.buffer = e.buffer[:0]
+	return nil
+}
+
+// Close implements io.Closer.
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	return e.seal(true)
+}
+
+type decryptReader struct {
+	reader    *bufio.Reader
+	aead      cipher.AEAD
+	buffer    []byte
+	plaintext []byte
+	index     uint64
+	done      bool
+}
+
+// Read implements io.Reader.
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plaintext) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plaintext)
+	d.plaintext = d.plaintext[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.reader, d.buffer)
+	last := false
+	if err == io.ErrUnexpectedEOF {
+		last = true
+	} else if err == io.EOF {
+		return fmt.Errorf("encrypted content is truncated")
+	} else if err != nil {
+		return err
+	}
+
+	// A full chunk may be the last chunk
+	if !last {
+		_, err := d.reader.Peek(1)
+		if err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plaintext, err := d.aead.Open(d.buffer[:0], chunkNonce(d.aead, d.index, last), d.buffer[:n], nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt content: %w", err)
+	}
+
+	d.plaintext = plaintext
+	d.index++
+	d.done = last
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
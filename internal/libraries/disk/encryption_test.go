@@ -0,0 +1,246 @@
+package disk
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+
+	keys, err := NewKeyring(key)
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+
+		ciphertext, err := keys.seal(plaintext)
+		require.NoError(t, err)
+		assert.True(t, isEncrypted(ciphertext))
+
+		actual, err := keys.open(ciphertext)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, actual)
+
+		// Truncated content is detected, even on chunk boundaries
+		if size > chunkSize {
+			_, err = keys.open(ciphertext[:headerSize+chunkSize+16])
+			assert.Error(t, err)
+		}
+
+		// Modified content is detected
+		ciphertext[len(ciphertext)-1] ^= 1
+		_, err = keys.open(ciphertext)
+		assert.Error(t, err)
+	}
+
+	// Unencrypted content is read as-is, unless it's an index
+	actual, err := keys.open([]byte("{}"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("{}"), actual)
+
+	_, err = keys.openIndex([]byte("{}"))
+	assert.ErrorIs(t, err, ErrUnencrypted)
+
+	_, err = keys.DecryptIndex(bytes.NewReader([]byte("{}")))
+	assert.ErrorIs(t, err, ErrUnencrypted)
+
+	// Libraries without keys read unencrypted indexes
+	noKeys, err := NewKeyring()
+	require.NoError(t, err)
+	actual, err = noKeys.openIndex([]byte("{}"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("{}"), actual)
+
+	// Content of unknown keys is not
+	ciphertext, err := keys.seal([]byte("hello"))
+	require.NoError(t, err)
+
+	other := make([]byte, KeySize)
+	rand.Read(other)
+	otherKeys, err := NewKeyring(other)
+	require.NoError(t, err)
+
+	_, err = otherKeys.open(ciphertext)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestLibraryEncryption(t *testing.T) {
+	basePath := t.TempDir()
+
+	write := func(library *Library, id string, data []byte) string {
+		snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", id)
+		require.NoError(t, err)
+		defer snapshotWriter.Close()
+
+		size, digest, err := snapshotWriter.WriteArtifact(context.TODO(), "artifact.html", data)
+		require.NoError(t, err)
+		require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "text/html",
+			Digest:      digest,
+			Size:        size,
+		}))
+		return digest
+	}
+
+	read := func(library *Library, id string, digest string) []byte {
+		snapshotReader, err := library.ReadSnapshot(context.TODO(), "example.com", id)
+		require.NoError(t, err)
+		defer snapshotReader.Close()
+
+		require.Len(t, snapshotReader.Index().Artifacts, 1)
+		assert.Equal(t, digest, snapshotReader.Index().Artifacts[0].Digest)
+
+		reader, err := library.ReadArtifact(context.TODO(), digest)
+		require.NoError(t, err)
+		defer reader.Close()
+
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, digest, reader.Digest())
+		return data
+	}
+
+	// Files of the library, excluding symlinks
+	files := func() map[string][]byte {
+		files := make(map[string][]byte)
+		err := filepath.WalkDir(basePath, func(path string, entry os.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+
+			data, err := os.ReadFile(path)
+			files[path] = data
+			return err
+		})
+		require.NoError(t, err)
+		return files
+	}
+
+	html := bytes.Repeat([]byte("<p>Hello, World!</p>\n"), 1000)
+
+	// Start out unencrypted
+	library, err := NewLibrary(basePath, &LibraryOptions{ContentEncoding: "gzip"})
+	require.NoError(t, err)
+	plaintextDigest := write(library, "1", []byte("plaintext"))
+	library.Close()
+
+	oldKey := make([]byte, KeySize)
+	rand.Read(oldKey)
+
+	library, err = NewLibrary(basePath, &LibraryOptions{ContentEncoding: "gzip", Keys: [][]byte{oldKey}})
+	require.NoError(t, err)
+
+	// Digests are of the plaintext, blobs are still deduplicated
+	digest := write(library, "2", html)
+	assert.Equal(t, digest, write(library, "3", html))
+	assert.Equal(t, html, read(library, "2", digest))
+
+	// Unencrypted indexes are rejected until encrypted, unencrypted blobs are
+	// addressed by their digest and may still be read
+	_, err = library.ReadSnapshot(context.TODO(), "example.com", "1")
+	assert.ErrorIs(t, err, ErrUnencrypted)
+
+	reader, err := library.ReadArtifact(context.TODO(), plaintextDigest)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, []byte("plaintext"), data)
+
+	// Encrypting the library encrypts the unencrypted content
+	report, err := library.RotateKey(context.TODO(), oldKey)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Blobs)
+	assert.Equal(t, 1, report.Indexes)
+
+	for path, data := range files() {
+		assert.True(t, isEncrypted(data), path)
+	}
+
+	newKey := make([]byte, KeySize)
+	rand.Read(newKey)
+
+	report, err = library.RotateKey(context.TODO(), newKey)
+	require.NoError(t, err)
+	assert.Equal(t, &RotationReport{Blobs: 2, Indexes: 3}, report)
+	library.Close()
+
+	// The old key is no longer needed
+	library, err = NewLibrary(basePath, &LibraryOptions{ContentEncoding: "gzip", Keys: [][]byte{newKey}})
+	require.NoError(t, err)
+	defer library.Close()
+
+	assert.Equal(t, html, read(library, "3", digest))
+	assert.Equal(t, []byte("plaintext"), read(library, "1", plaintextDigest))
+
+	verification, err := library.Verify(context.TODO(), libraries.VerifyOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, verification.Problems)
+}
+
+func TestArtifactWriterEncryptsTemp(t *testing.T) {
+	basePath := t.TempDir()
+
+	key := make([]byte, KeySize)
+	rand.Read(key)
+
+	library, err := NewLibrary(basePath, &LibraryOptions{ContentEncoding: "gzip", Keys: [][]byte{key}})
+	require.NoError(t, err)
+	defer library.Close()
+
+	snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", "1")
+	require.NoError(t, err)
+	defer snapshotWriter.Close()
+
+	artifactWriter, err := snapshotWriter.NextArtifactWriter(context.TODO(), "artifact.html")
+	require.NoError(t, err)
+
+	html := bytes.Repeat([]byte("<p>Hello, World!</p>\n"), 1000)
+	_, err = artifactWriter.Write(html)
+	require.NoError(t, err)
+
+	// Temp files don't hold the plaintext
+	tempFiles := func() [][]byte {
+		entries, err := os.ReadDir(filepath.Join(basePath, "blobs", tempDir))
+		require.NoError(t, err)
+
+		files := make([][]byte, 0)
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(basePath, "blobs", tempDir, entry.Name()))
+			require.NoError(t, err)
+			files = append(files, data)
+		}
+		return files
+	}
+
+	files := tempFiles()
+	require.Len(t, files, 1)
+	assert.NotContains(t, string(files[0]), "Hello, World!")
+
+	require.NoError(t, artifactWriter.Close())
+	assert.Empty(t, tempFiles())
+
+	reader, err := library.ReadArtifact(context.TODO(), artifactWriter.Digest())
+	require.NoError(t, err)
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, html, data)
+
+	_, contentEncoding, err := statBlob(library.blobsRoot, artifactWriter.Digest())
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", contentEncoding)
+}
@@ -0,0 +1,156 @@
+package disk
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// tempDir is the directory of temp files, relative to the blobs root. Temp
+// files are kept on the same file system as blobs, so that blobs can be
+// renamed into place.
+const tempDir = ".tmp"
+
+// createTemp creates a temp file in the root's temp directory.
+func createTemp(root *os.Root) (*os.File, error) {
+	if err := root.MkdirAll(tempDir, 0755); err != nil {
+		return nil, err
+	}
+
+	return root.OpenFile(filepath.Join(tempDir, "larch-temp-"+rand.Text()), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+}
+
+// tempName returns the name of a temp file relative to the root it was created
+// in, see [createTemp].
+func tempName(file *os.File) string {
+	return filepath.Join(tempDir, filepath.Base(file.Name()))
+}
+
+// writeFileAtomic writes a file by writing to a temp file, which is then
+// renamed into place. Readers see either the previous or the new content, even
+// if the process crashes.
+func writeFileAtomic(root *os.Root, name string, data []byte, perm os.FileMode) error {
+	tempName := filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".tmp-"+rand.Text())
+
+	file, err := root.OpenFile(tempName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		root.Remove(tempName)
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		root.Remove(tempName)
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		root.Remove(tempName)
+		return err
+	}
+
+	if err := root.Rename(tempName, name); err != nil {
+		root.Remove(tempName)
+		return err
+	}
+
+	return syncDir(root, filepath.Dir(name))
+}
+
+// syncDir syncs a directory, persisting renames of its entries.
+func syncDir(root *os.Root, name string) error {
+	dir, err := root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// sealedTemp is a temp file of blob content. Content of encrypted libraries is
+// encrypted using an ephemeral key only known to the writer, so that plaintext
+// never reaches the disk, not even in temp files left behind by a crash.
+type sealedTemp struct {
+	root *os.Root
+	file *os.File
+	// keys holds the ephemeral key, or no keys if content is stored as-is
+	keys      *Keyring
+	encrypter io.WriteCloser
+	// size is the size of the content, in bytes
+	size int64
+}
+
+// createSealedTemp creates a sealed temp file in the root's temp directory,
+// see [createTemp].
+func createSealedTemp(root *os.Root, encrypted bool) (*sealedTemp, error) {
+	keys := make([][]byte, 0)
+	if encrypted {
+		key := make([]byte, KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	keyring, err := NewKeyring(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := createTemp(root)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypter, err := keyring.Encrypt(file)
+	if err != nil {
+		file.Close()
+		root.Remove(tempName(file))
+		return nil, err
+	}
+
+	return &sealedTemp{
+		root:      root,
+		file:      file,
+		keys:      keyring,
+		encrypter: encrypter,
+	}, nil
+}
+
+// Write implements io.Writer.
+func (t *sealedTemp) Write(p []byte) (int, error) {
+	n, err := t.encrypter.Write(p)
+	t.size += int64(n)
+	return n, err
+}
+
+// Reader returns a reader of the content. Nothing may be written afterwards.
+func (t *sealedTemp) Reader() (io.Reader, error) {
+	if err := t.encrypter.Close(); err != nil {
+		return nil, err
+	}
+
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// Unencrypted content is read as-is, even if it looks encrypted
+	if !t.keys.Encrypted() {
+		return t.file, nil
+	}
+
+	return t.keys.Decrypt(t.file)
+}
+
+// Remove closes and removes the temp file.
+func (t *sealedTemp) Remove() error {
+	defer t.root.Remove(tempName(t.file))
+	return t.file.Close()
+}
@@ -0,0 +1,171 @@
+package disk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.GarbageCollector = (*Library)(nil)
+
+// CollectGarbage implements GarbageCollector. Referenced blobs are marked by
+// walking the index of all snapshots. Stored blobs that are unreferenced and
+// older than the grace period are then removed. Temp files older than the
+// grace period, such as left behind by crashes, are removed as well.
+func (d *Library) CollectGarbage(ctx context.Context, options libraries.GarbageCollectionOptions) (*libraries.GarbageCollectionReport, error) {
+	d.gcMutex.Lock()
+	defer d.gcMutex.Unlock()
+
+	// Decide on the cutoff before marking, blobs stored and referenced while
+	// marking are newer
+	cutoff := time.Now().Add(-options.GracePeriod)
+
+	referenced, err := d.mark(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &libraries.GarbageCollectionReport{
+		DryRun:    options.DryRun,
+		Collected: make([]string, 0),
+	}
+
+	err = fs.WalkDir(d.blobsRoot.FS(), ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if entry.IsDir() && name == tempDir {
+			if options.DryRun {
+				return fs.SkipDir
+			}
+
+			if err := d.removeTemp(cutoff); err != nil {
+				return err
+			}
+			return fs.SkipDir
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		// Leave files that are not blobs alone
+		digest, _, ok := blobDigest(name)
+		if !ok {
+			return nil
+		}
+
+		report.Blobs++
+		if _, ok := referenced[digest]; ok {
+			report.Referenced++
+			return nil
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		report.Collected = append(report.Collected, digest)
+		report.CollectedBytes += info.Size()
+
+		if options.DryRun {
+			return nil
+		}
+
+		if err := d.blobsRoot.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// removeTemp removes temp files last modified before the cutoff.
+func (d *Library) removeTemp(cutoff time.Time) error {
+	entries, err := fs.ReadDir(d.blobsRoot.FS(), tempDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+
+		if err := d.blobsRoot.Remove(path.Join(tempDir, entry.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mark returns the digests of all blobs referenced by snapshots.
+func (d *Library) mark(ctx context.Context) (map[string]struct{}, error) {
+	referenced := make(map[string]struct{})
+
+	origins, err := d.GetOrigins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, origin := range origins {
+		snapshots, err := d.GetSnapshots(ctx, origin)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range snapshots {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			// Any unreadable index could reference blobs, it's not safe to
+			// continue
+			snapshotReader, err := d.ReadSnapshot(ctx, origin, id)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to read snapshot %s/%s: %w", origin, id, err)
+			}
+
+			for _, artifact := range snapshotReader.Index().Artifacts {
+				referenced[artifact.Digest] = struct{}{}
+			}
+
+			snapshotReader.Close()
+		}
+	}
+
+	return referenced, nil
+}
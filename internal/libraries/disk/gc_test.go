@@ -0,0 +1,113 @@
+package disk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	basePath := t.TempDir()
+
+	library, err := NewLibrary(basePath, nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", "1")
+	require.NoError(t, err)
+	defer snapshotWriter.Close()
+
+	// Referenced blob
+	size, referenced, err := snapshotWriter.WriteArtifact(context.TODO(), "referenced.txt", []byte("referenced"))
+	require.NoError(t, err)
+	require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+		ContentType: "text/plain",
+		Digest:      referenced,
+		Size:        size,
+	}))
+
+	// Unreferenced blobs, such as of failed jobs
+	_, old, err := snapshotWriter.WriteArtifact(context.TODO(), "old.txt", []byte("old"))
+	require.NoError(t, err)
+	_, recent, err := snapshotWriter.WriteArtifact(context.TODO(), "recent.txt", []byte("recent"))
+	require.NoError(t, err)
+
+	for _, digest := range []string{referenced, old} {
+		path, err := blobPath(digest)
+		require.NoError(t, err)
+		then := time.Now().Add(-48 * time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(basePath, "blobs", path), then, then))
+	}
+
+	// Files other than blobs are left alone
+	require.NoError(t, os.WriteFile(filepath.Join(basePath, "blobs", "README"), []byte("readme"), 0644))
+
+	report, err := library.CollectGarbage(context.TODO(), libraries.GarbageCollectionOptions{
+		GracePeriod: 24 * time.Hour,
+		DryRun:      true,
+	})
+	require.NoError(t, err)
+
+	expected := &libraries.GarbageCollectionReport{
+		DryRun:         true,
+		Blobs:          3,
+		Referenced:     1,
+		Collected:      []string{old},
+		CollectedBytes: 3,
+	}
+	assert.Equal(t, expected, report)
+
+	reader, err := library.ReadArtifact(context.TODO(), old)
+	require.NoError(t, err)
+	reader.Close()
+
+	report, err = library.CollectGarbage(context.TODO(), libraries.GarbageCollectionOptions{
+		GracePeriod: 24 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	expected.DryRun = false
+	assert.Equal(t, expected, report)
+
+	_, err = library.ReadArtifact(context.TODO(), old)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	for _, digest := range []string{referenced, recent} {
+		reader, err := library.ReadArtifact(context.TODO(), digest)
+		require.NoError(t, err)
+		reader.Close()
+	}
+
+	_, err = os.Stat(filepath.Join(basePath, "blobs", "README"))
+	assert.NoError(t, err)
+}
+
+func TestBlobDigest(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		Digest          string
+		ContentEncoding string
+		Expected        bool
+	}{
+		{Name: "sha256/ab/cd/abcdef", Digest: "sha256:abcdef", Expected: true},
+		{Name: "sha256/ab/cd/abcdef.gz", Digest: "sha256:abcdef", ContentEncoding: "gzip", Expected: true},
+		{Name: "sha256/ab/ef/abcdef", Expected: false},
+		{Name: "sha256/abcdef", Expected: false},
+		{Name: "README", Expected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			digest, contentEncoding, ok := blobDigest(testCase.Name)
+			assert.Equal(t, testCase.Expected, ok)
+			assert.Equal(t, testCase.Digest, digest)
+			assert.Equal(t, testCase.ContentEncoding, contentEncoding)
+		})
+	}
+}
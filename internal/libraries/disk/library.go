@@ -3,22 +3,66 @@ package disk
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/AlexGustafsson/larch/internal/libraries"
 )
 
 var _ libraries.LibraryWriter = (*Library)(nil)
 var _ libraries.LibraryReader = (*Library)(nil)
+var _ libraries.EncodedLibraryReader = (*Library)(nil)
+var _ libraries.PartialSnapshotMarker = (*Library)(nil)
 
 type Library struct {
-	snapshotsRoot *os.Root
-	blobsRoot     *os.Root
+	snapshotsRoot   *os.Root
+	blobsRoot       *os.Root
+	contentEncoding string
+	keys            *Keyring
+	// gcMutex guards garbage collection
+	gcMutex       sync.Mutex
+	snapshotLocks snapshotLocks
 }
 
-func NewLibrary(basePath string) (*Library, error) {
-	err := os.MkdirAll(filepath.Join(basePath, "snapshots"), 0755)
+type LibraryOptions struct {
+	// ContentEncoding is the encoding to store blobs with, one of gzip, zstd and
+	// br. Blobs that would not get smaller are stored as-is.
+	ContentEncoding string
+	// Keys encrypt blobs and snapshot indexes at rest. Content is encrypted
+	// using the first key and may be decrypted using any key, see [Keyring].
+	// Digests are of the plaintext, blobs are still deduplicated.
+	//
+	// Blobs being written are kept in the blobs' temp directory, encrypted
+	// using an ephemeral key. Temp files left behind by a crash are therefore
+	// unreadable, and removed when the library is opened, see [NewLibrary].
+	//
+	// NOTE: Origins and snapshot ids are not encrypted, as they're the names of
+	// the snapshots' directories.
+	Keys [][]byte
+}
+
+func NewLibrary(basePath string, options *LibraryOptions) (*Library, error) {
+	contentEncoding := ""
+	var keys [][]byte
+	if options != nil {
+		contentEncoding = options.ContentEncoding
+		keys = options.Keys
+	}
+
+	keyring, err := NewKeyring(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := blobExtension(contentEncoding); err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Join(basePath, "snapshots"), 0755)
 	if err != nil {
 		return nil, err
 	}
@@ -35,13 +79,27 @@ func NewLibrary(basePath string) (*Library, error) {
 
 	blobsRoot, err := os.OpenRoot(filepath.Join(basePath, "blobs"))
 	if err != nil {
+		snapshotsRoot.Close()
 		return nil, err
 	}
 
-	return &Library{
-		snapshotsRoot: snapshotsRoot,
-		blobsRoot:     blobsRoot,
-	}, nil
+	library := &Library{
+		snapshotsRoot:   snapshotsRoot,
+		blobsRoot:       blobsRoot,
+		contentEncoding: contentEncoding,
+		keys:            keyring,
+	}
+
+	// Temp files hold blobs before they're encoded and stored. Remove those
+	// left behind by a crash. Temp files of writes that may still be in progress,
+	// such as by another process, are left for garbage collection
+	err = library.removeTemp(time.Now().Add(-libraries.MinGracePeriod))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		library.Close()
+		return nil, err
+	}
+
+	return library, nil
 }
 
 // GetOrigins implements LibraryReader.
@@ -88,17 +146,101 @@ func (d *Library) GetSnapshots(ctx context.Context, origin string) ([]string, er
 
 // ReadSnapshot implements LibraryReader.
 func (d *Library) ReadSnapshot(ctx context.Context, origin string, id string) (libraries.SnapshotReader, error) {
-	return NewSnapshotReader(d.snapshotsRoot, d.blobsRoot, origin, id)
+	return NewSnapshotReader(d.snapshotsRoot, d.blobsRoot, d.keys, origin, id)
 }
 
 // ReadArtifact implements LibraryReader.
 func (d *Library) ReadArtifact(ctx context.Context, digest string) (libraries.ArtifactReader, error) {
-	return NewArtifactReader(d.blobsRoot, digest)
+	return NewArtifactReader(d.blobsRoot, d.keys, digest)
+}
+
+// ReadEncodedArtifact implements EncodedLibraryReader.
+func (d *Library) ReadEncodedArtifact(ctx context.Context, digest string) (io.ReadCloser, string, error) {
+	file, contentEncoding, err := openBlob(d.blobsRoot, digest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	reader, err := d.keys.Decrypt(file)
+	if err != nil {
+		file.Close()
+		return nil, "", err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, file}, contentEncoding, nil
 }
 
 // WriteSnapshot implements LibraryWriter.
 func (d *Library) WriteSnapshot(ctx context.Context, origin string, id string) (libraries.SnapshotWriter, error) {
-	return NewSnapshotWriter(d.snapshotsRoot, d.blobsRoot, origin, id)
+	return newSnapshotWriter(d.snapshotsRoot, d.blobsRoot, d.keys, origin, id, d.contentEncoding, d.snapshotLocks.get(filepath.Join(origin, id)))
+}
+
+// MarkPartial implements libraries.PartialSnapshotMarker. The mark counts as
+// an open writer of the snapshot.
+func (d *Library) MarkPartial(ctx context.Context, origin string, id string) error {
+	name := filepath.Join(origin, id)
+
+	if err := d.snapshotsRoot.MkdirAll(name, 0755); err != nil {
+		return err
+	}
+
+	snapshotRoot, err := d.snapshotsRoot.OpenRoot(name)
+	if err != nil {
+		return err
+	}
+	defer snapshotRoot.Close()
+
+	lock := d.snapshotLocks.get(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return acquireWriter(snapshotRoot, d.keys, lock)
+}
+
+// ClearPartial implements libraries.PartialSnapshotMarker.
+func (d *Library) ClearPartial(ctx context.Context, origin string, id string) error {
+	name := filepath.Join(origin, id)
+
+	snapshotRoot, err := d.snapshotsRoot.OpenRoot(name)
+	if err != nil {
+		return err
+	}
+	defer snapshotRoot.Close()
+
+	lock := d.snapshotLocks.get(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return releaseWriter(snapshotRoot, d.keys, lock)
+}
+
+// DeleteSnapshot implements LibraryWriter. The snapshot's directory is
+// removed, its blobs are left for garbage collection.
+func (d *Library) DeleteSnapshot(ctx context.Context, origin string, id string) error {
+	name := filepath.Join(origin, id)
+
+	lock := d.snapshotLocks.get(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if lock.writers > 0 {
+		return fmt.Errorf("snapshot is being written")
+	}
+
+	if _, err := d.snapshotsRoot.Stat(name); err != nil {
+		return err
+	}
+
+	if err := d.snapshotsRoot.RemoveAll(name); err != nil {
+		return err
+	}
+
+	// Remove the origin once empty
+	_ = d.snapshotsRoot.Remove(origin)
+	return nil
 }
 
 func (l *Library) Close() error {
@@ -0,0 +1,323 @@
+package disk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibraryCompress(t *testing.T) {
+	// Compressible content is stored compressed, other content is stored as-is
+	html := bytes.Repeat([]byte("<p>Hello, World!</p>\n"), 1000)
+	random := []byte{0x8f, 0x1c, 0x2d}
+
+	for _, encoding := range []struct {
+		ContentEncoding string
+		Extension       string
+	}{
+		{ContentEncoding: "gzip", Extension: ".gz"},
+		{ContentEncoding: "zstd", Extension: ".zst"},
+		{ContentEncoding: "br", Extension: ".br"},
+	} {
+		t.Run(encoding.ContentEncoding, func(t *testing.T) {
+			basePath := t.TempDir()
+
+			library, err := NewLibrary(basePath, &LibraryOptions{ContentEncoding: encoding.ContentEncoding})
+			require.NoError(t, err)
+			defer library.Close()
+
+			snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", "1")
+			require.NoError(t, err)
+			defer snapshotWriter.Close()
+
+			for _, testCase := range []struct {
+				Name            string
+				Data            []byte
+				ContentEncoding string
+			}{
+				{Name: "singlefile.html", Data: html, ContentEncoding: encoding.ContentEncoding},
+				{Name: "random.bin", Data: random, ContentEncoding: ""},
+			} {
+				t.Run(testCase.Name, func(t *testing.T) {
+					size, digest, err := snapshotWriter.WriteArtifact(context.TODO(), testCase.Name, testCase.Data)
+					require.NoError(t, err)
+					assert.Equal(t, int64(len(testCase.Data)), size)
+
+					err = snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+						ContentType: "application/octet-stream",
+						Digest:      digest,
+						Size:        size,
+					})
+					require.NoError(t, err)
+
+					snapshotReader, err := library.ReadSnapshot(context.TODO(), "example.com", "1")
+					require.NoError(t, err)
+					defer snapshotReader.Close()
+
+					artifacts := snapshotReader.Index().Artifacts
+					assert.Equal(t, testCase.ContentEncoding, artifacts[len(artifacts)-1].ContentEncoding)
+
+					reader, err := library.ReadArtifact(context.TODO(), digest)
+					require.NoError(t, err)
+
+					data, err := io.ReadAll(reader)
+					require.NoError(t, err)
+					require.NoError(t, reader.Close())
+
+					assert.Equal(t, testCase.Data, data)
+					assert.Equal(t, digest, reader.Digest())
+
+					encodedReader, contentEncoding, err := library.ReadEncodedArtifact(context.TODO(), digest)
+					require.NoError(t, err)
+					encodedReader.Close()
+					assert.Equal(t, testCase.ContentEncoding, contentEncoding)
+
+					extension := ""
+					if testCase.ContentEncoding != "" {
+						extension = encoding.Extension
+					}
+					_, err = os.Lstat(filepath.Join(basePath, "snapshots", "example.com", "1", testCase.Name+extension))
+					assert.NoError(t, err)
+				})
+			}
+		})
+	}
+}
+
+func TestLibraryLinkArtifact(t *testing.T) {
+	basePath := t.TempDir()
+
+	library, err := NewLibrary(basePath, nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	data := []byte("<html><body>Hello, World!</body></html>")
+
+	snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", "1")
+	require.NoError(t, err)
+	defer snapshotWriter.Close()
+
+	_, digest, err := snapshotWriter.WriteArtifact(context.TODO(), "singlefile.html", data)
+	require.NoError(t, err)
+
+	path, err := blobPath(digest)
+	require.NoError(t, err)
+	info, err := os.Stat(filepath.Join(basePath, "blobs", path))
+	require.NoError(t, err)
+
+	// Stored blobs are linked, not written again
+	snapshotWriter, err = library.WriteSnapshot(context.TODO(), "example.com", "2")
+	require.NoError(t, err)
+	defer snapshotWriter.Close()
+
+	linkingWriter, ok := snapshotWriter.(libraries.LinkingSnapshotWriter)
+	require.True(t, ok)
+
+	require.NoError(t, linkingWriter.LinkArtifact(context.TODO(), "singlefile.html", digest))
+
+	err = linkingWriter.LinkArtifact(context.TODO(), "missing.html", "sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, _, err = snapshotWriter.WriteArtifact(context.TODO(), "copy.html", data)
+	require.NoError(t, err)
+
+	linkedInfo, err := os.Stat(filepath.Join(basePath, "snapshots", "example.com", "2", "singlefile.html"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(info, linkedInfo))
+
+	copiedInfo, err := os.Stat(filepath.Join(basePath, "snapshots", "example.com", "2", "copy.html"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(info, copiedInfo))
+}
+
+func TestLibraryConcurrentWriters(t *testing.T) {
+	basePath := t.TempDir()
+
+	library, err := NewLibrary(basePath, &LibraryOptions{ContentEncoding: "gzip"})
+	require.NoError(t, err)
+	defer library.Close()
+
+	readIndex := func() libraries.SnapshotIndex {
+		snapshotReader, err := library.ReadSnapshot(context.TODO(), "example.com", "1")
+		require.NoError(t, err)
+		defer snapshotReader.Close()
+		return snapshotReader.Index()
+	}
+
+	// The snapshot is partial until its jobs have ended
+	require.NoError(t, library.MarkPartial(context.TODO(), "example.com", "1"))
+	assert.True(t, readIndex().Partial)
+
+	const writers = 8
+	errs := make(chan error, writers)
+
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Go(func() {
+			errs <- func() error {
+				snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", "1")
+				if err != nil {
+					return err
+				}
+				defer snapshotWriter.Close()
+
+				data := bytes.Repeat([]byte{byte('a' + i)}, 1024)
+				size, digest, err := snapshotWriter.WriteArtifact(context.TODO(), fmt.Sprintf("artifact-%d.txt", i), data)
+				if err != nil {
+					return err
+				}
+
+				err = snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+					ContentType: "text/plain",
+					Digest:      digest,
+					Size:        size,
+				})
+				if err != nil {
+					return err
+				}
+
+				return snapshotWriter.Close()
+			}()
+		})
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// Writers don't overwrite each other's manifests
+	assert.Len(t, readIndex().Artifacts, writers)
+
+	// The snapshot is partial until all writers are closed and the mark is
+	// cleared
+	assert.True(t, readIndex().Partial)
+	require.NoError(t, library.ClearPartial(context.TODO(), "example.com", "1"))
+	assert.False(t, readIndex().Partial)
+
+	// Blobs are renamed into place, no temp files are left behind
+	entries, err := os.ReadDir(filepath.Join(basePath, "blobs", tempDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLibraryDeleteSnapshot(t *testing.T) {
+	basePath := t.TempDir()
+
+	library, err := NewLibrary(basePath, nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	for _, id := range []string{"1", "2"} {
+		snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", id)
+		require.NoError(t, err)
+
+		size, digest, err := snapshotWriter.WriteArtifact(context.TODO(), "singlefile.html", []byte("Hello, World!"))
+		require.NoError(t, err)
+
+		require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "text/html",
+			Digest:      digest,
+			Size:        size,
+		}))
+		require.NoError(t, snapshotWriter.Close())
+	}
+
+	// Snapshots being written can't be deleted
+	require.NoError(t, library.MarkPartial(context.TODO(), "example.com", "1"))
+	assert.ErrorContains(t, library.DeleteSnapshot(context.TODO(), "example.com", "1"), "snapshot is being written")
+	require.NoError(t, library.ClearPartial(context.TODO(), "example.com", "1"))
+
+	require.NoError(t, library.DeleteSnapshot(context.TODO(), "example.com", "1"))
+	assert.ErrorIs(t, library.DeleteSnapshot(context.TODO(), "example.com", "1"), os.ErrNotExist)
+
+	snapshots, err := library.GetSnapshots(context.TODO(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2"}, snapshots)
+
+	_, err = library.ReadSnapshot(context.TODO(), "example.com", "1")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// The origin is removed with its last snapshot
+	require.NoError(t, library.DeleteSnapshot(context.TODO(), "example.com", "2"))
+
+	origins, err := library.GetOrigins(context.TODO())
+	require.NoError(t, err)
+	assert.Empty(t, origins)
+}
+
+func TestNewLibraryRemovesTemp(t *testing.T) {
+	basePath := t.TempDir()
+
+	library, err := NewLibrary(basePath, nil)
+	require.NoError(t, err)
+
+	stale, err := createTemp(library.blobsRoot)
+	require.NoError(t, err)
+	stale.Close()
+
+	recent, err := createTemp(library.blobsRoot)
+	require.NoError(t, err)
+	recent.Close()
+	require.NoError(t, library.Close())
+
+	modified := time.Now().Add(-2 * libraries.MinGracePeriod)
+	require.NoError(t, os.Chtimes(filepath.Join(basePath, "blobs", tempName(stale)), modified, modified))
+
+	// Temp files left behind by a crash are removed, temp files of writes that
+	// may be in progress are not
+	library, err = NewLibrary(basePath, nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	_, err = os.Stat(filepath.Join(basePath, "blobs", tempName(stale)))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = os.Stat(filepath.Join(basePath, "blobs", tempName(recent)))
+	assert.NoError(t, err)
+}
+
+func TestArtifactWriterAbort(t *testing.T) {
+	basePath := t.TempDir()
+
+	library, err := NewLibrary(basePath, nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", "1")
+	require.NoError(t, err)
+	defer snapshotWriter.Close()
+
+	artifactWriter, err := snapshotWriter.NextArtifactWriter(context.TODO(), "artifact.txt")
+	require.NoError(t, err)
+
+	_, err = artifactWriter.Write([]byte("aborted"))
+	require.NoError(t, err)
+
+	// Aborted artifacts are not stored
+	require.NoError(t, libraries.AbortArtifact(artifactWriter))
+
+	_, err = os.Lstat(filepath.Join(basePath, "snapshots", "example.com", "1", "artifact.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	entries, err := os.ReadDir(filepath.Join(basePath, "blobs"))
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.Equal(t, tempDir, entry.Name())
+	}
+
+	entries, err = os.ReadDir(filepath.Join(basePath, "blobs", tempDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,53 @@
+package disk
+
+import (
+	"sync"
+)
+
+// snapshotLocks holds the locks of snapshots by path.
+//
+// NOTE: Locks are never removed, a lock is kept for every snapshot written to
+// for the lifetime of the library.
+type snapshotLocks struct {
+	mutex sync.Mutex
+	locks map[string]*snapshotLock
+}
+
+// snapshotLock guards reading and writing a snapshot's index.
+type snapshotLock struct {
+	sync.Mutex
+	// writers is the number of open writers of the snapshot
+	writers int
+}
+
+// get returns the lock of a snapshot.
+func (s *snapshotLocks) get(name string) *snapshotLock {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.locks == nil {
+		s.locks = make(map[string]*snapshotLock)
+	}
+
+	lock, ok := s.locks[name]
+	if !ok {
+		lock = &snapshotLock{}
+		s.locks[name] = lock
+	}
+
+	return lock
+}
+
+// writing returns whether or not the snapshot has any open writers.
+func (s *snapshotLocks) writing(name string) bool {
+	s.mutex.Lock()
+	lock, ok := s.locks[name]
+	s.mutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	return lock.writers > 0
+}
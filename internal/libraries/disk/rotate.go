@@ -0,0 +1,226 @@
+package disk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+type RotationReport struct {
+	// Blobs is the number of re-encrypted blobs.
+	Blobs int `json:"blobs"`
+	// Indexes is the number of re-encrypted snapshot indexes.
+	Indexes int `json:"indexes"`
+	// Skipped is the number of blobs and indexes already encrypted using the
+	// key.
+	Skipped int `json:"skipped"`
+}
+
+// RotateKey re-encrypts all blobs and snapshot indexes using the key, which
+// becomes the key new content is encrypted with. The library must be able to
+// decrypt all content, that is hold the previous keys. Content already
+// encrypted using the key is skipped, so an interrupted rotation can be
+// resumed. Unencrypted content is encrypted, which is how encryption is
+// enabled for an existing library.
+func (d *Library) RotateKey(ctx context.Context, key []byte) (*RotationReport, error) {
+	d.gcMutex.Lock()
+	defer d.gcMutex.Unlock()
+
+	if err := d.keys.Rotate(key); err != nil {
+		return nil, err
+	}
+
+	report := &RotationReport{}
+
+	err := fs.WalkDir(d.blobsRoot.FS(), ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if entry.IsDir() && name == tempDir {
+			return fs.SkipDir
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		if _, _, ok := blobDigest(name); !ok {
+			return nil
+		}
+
+		rotated, err := d.rotateBlob(filepath.FromSlash(name))
+		if err != nil {
+			return err
+		}
+
+		if rotated {
+			report.Blobs++
+		} else {
+			report.Skipped++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	origins, err := d.GetOrigins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, origin := range origins {
+		snapshots, err := d.GetSnapshots(ctx, origin)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range snapshots {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			rotated, err := d.rotateIndex(filepath.Join(origin, id))
+			if err != nil {
+				return nil, err
+			}
+
+			if rotated {
+				report.Indexes++
+			} else {
+				report.Skipped++
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// rotateBlob re-encrypts a blob using the primary key, unless already
+// encrypted using it. Returns whether or not the blob was re-encrypted.
+func (d *Library) rotateBlob(name string) (bool, error) {
+	file, err := d.blobsRoot.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+
+	if d.keys.encryptedWithPrimary(header[:n]) {
+		return false, nil
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return false, err
+	}
+
+	decrypter, err := d.keys.Decrypt(file)
+	if err != nil {
+		return false, err
+	}
+
+	tempFile, err := createTemp(d.blobsRoot)
+	if err != nil {
+		return false, err
+	}
+	defer d.blobsRoot.Remove(tempName(tempFile))
+	defer tempFile.Close()
+
+	encrypter, err := d.keys.Encrypt(tempFile)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := io.Copy(encrypter, decrypter); err != nil {
+		return false, err
+	}
+
+	if err := encrypter.Close(); err != nil {
+		return false, err
+	}
+
+	if err := tempFile.Sync(); err != nil {
+		return false, err
+	}
+
+	if err := d.blobsRoot.Rename(tempName(tempFile), name); err != nil {
+		return false, err
+	}
+
+	return true, syncDir(d.blobsRoot, filepath.Dir(name))
+}
+
+// rotateIndex re-encrypts a snapshot's index using the primary key, unless
+// already encrypted using it. Returns whether or not the index was
+// re-encrypted.
+func (d *Library) rotateIndex(name string) (bool, error) {
+	lock := d.snapshotLocks.get(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	snapshotRoot, err := d.snapshotsRoot.OpenRoot(name)
+	if err != nil {
+		return false, err
+	}
+	defer snapshotRoot.Close()
+
+	data, err := snapshotRoot.ReadFile("index.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if d.keys.encryptedWithPrimary(data) {
+		return false, nil
+	}
+
+	// Unencrypted indexes are only read when encrypting them
+	data, err = d.keys.open(data)
+	if err != nil {
+		return false, err
+	}
+
+	var index libraries.SnapshotIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return false, err
+	}
+
+	if err := writeIndex(snapshotRoot, d.keys, index); err != nil {
+		return false, err
+	}
+
+	// Symlinks of unencrypted libraries point to blobs that are now encrypted
+	return true, removeSymlinks(snapshotRoot)
+}
+
+// removeSymlinks removes the convenience symlinks of a snapshot.
+func removeSymlinks(snapshotRoot *os.Root) error {
+	return fs.WalkDir(snapshotRoot.FS(), ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		return snapshotRoot.Remove(filepath.FromSlash(name))
+	})
+}
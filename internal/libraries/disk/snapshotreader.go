@@ -14,10 +14,11 @@ var _ libraries.SnapshotReader = (*SnapshotReader)(nil)
 type SnapshotReader struct {
 	snapshotRoot *os.Root
 	blobsRoot    *os.Root
+	keys         *Keyring
 	index        libraries.SnapshotIndex
 }
 
-func NewSnapshotReader(snapshotsRoot *os.Root, blobsRoot *os.Root, origin string, id string) (*SnapshotReader, error) {
+func NewSnapshotReader(snapshotsRoot *os.Root, blobsRoot *os.Root, keys *Keyring, origin string, id string) (*SnapshotReader, error) {
 	snapshotRoot, err := snapshotsRoot.OpenRoot(filepath.Join(origin, id))
 	if err != nil {
 		return nil, err
@@ -30,14 +31,22 @@ func NewSnapshotReader(snapshotsRoot *os.Root, blobsRoot *os.Root, origin string
 	}
 	defer indexFile.Close()
 
+	decrypter, err := keys.DecryptIndex(indexFile)
+	if err != nil {
+		snapshotRoot.Close()
+		return nil, err
+	}
+
 	var index libraries.SnapshotIndex
-	if err := json.NewDecoder(indexFile).Decode(&index); err != nil {
+	if err := json.NewDecoder(decrypter).Decode(&index); err != nil {
+		snapshotRoot.Close()
 		return nil, err
 	}
 
 	return &SnapshotReader{
 		snapshotRoot: snapshotRoot,
 		blobsRoot:    blobsRoot,
+		keys:         keys,
 		index:        index,
 	}, nil
 }
@@ -49,7 +58,7 @@ func (s *SnapshotReader) Index() libraries.SnapshotIndex {
 
 // NextArtifactReader implements SnapshotReader.
 func (s *SnapshotReader) NextArtifactReader(ctx context.Context, digest string) (libraries.ArtifactReader, error) {
-	return NewArtifactReader(s.blobsRoot, digest)
+	return NewArtifactReader(s.blobsRoot, s.keys, digest)
 }
 
 // Close implements SnapshotReader.
@@ -3,6 +3,8 @@ package disk
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io"
@@ -12,14 +14,20 @@ import (
 	"github.com/AlexGustafsson/larch/internal/libraries"
 )
 
+var _ libraries.LinkingSnapshotWriter = (*SnapshotWriter)(nil)
+
+// SnapshotWriter writes a snapshot. The snapshot's index is marked as partial
+// until all writers of the snapshot are closed and all marks made using
+// [Library.MarkPartial] are cleared.
 type SnapshotWriter struct {
-	snapshotRoot *os.Root
-	blobsRoot    *os.Root
-	index        libraries.SnapshotIndex
-	indexFile    *os.File
+	snapshotRoot    *os.Root
+	blobsRoot       *os.Root
+	keys            *Keyring
+	lock            *snapshotLock
+	contentEncoding string
 }
 
-func NewSnapshotWriter(snapshotsRoot *os.Root, blobsRoot *os.Root, origin string, id string) (*SnapshotWriter, error) {
+func newSnapshotWriter(snapshotsRoot *os.Root, blobsRoot *os.Root, keys *Keyring, origin string, id string, contentEncoding string, lock *snapshotLock) (*SnapshotWriter, error) {
 	if err := snapshotsRoot.MkdirAll(filepath.Join(origin, id), 0755); err != nil {
 		return nil, err
 	}
@@ -29,58 +37,82 @@ func NewSnapshotWriter(snapshotsRoot *os.Root, blobsRoot *os.Root, origin string
 		return nil, err
 	}
 
-	created := false
-	indexFile, err := snapshotRoot.OpenFile("index.json", os.O_RDWR, 0644)
-	if errors.Is(err, os.ErrNotExist) {
-		indexFile, err = snapshotRoot.OpenFile("index.json", os.O_CREATE|os.O_RDWR, 0644)
-		created = true
-	}
-	if err != nil {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := acquireWriter(snapshotRoot, keys, lock); err != nil {
 		snapshotRoot.Close()
-		blobsRoot.Close()
 		return nil, err
 	}
 
+	return &SnapshotWriter{
+		snapshotRoot:    snapshotRoot,
+		blobsRoot:       blobsRoot,
+		keys:            keys,
+		lock:            lock,
+		contentEncoding: contentEncoding,
+	}, nil
+}
+
+// readIndex reads a snapshot's index. Returns an empty index if the snapshot
+// has none.
+func readIndex(snapshotRoot *os.Root, keys *Keyring) (libraries.SnapshotIndex, error) {
 	index := libraries.SnapshotIndex{
 		Schema:    "application/vnd.larch.snapshot.index.v1+json",
 		Artifacts: make([]libraries.ArtifactManifest, 0),
 	}
-	if created {
-		v, _ := json.MarshalIndent(&index, "", "  ")
-		_, err := indexFile.Write(v)
-		if err != nil {
-			snapshotRoot.Close()
-			blobsRoot.Close()
-			return nil, err
-		}
-	} else {
-		d, err := io.ReadAll(indexFile)
-		if err != nil {
-			snapshotRoot.Close()
-			return nil, err
-		}
 
-		if err := json.Unmarshal(d, &index); err != nil {
-			snapshotRoot.Close()
-			return nil, err
-		}
+	data, err := snapshotRoot.ReadFile("index.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	} else if err != nil {
+		return index, err
 	}
 
-	return &SnapshotWriter{
-		snapshotRoot: snapshotRoot,
-		blobsRoot:    blobsRoot,
-		index:        index,
-		indexFile:    indexFile,
-	}, nil
+	data, err = keys.openIndex(data)
+	if err != nil {
+		return index, err
+	}
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		return index, err
+	}
+
+	return index, nil
+}
+
+// writeIndex atomically replaces a snapshot's index.
+func writeIndex(snapshotRoot *os.Root, keys *Keyring, index libraries.SnapshotIndex) error {
+	data, err := json.MarshalIndent(&index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	data, err = keys.seal(data)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(snapshotRoot, "index.json", data, 0644)
 }
 
 // NextArtifactWriter implements SnapshotWriter.
 func (d *SnapshotWriter) NextArtifactWriter(ctx context.Context, name string) (libraries.ArtifactWriter, error) {
-	return NewArtifactWriter(d.snapshotRoot, d.blobsRoot, name)
+	return NewArtifactWriter(d.snapshotRoot, d.blobsRoot, d.keys, name, d.contentEncoding)
 }
 
 // WriteArtifact implements SnapshotWriter.
 func (d *SnapshotWriter) WriteArtifact(ctx context.Context, name string, data []byte) (int64, string, error) {
+	// Skip the temp file and copy if the blob is already stored
+	hash := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+	err := d.LinkArtifact(ctx, name, digest)
+	if err == nil {
+		return int64(len(data)), digest, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, "", err
+	}
+
 	w, err := d.NextArtifactWriter(ctx, name)
 	if err != nil {
 		return 0, "", err
@@ -96,35 +128,98 @@ func (d *SnapshotWriter) WriteArtifact(ctx context.Context, name string, data []
 		return n, "", err
 	}
 
-	digest := w.Digest()
-	return n, digest, nil
+	return n, w.Digest(), nil
+}
+
+// LinkArtifact implements LinkingSnapshotWriter.
+func (d *SnapshotWriter) LinkArtifact(ctx context.Context, name string, digest string) error {
+	_, contentEncoding, err := statBlob(d.blobsRoot, digest)
+	if err != nil {
+		return err
+	}
+
+	return linkBlob(d.snapshotRoot, d.blobsRoot, d.keys, name, digest, contentEncoding)
 }
 
 // WriteArtifactManifest implements SnapshotWriter.
 func (d *SnapshotWriter) WriteArtifactManifest(ctx context.Context, manifest libraries.ArtifactManifest) error {
-	_, err := d.indexFile.Seek(0, 0)
+	// Writers of manifests, such as workers, don't know how blobs are stored.
+	// Record the encoding the blob was stored with
+	if manifest.ContentEncoding == "" && manifest.Size > 0 {
+		_, contentEncoding, err := statBlob(d.blobsRoot, manifest.Digest)
+		if err == nil {
+			manifest.ContentEncoding = contentEncoding
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// Other writers may have written to the index since it was last read
+	index, err := readIndex(d.snapshotRoot, d.keys)
 	if err != nil {
 		return err
 	}
 
-	d.index.Artifacts = append(d.index.Artifacts, manifest)
+	index.Artifacts = append(index.Artifacts, manifest)
+	index.Partial = true
 
-	_, err = d.indexFile.Seek(0, 0)
+	return writeIndex(d.snapshotRoot, d.keys, index)
+}
+
+// Close implements SnapshotWriter. The last writer of the snapshot to close
+// clears the snapshot's partial mark, unless marked using
+// [Library.MarkPartial].
+func (d *SnapshotWriter) Close() error {
+	if d.lock == nil {
+		return nil
+	}
+
+	d.lock.Lock()
+	err := releaseWriter(d.snapshotRoot, d.keys, d.lock)
+	d.lock.Unlock()
+
+	d.lock = nil
+	return errors.Join(err, d.snapshotRoot.Close())
+}
+
+// acquireWriter acquires a writer of a snapshot. The first writer marks the
+// snapshot as partial. Expects the lock to be held.
+func acquireWriter(snapshotRoot *os.Root, keys *Keyring, lock *snapshotLock) error {
+	index, err := readIndex(snapshotRoot, keys)
 	if err != nil {
 		return err
 	}
 
-	// Assume the file always grows, no need to truncate
-	// TODO: Only write to index on close?
-	encoder := json.NewEncoder(d.indexFile)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(d.index); err != nil {
-		return err
+	if !index.Partial {
+		index.Partial = true
+		if err := writeIndex(snapshotRoot, keys, index); err != nil {
+			return err
+		}
 	}
 
+	lock.writers++
 	return nil
 }
 
-func (d *SnapshotWriter) Close() error {
-	return d.snapshotRoot.Close()
+// releaseWriter releases a writer of a snapshot. The last writer clears the
+// snapshot's partial mark. Expects the lock to be held.
+func releaseWriter(snapshotRoot *os.Root, keys *Keyring, lock *snapshotLock) error {
+	if lock.writers > 0 {
+		lock.writers--
+	}
+
+	if lock.writers > 0 {
+		return nil
+	}
+
+	index, err := readIndex(snapshotRoot, keys)
+	if err != nil {
+		return err
+	}
+
+	index.Partial = false
+	return writeIndex(snapshotRoot, keys, index)
 }
@@ -0,0 +1,63 @@
+package disk
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.UsageReporter = (*Library)(nil)
+
+// Usage implements UsageReporter. Logical usage is computed from the index of
+// every snapshot, the stored bytes and blobs are that of the blobs directory.
+// Blobs are stored encoded, if smaller, which is why the stored bytes may be
+// less than the size of the unique artifacts.
+func (d *Library) Usage(ctx context.Context) (*libraries.Usage, error) {
+	usage, err := libraries.ComputeLogicalUsage(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	usage.Bytes = 0
+	usage.Blobs = 0
+
+	err = fs.WalkDir(d.blobsRoot.FS(), ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if entry.IsDir() && name == tempDir {
+			return fs.SkipDir
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		if _, _, ok := blobDigest(name); !ok {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		usage.Blobs++
+		usage.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
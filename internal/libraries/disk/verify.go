@@ -0,0 +1,365 @@
+package disk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.Verifier = (*Library)(nil)
+
+// Verify implements Verifier. Every blob is re-hashed and compared to the
+// digest of its path. The index of every snapshot is then checked to only
+// reference stored blobs of the recorded size and the convenience symlinks of
+// snapshots are checked to point to stored blobs.
+//
+// Corrupt blobs are quarantined to the quarantine directory next to the blobs
+// directory. Broken symlinks are repaired if the blob is stored, such as with
+// another content encoding. Partial snapshots not being written to are
+// repaired by clearing their mark, as their artifacts are intact.
+func (d *Library) Verify(ctx context.Context, options libraries.VerifyOptions) (*libraries.VerificationReport, error) {
+	report := &libraries.VerificationReport{
+		Problems: make([]libraries.Problem, 0),
+	}
+
+	sizes, err := d.verifyBlobs(ctx, options, report)
+	if err != nil {
+		return nil, err
+	}
+
+	// Corrupt blobs are only reported once, even if quarantined
+	corrupt := make(map[string]bool)
+	for _, problem := range report.Problems {
+		if problem.Type == libraries.ProblemCorruptBlob {
+			corrupt[problem.Digest] = true
+		}
+	}
+
+	origins, err := d.GetOrigins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, origin := range origins {
+		snapshots, err := d.GetSnapshots(ctx, origin)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range snapshots {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			report.Snapshots++
+			if err := d.verifySnapshot(options, report, sizes, corrupt, origin, id); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// verifyBlobs re-hashes all blobs and returns the decoded size of the intact
+// blobs, by digest.
+func (d *Library) verifyBlobs(ctx context.Context, options libraries.VerifyOptions, report *libraries.VerificationReport) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+
+	err := fs.WalkDir(d.blobsRoot.FS(), ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if entry.IsDir() && name == tempDir {
+			return fs.SkipDir
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		digest, contentEncoding, ok := blobDigest(name)
+		if !ok {
+			return nil
+		}
+
+		report.Blobs++
+
+		size, actual, err := d.hashBlob(name, contentEncoding)
+		if err == nil && actual == digest {
+			sizes[digest] = size
+			return nil
+		} else if errors.Is(err, ErrUnknownKey) {
+			// Blobs can't be verified without their key, they're not corrupt
+			return err
+		}
+
+		problem := libraries.Problem{
+			Type:   libraries.ProblemCorruptBlob,
+			Digest: digest,
+			Path:   path.Join("blobs", name),
+		}
+		if err != nil {
+			problem.Message = err.Error()
+		} else {
+			problem.Message = fmt.Sprintf("content has digest %s", actual)
+		}
+
+		if options.Quarantine {
+			if err := d.quarantine(name); err != nil {
+				return err
+			}
+			problem.Quarantined = true
+		}
+
+		report.Problems = append(report.Problems, problem)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sizes, nil
+}
+
+// hashBlob returns the decoded size and digest of a blob.
+func (d *Library) hashBlob(name string, contentEncoding string) (int64, string, error) {
+	file, err := d.blobsRoot.Open(name)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	decrypter, err := d.keys.Decrypt(file)
+	if err != nil {
+		return 0, "", err
+	}
+
+	decoder, err := libraries.NewDecoder(decrypter, contentEncoding)
+	if err != nil {
+		return 0, "", err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, decoder)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// quarantine moves a blob out of the blobs directory, keeping its path.
+func (d *Library) quarantine(name string) error {
+	quarantinePath := filepath.Join(filepath.Dir(d.blobsRoot.Name()), "quarantine", filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(quarantinePath), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Join(d.blobsRoot.Name(), filepath.FromSlash(name)), quarantinePath)
+}
+
+// verifySnapshot verifies a snapshot's index and symlinks. References to
+// corrupt blobs are not reported, the blobs themselves already are.
+func (d *Library) verifySnapshot(options libraries.VerifyOptions, report *libraries.VerificationReport, sizes map[string]int64, corrupt map[string]bool, origin string, id string) error {
+	snapshotPath := path.Join("snapshots", origin, id)
+
+	snapshotRoot, err := d.snapshotsRoot.OpenRoot(filepath.Join(origin, id))
+	if err != nil {
+		return err
+	}
+	defer snapshotRoot.Close()
+
+	var index libraries.SnapshotIndex
+	data, err := snapshotRoot.ReadFile("index.json")
+	if err == nil {
+		data, err = d.keys.openIndex(data)
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &index)
+	}
+	if err != nil {
+		report.Problems = append(report.Problems, libraries.Problem{
+			Type:     libraries.ProblemInvalidIndex,
+			Origin:   origin,
+			Snapshot: id,
+			Path:     path.Join(snapshotPath, "index.json"),
+			Message:  err.Error(),
+		})
+	}
+
+	if index.Partial && !d.snapshotLocks.writing(filepath.Join(origin, id)) {
+		problem := libraries.Problem{
+			Type:     libraries.ProblemPartialSnapshot,
+			Origin:   origin,
+			Snapshot: id,
+			Path:     path.Join(snapshotPath, "index.json"),
+			Message:  "snapshot was not completely written",
+		}
+
+		if options.Repair {
+			if err := d.repairPartialSnapshot(snapshotRoot, filepath.Join(origin, id)); err != nil {
+				return err
+			}
+			problem.Repaired = true
+		}
+
+		report.Problems = append(report.Problems, problem)
+	}
+
+	for _, artifact := range index.Artifacts {
+		if artifact.Digest == libraries.EmptyDigest {
+			continue
+		}
+
+		if corrupt[artifact.Digest] {
+			continue
+		}
+
+		size, ok := sizes[artifact.Digest]
+		if !ok {
+			report.Problems = append(report.Problems, libraries.Problem{
+				Type:     libraries.ProblemMissingBlob,
+				Origin:   origin,
+				Snapshot: id,
+				Digest:   artifact.Digest,
+				Message:  "blob does not exist",
+			})
+		} else if size != artifact.Size {
+			report.Problems = append(report.Problems, libraries.Problem{
+				Type:     libraries.ProblemSizeMismatch,
+				Origin:   origin,
+				Snapshot: id,
+				Digest:   artifact.Digest,
+				Message:  fmt.Sprintf("expected %d bytes, got %d", artifact.Size, size),
+			})
+		}
+	}
+
+	return fs.WalkDir(snapshotRoot.FS(), ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		// Symlinks point outside of the snapshot's root, resolve them as any
+		// other tool would
+		_, err = os.Stat(filepath.Join(snapshotRoot.Name(), filepath.FromSlash(name)))
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		digest, _, ok, err := symlinkDigest(snapshotRoot, name)
+		if err != nil {
+			return err
+		} else if ok && corrupt[digest] {
+			return nil
+		}
+
+		problem := libraries.Problem{
+			Type:     libraries.ProblemBrokenSymlink,
+			Origin:   origin,
+			Snapshot: id,
+			Path:     path.Join(snapshotPath, name),
+			Message:  "target does not exist",
+		}
+
+		if options.Repair {
+			problem.Repaired, err = d.repairSymlink(snapshotRoot, name)
+			if err != nil {
+				return err
+			}
+		}
+
+		report.Problems = append(report.Problems, problem)
+		return nil
+	})
+}
+
+// repairPartialSnapshot clears the partial mark of a snapshot.
+func (d *Library) repairPartialSnapshot(snapshotRoot *os.Root, name string) error {
+	lock := d.snapshotLocks.get(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	index, err := readIndex(snapshotRoot, d.keys)
+	if err != nil {
+		return err
+	}
+
+	index.Partial = false
+	return writeIndex(snapshotRoot, d.keys, index)
+}
+
+// repairSymlink relinks a broken symlink to the blob it pointed to, if the
+// blob is stored. Returns whether or not the symlink was repaired.
+func (d *Library) repairSymlink(snapshotRoot *os.Root, name string) (bool, error) {
+	digest, contentEncoding, ok, err := symlinkDigest(snapshotRoot, name)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	_, storedContentEncoding, err := statBlob(d.blobsRoot, digest)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// Links are named after the blob's extension, name the new link after the
+	// stored blob
+	extension, err := blobExtension(contentEncoding)
+	if err != nil {
+		return false, err
+	}
+
+	if err := snapshotRoot.Remove(name); err != nil {
+		return false, err
+	}
+
+	if err := linkBlob(snapshotRoot, d.blobsRoot, d.keys, strings.TrimSuffix(name, extension), digest, storedContentEncoding); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// symlinkDigest returns the digest and content encoding of the blob a symlink
+// points to. Returns false if the symlink does not point to a blob.
+func symlinkDigest(snapshotRoot *os.Root, name string) (string, string, bool, error) {
+	target, err := snapshotRoot.Readlink(name)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	// Targets end with the blob's path relative to the blobs root, such as
+	// ../../../blobs/sha256/ab/cd/abcd...
+	segments := strings.Split(filepath.ToSlash(target), "/")
+	if len(segments) < 4 {
+		return "", "", false, nil
+	}
+
+	digest, contentEncoding, ok := blobDigest(strings.Join(segments[len(segments)-4:], "/"))
+	return digest, contentEncoding, ok, nil
+}
@@ -0,0 +1,95 @@
+package disk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	basePath := t.TempDir()
+
+	library, err := NewLibrary(basePath, nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", "1")
+	require.NoError(t, err)
+	defer snapshotWriter.Close()
+
+	write := func(name string, data []byte, size int64) string {
+		_, digest, err := snapshotWriter.WriteArtifact(context.TODO(), name, data)
+		require.NoError(t, err)
+		require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "text/plain",
+			Digest:      digest,
+			Size:        size,
+		}))
+		return digest
+	}
+
+	intact := write("intact.txt", []byte("intact"), 6)
+	corrupt := write("corrupt.txt", []byte("corrupt"), 7)
+	mismatch := write("mismatch.txt", []byte("mismatch"), 1)
+
+	missing := "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+		ContentType: "text/plain",
+		Digest:      missing,
+		Size:        1,
+	}))
+
+	corruptPath, err := blobPath(corrupt)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(basePath, "blobs", corruptPath), []byte("c0rrupt"), 0644))
+
+	// A link to the blob as if stored compressed
+	snapshotPath := filepath.Join(basePath, "snapshots", "example.com", "1")
+	intactPath, err := blobPath(intact)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(filepath.Join(snapshotPath, "intact.txt")))
+	require.NoError(t, os.Symlink(filepath.Join("..", "..", "..", "blobs", intactPath+".gz"), filepath.Join(snapshotPath, "intact.txt.gz")))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(basePath, "snapshots", "example.com", "2"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(basePath, "snapshots", "example.com", "2", "index.json"), []byte("{"), 0644))
+
+	report, err := libraries.Verify(context.TODO(), library, libraries.VerifyOptions{
+		Repair:     true,
+		Quarantine: true,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.Snapshots)
+	assert.Equal(t, 3, report.Blobs)
+
+	problems := make(map[string]libraries.Problem)
+	for _, problem := range report.Problems {
+		problems[problem.Type] = problem
+	}
+	require.Len(t, problems, 5)
+	// The quarantined blob is not also reported as missing or as the target of
+	// a broken symlink
+	assert.Len(t, report.Problems, 5)
+
+	assert.Equal(t, corrupt, problems[libraries.ProblemCorruptBlob].Digest)
+	assert.True(t, problems[libraries.ProblemCorruptBlob].Quarantined)
+	assert.Equal(t, mismatch, problems[libraries.ProblemSizeMismatch].Digest)
+	assert.Equal(t, missing, problems[libraries.ProblemMissingBlob].Digest)
+	assert.Equal(t, "2", problems[libraries.ProblemInvalidIndex].Snapshot)
+	assert.Equal(t, "snapshots/example.com/1/intact.txt.gz", problems[libraries.ProblemBrokenSymlink].Path)
+	assert.True(t, problems[libraries.ProblemBrokenSymlink].Repaired)
+
+	_, err = os.Stat(filepath.Join(basePath, "quarantine", corruptPath))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(basePath, "blobs", corruptPath))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	data, err := os.ReadFile(filepath.Join(snapshotPath, "intact.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("intact"), data)
+}
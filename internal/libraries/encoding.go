@@ -0,0 +1,61 @@
+package libraries
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrUnsupportedContentEncoding is returned when encoding or decoding content
+// of an unknown or unsupported content encoding.
+var ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+
+// NewEncoder returns a writer encoding content written to w using the content
+// encoding, one of gzip, zstd and br. The returned writer must be closed to
+// flush the encoded content, closing it does not close w.
+func NewEncoder(w io.Writer, contentEncoding string) (io.WriteCloser, error) {
+	switch contentEncoding {
+	case "", "identity":
+		return nopWriteCloser{w}, nil
+	case "gzip":
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case "zstd":
+		// NOTE: Encode synchronously, so that no goroutines are left behind if
+		// the writer is never closed
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithEncoderConcurrency(1))
+	case "br":
+		return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentEncoding, contentEncoding)
+	}
+}
+
+// NewDecoder returns a reader decoding content read from r using the content
+// encoding.
+func NewDecoder(r io.Reader, contentEncoding string) (io.Reader, error) {
+	switch contentEncoding {
+	case "", "identity":
+		return r, nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		// NOTE: Decode synchronously, as the reader is never closed
+		return zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	case "br":
+		return brotli.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentEncoding, contentEncoding)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
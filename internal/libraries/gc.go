@@ -0,0 +1,44 @@
+package libraries
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultGracePeriod is the default minimum age of unreferenced blobs to
+// collect.
+const DefaultGracePeriod = 24 * time.Hour
+
+// MinGracePeriod is the minimum grace period accepted from clients. It outlasts
+// the deadline of jobs, which may still reference blobs they have written.
+const MinGracePeriod = time.Hour
+
+// GarbageCollector is implemented by libraries that share blobs between
+// snapshots. Blobs no longer referenced by any snapshot, such as blobs left
+// behind by failed jobs, are never removed otherwise.
+type GarbageCollector interface {
+	// CollectGarbage removes blobs not referenced by any snapshot.
+	CollectGarbage(context.Context, GarbageCollectionOptions) (*GarbageCollectionReport, error)
+}
+
+type GarbageCollectionOptions struct {
+	// GracePeriod is the minimum age of unreferenced blobs to collect. It
+	// protects blobs of snapshots that are being written, which are stored
+	// before being referenced.
+	GracePeriod time.Duration
+	// DryRun reports collectable blobs without removing them.
+	DryRun bool
+}
+
+type GarbageCollectionReport struct {
+	DryRun bool `json:"dryRun"`
+	// Blobs is the number of stored blobs.
+	Blobs int `json:"blobs"`
+	// Referenced is the number of stored blobs referenced by snapshots.
+	Referenced int `json:"referenced"`
+	// Collected holds the digests of the unreferenced blobs older than the grace
+	// period. The blobs are removed unless it's a dry run.
+	Collected []string `json:"collected"`
+	// CollectedBytes is the size of the collected blobs, as stored.
+	CollectedBytes int64 `json:"collectedBytes"`
+}
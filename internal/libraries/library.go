@@ -2,6 +2,7 @@ package libraries
 
 import (
 	"context"
+	"fmt"
 	"io"
 )
 
@@ -19,10 +20,45 @@ type LibraryReader interface {
 	Close() error
 }
 
+// EncodedLibraryReader is implemented by libraries that may store artifacts
+// encoded, such as compressed. It allows serving artifacts as stored, without
+// decoding them, to clients supporting the encoding.
+type EncodedLibraryReader interface {
+	// ReadEncodedArtifact opens a reader of the artifact of the given digest,
+	// as stored. Returns the content encoding of the read content, which is
+	// empty if the artifact is not encoded.
+	ReadEncodedArtifact(context.Context, string) (io.ReadCloser, string, error)
+}
+
+// RedirectLibraryReader is implemented by libraries that may serve artifacts
+// directly to clients, such as object storage using presigned URLs.
+type RedirectLibraryReader interface {
+	// ArtifactURL returns a URL from which the artifact of the given digest may
+	// be read, served with the given content type. Returns an empty URL if the
+	// artifact must be read through the library.
+	ArtifactURL(context.Context, string, string) (string, error)
+}
+
 type LibraryWriter interface {
 	// WriteSnapshot opens a [SnapshotWriter] for the given origin and snapshot
 	// id.
 	WriteSnapshot(context.Context, string, string) (SnapshotWriter, error)
+	// DeleteSnapshot deletes the snapshot of the given origin and id. Blobs of
+	// the snapshot may be left for garbage collection. Returns
+	// [os.ErrNotExist] if the snapshot does not exist.
+	DeleteSnapshot(context.Context, string, string) error
+}
+
+// PartialSnapshotMarker is implemented by libraries marking snapshots as
+// partial while they are being written, see [SnapshotIndex.Partial].
+type PartialSnapshotMarker interface {
+	// MarkPartial marks the snapshot of the given origin and id as partial,
+	// even while no writer of the snapshot is open, until the mark is cleared.
+	// Used to keep a snapshot partial until all of its jobs have ended.
+	MarkPartial(context.Context, string, string) error
+	// ClearPartial clears a mark made using MarkPartial. The snapshot is no
+	// longer partial once all marks are cleared and all writers are closed.
+	ClearPartial(context.Context, string, string) error
 }
 
 type SnapshotReader interface {
@@ -34,11 +70,6 @@ type SnapshotReader interface {
 	Close() error
 }
 
-// TODO: It would be nice to optionally supply a digest, that would let the
-// writer not calculate it and could allow for features like not writing the
-// file to disk if it already exists or not even accepting it from a worker if
-// it exists - saving us some disk writes. The Chrome archiver, for example, has
-// all the data in-memory so it is trivial to hash before writing.
 type SnapshotWriter interface {
 	// NextArtifactWriter returns a [ArtifactWriter] for the given file name.
 	// The name may be unused by the underlying implementation and should be
@@ -54,6 +85,20 @@ type SnapshotWriter interface {
 	Close() error
 }
 
+// LinkingSnapshotWriter is implemented by snapshot writers of libraries that
+// share blobs between snapshots. It allows writing an artifact by digest,
+// without sending or writing its content if the blob is already stored. The
+// Chrome archiver, for example, has all the data in-memory so it is trivial to
+// hash before writing.
+type LinkingSnapshotWriter interface {
+	SnapshotWriter
+	// LinkArtifact adds the already stored blob of the given digest as an
+	// artifact by file name. Returns [os.ErrNotExist] if the blob is not
+	// stored, in which case the artifact must be written. The name may be
+	// unused by the underlying implementation and should be treated as a hint.
+	LinkArtifact(context.Context, string, string) error
+}
+
 type ArtifactWriter interface {
 	io.Writer
 	io.Closer
@@ -63,6 +108,28 @@ type ArtifactWriter interface {
 	Digest() string
 }
 
+// AbortableArtifactWriter is implemented by artifact writers that can discard
+// the written content instead of storing it, such as when it's found to be
+// invalid before the writer is closed.
+type AbortableArtifactWriter interface {
+	ArtifactWriter
+	// Abort discards the written content. The writer must not be used after
+	// being aborted.
+	Abort() error
+}
+
+// AbortArtifact discards the content written to the writer, see
+// [AbortableArtifactWriter]. Writers that can't be aborted are left open, as
+// closing them would store the content.
+func AbortArtifact(writer ArtifactWriter) error {
+	abortable, ok := writer.(AbortableArtifactWriter)
+	if !ok {
+		return fmt.Errorf("artifact writer can't be aborted")
+	}
+
+	return abortable.Abort()
+}
+
 type ArtifactReader interface {
 	io.Reader
 	io.Closer
@@ -78,8 +145,9 @@ type ArtifactManifest struct {
 	ContentType string `json:"contentType"`
 	Digest      string `json:"digest"`
 	Size        int64  `json:"size"`
-	// TODO: ContentEncoding for compression? Would map to annotation for OCI.
-	// Use brotli for HTML, for example, leave that up to the archiver.
+	// ContentEncoding is the encoding the artifact is stored with, such as gzip.
+	// The digest and size are always that of the decoded content.
+	// TODO: Would map to annotation for OCI.
 	ContentEncoding string            `json:"contentEncoding,omitempty"`
 	Annotations     map[string]string `json:"annotations,omitempty"`
 }
@@ -88,6 +156,10 @@ type SnapshotIndex struct {
 	// application/vnd.larch.snapshot.index.v1+json
 	Schema    string             `json:"schema"`
 	Artifacts []ArtifactManifest `json:"artifacts"`
+	// Partial marks a snapshot that is being written to or whose writing was
+	// interrupted, such as by a crash or by jobs that never ended. The artifacts
+	// of partial snapshots are intact, but artifacts may be missing.
+	Partial bool `json:"partial,omitempty"`
 }
 
 // IDEA: Index is just index, could be read on-boot? How would that work in
@@ -96,9 +168,9 @@ type SnapshotIndex struct {
 
 // warc
 // /libraries
-// /libraries/disk
-// /libraries/disk/index.sqlite
-// /libraries/disk/snapshots/example.com/1231231.warc
+// /libraries/warc
+// /libraries/warc/snapshots/example.com/1231231.warc
+// /libraries/warc/snapshots/example.com/1231231.cdxj
 
 // disk
 // /libraries
@@ -113,9 +185,18 @@ type SnapshotIndex struct {
 // /libraries/blob/blobs/sha256/xxxxx
 // /libraries/blob/blobs/sha256/xxxxx
 
+// s3
+// <bucket>/larch/snapshots/example.com/1231231/index.json
+// <bucket>/larch/blobs/sha256/xx/yy/xxxxx
+
+// oci
+// /libraries/oci/oci-layout
+// /libraries/oci/index.json <- tags origin:snapshot per manifest
+// /libraries/oci/blobs/sha256/xxxxx
+
 // oci <=> blob on-disk? Why make any difference?
 // tags: latest, shaid per snapshot etc. URL as name?
-// registry.home.internal/larch/index:example.com <- what to put here?
+// registry.home.internal/larch/index:example.com <- tags the origin
 // registry.home.internal/larch/example.com:1231231231 <-manifest index, artifact
 // registry.home.internal/blobs/sha256/xxxxx
 // registry.home.internal/blobs/sha256/xxxxx
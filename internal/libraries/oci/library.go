@@ -0,0 +1,367 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/google/uuid"
+)
+
+var _ libraries.LibraryWriter = (*Library)(nil)
+var _ libraries.LibraryReader = (*Library)(nil)
+
+// Library stores snapshots in an OCI image layout. Each snapshot is an
+// artifact manifest with the snapshot's artifacts as layers, tagged by its
+// origin and id.
+//
+//	/oci-layout
+//	/index.json
+//	/blobs/sha256/xxxxx
+type Library struct {
+	root *os.Root
+	// mutex guards the index
+	mutex sync.Mutex
+}
+
+func NewLibrary(basePath string) (*Library, error) {
+	if err := os.MkdirAll(filepath.Join(basePath, "blobs", "sha256"), 0755); err != nil {
+		return nil, err
+	}
+
+	root, err := os.OpenRoot(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	library := &Library{
+		root: root,
+	}
+
+	// Initialize the layout
+	if _, err := root.Stat("oci-layout"); errors.Is(err, os.ErrNotExist) {
+		if err := root.WriteFile("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+			root.Close()
+			return nil, err
+		}
+	} else if err != nil {
+		root.Close()
+		return nil, err
+	}
+
+	if _, err := root.Stat("index.json"); errors.Is(err, os.ErrNotExist) {
+		err := library.writeIndex(Index{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeImageIndex,
+			Manifests:     make([]Descriptor, 0),
+		})
+		if err != nil {
+			root.Close()
+			return nil, err
+		}
+	} else if err != nil {
+		root.Close()
+		return nil, err
+	}
+
+	return library, nil
+}
+
+// GetOrigins implements LibraryReader.
+func (l *Library) GetOrigins(ctx context.Context) ([]string, error) {
+	index, err := l.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	origins := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, descriptor := range index.Manifests {
+		origin, ok := descriptor.Annotations[AnnotationSnapshotOrigin]
+		if !ok {
+			continue
+		}
+
+		if _, ok := seen[origin]; !ok {
+			seen[origin] = struct{}{}
+			origins = append(origins, origin)
+		}
+	}
+
+	return origins, nil
+}
+
+// GetSnapshots implements LibraryReader.
+func (l *Library) GetSnapshots(ctx context.Context, origin string) ([]string, error) {
+	index, err := l.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]string, 0)
+	for _, descriptor := range index.Manifests {
+		if descriptor.Annotations[AnnotationSnapshotOrigin] == origin {
+			snapshots = append(snapshots, descriptor.Annotations[AnnotationSnapshotID])
+		}
+	}
+
+	return snapshots, nil
+}
+
+// ReadSnapshot implements LibraryReader.
+func (l *Library) ReadSnapshot(ctx context.Context, origin string, id string) (libraries.SnapshotReader, error) {
+	manifest, err := l.readManifest(origin, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotReader{
+		library: l,
+		index:   NewSnapshotIndex(*manifest),
+	}, nil
+}
+
+// ReadArtifact implements LibraryReader.
+func (l *Library) ReadArtifact(ctx context.Context, digest string) (libraries.ArtifactReader, error) {
+	return NewArtifactReader(l.root, digest)
+}
+
+// WriteSnapshot implements LibraryWriter.
+func (l *Library) WriteSnapshot(ctx context.Context, origin string, id string) (libraries.SnapshotWriter, error) {
+	return &SnapshotWriter{
+		library: l,
+		origin:  origin,
+		id:      id,
+	}, nil
+}
+
+// Close implements LibraryReader.
+func (l *Library) Close() error {
+	return l.root.Close()
+}
+
+// blobPath returns the path of a blob in the layout.
+func blobPath(digest string) (string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" || len(encoded) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid digest")
+	}
+
+	if _, err := hex.DecodeString(encoded); err != nil {
+		return "", fmt.Errorf("invalid digest")
+	}
+
+	return filepath.Join("blobs", algorithm, encoded), nil
+}
+
+// readIndex reads the layout's index.
+func (l *Library) readIndex() (*Index, error) {
+	data, err := l.root.ReadFile("index.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+
+	return &index, nil
+}
+
+// writeIndex atomically replaces the layout's index.
+func (l *Library) writeIndex(index Index) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name := ".index.json." + uuid.NewString()
+	if err := l.root.WriteFile(name, data, 0644); err != nil {
+		return err
+	}
+
+	if err := l.root.Rename(name, "index.json"); err != nil {
+		_ = l.root.Remove(name)
+		return err
+	}
+
+	return nil
+}
+
+// readManifest reads the manifest of a snapshot.
+func (l *Library) readManifest(origin string, id string) (*Manifest, error) {
+	index, err := l.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, descriptor := range index.Manifests {
+		if descriptor.Annotations[AnnotationSnapshotOrigin] != origin || descriptor.Annotations[AnnotationSnapshotID] != id {
+			continue
+		}
+
+		path, err := blobPath(descriptor.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := l.root.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, err
+		}
+
+		return &manifest, nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// writeBlob writes a blob to the layout, returning its digest and size. The
+// blob is first written to a temporary file and then moved into place, so
+// that blobs are never partially written.
+func (l *Library) writeBlob(r io.Reader) (string, int64, error) {
+	name := ".blob." + uuid.NewString()
+	file, err := l.root.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = l.root.Remove(name)
+		return "", 0, err
+	}
+
+	digest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	path, err := blobPath(digest)
+	if err != nil {
+		_ = l.root.Remove(name)
+		return "", 0, err
+	}
+
+	if err := l.root.Rename(name, path); err != nil {
+		_ = l.root.Remove(name)
+		return "", 0, err
+	}
+
+	return digest, size, nil
+}
+
+// writeManifest adds an artifact to a snapshot's manifest and tags it.
+func (l *Library) writeManifest(origin string, id string, artifact libraries.ArtifactManifest) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	snapshotIndex := libraries.SnapshotIndex{
+		Schema:    ArtifactTypeSnapshot,
+		Artifacts: make([]libraries.ArtifactManifest, 0),
+	}
+
+	current, err := l.readManifest(origin, id)
+	if err == nil {
+		snapshotIndex = NewSnapshotIndex(*current)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	snapshotIndex.Artifacts = append(snapshotIndex.Artifacts, artifact)
+
+	// Layers must exist, make sure the well-known empty blobs do. The empty
+	// JSON blob is used as the config
+	if artifact.Size == 0 {
+		if _, _, err := l.writeBlob(bytes.NewReader(nil)); err != nil {
+			return err
+		}
+	}
+	if _, _, err := l.writeBlob(bytes.NewReader(EmptyJSON)); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(NewManifest(origin, id, snapshotIndex))
+	if err != nil {
+		return err
+	}
+
+	digest, size, err := l.writeBlob(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+
+	descriptor := Descriptor{
+		MediaType:    MediaTypeImageManifest,
+		Digest:       digest,
+		Size:         size,
+		ArtifactType: ArtifactTypeSnapshot,
+		Annotations: map[string]string{
+			AnnotationRefName:        RefName(origin, id),
+			AnnotationSnapshotOrigin: origin,
+			AnnotationSnapshotID:     id,
+		},
+	}
+
+	// Replace the snapshot's previous manifest, if any
+	replaced := false
+	for i, existing := range index.Manifests {
+		if existing.Annotations[AnnotationSnapshotOrigin] == origin && existing.Annotations[AnnotationSnapshotID] == id {
+			index.Manifests[i] = descriptor
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		index.Manifests = append(index.Manifests, descriptor)
+	}
+
+	return l.writeIndex(*index)
+}
+
+// DeleteSnapshot implements LibraryWriter. The snapshot's manifest is untagged
+// by removing it from the index, its blobs are left in the layout.
+func (l *Library) DeleteSnapshot(ctx context.Context, origin string, id string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+
+	manifests := slices.DeleteFunc(slices.Clone(index.Manifests), func(descriptor Descriptor) bool {
+		return descriptor.Annotations[AnnotationSnapshotOrigin] == origin && descriptor.Annotations[AnnotationSnapshotID] == id
+	})
+	if len(manifests) == len(index.Manifests) {
+		return os.ErrNotExist
+	}
+
+	index.Manifests = manifests
+	return l.writeIndex(*index)
+}
@@ -0,0 +1,128 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibrary(t *testing.T) {
+	basePath := t.TempDir()
+
+	library, err := NewLibrary(basePath)
+	require.NoError(t, err)
+	defer library.Close()
+
+	snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com:8080", "1")
+	require.NoError(t, err)
+	defer snapshotWriter.Close()
+
+	require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+		ContentType: "application/vnd.larch.snapshot.manifest.v1+json",
+		Digest:      "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		Annotations: map[string]string{"larch.snapshot.url": "https://example.com:8080"},
+	}))
+
+	data := []byte("<html><body>Hello, World!</body></html>")
+
+	writer, err := snapshotWriter.NextArtifactWriter(context.TODO(), "singlefile.html")
+	require.NoError(t, err)
+	_, err = writer.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	digest := writer.Digest()
+
+	require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+		ContentType:     "text/html",
+		Digest:          digest,
+		Size:            int64(len(data)),
+		ContentEncoding: "identity",
+	}))
+
+	origins, err := library.GetOrigins(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com:8080"}, origins)
+
+	snapshots, err := library.GetSnapshots(context.TODO(), "example.com:8080")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1"}, snapshots)
+
+	snapshotReader, err := library.ReadSnapshot(context.TODO(), "example.com:8080", "1")
+	require.NoError(t, err)
+	defer snapshotReader.Close()
+
+	index := snapshotReader.Index()
+	require.Len(t, index.Artifacts, 2)
+	assert.Equal(t, "https://example.com:8080", index.Artifacts[0].Annotations["larch.snapshot.url"])
+	assert.Equal(t, libraries.ArtifactManifest{
+		ContentType:     "text/html",
+		Digest:          digest,
+		Size:            int64(len(data)),
+		ContentEncoding: "identity",
+	}, index.Artifacts[1])
+
+	reader, err := snapshotReader.NextArtifactReader(context.TODO(), digest)
+	require.NoError(t, err)
+	actual, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, data, actual)
+
+	// The layout should only reference existing blobs
+	var layoutIndex Index
+	content, err := os.ReadFile(filepath.Join(basePath, "index.json"))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(content, &layoutIndex))
+	require.Len(t, layoutIndex.Manifests, 1)
+	assert.Equal(t, "example.com:8080:1", layoutIndex.Manifests[0].Annotations[AnnotationRefName])
+
+	var manifest Manifest
+	content, err = os.ReadFile(filepath.Join(basePath, "blobs", "sha256", layoutIndex.Manifests[0].Digest[7:]))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(content, &manifest))
+
+	for _, descriptor := range append(manifest.Layers, manifest.Config) {
+		_, err := os.Stat(filepath.Join(basePath, "blobs", "sha256", descriptor.Digest[7:]))
+		assert.NoError(t, err, descriptor.Digest)
+	}
+
+	// Deleting a snapshot removes it from the layout, its blobs are left
+	require.NoError(t, library.DeleteSnapshot(context.TODO(), "example.com:8080", "1"))
+	assert.ErrorIs(t, library.DeleteSnapshot(context.TODO(), "example.com:8080", "1"), os.ErrNotExist)
+
+	origins, err = library.GetOrigins(context.TODO())
+	require.NoError(t, err)
+	assert.Empty(t, origins)
+
+	_, err = library.ReadSnapshot(context.TODO(), "example.com:8080", "1")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = os.Stat(filepath.Join(basePath, "blobs", "sha256", digest[7:]))
+	assert.NoError(t, err)
+}
+
+func TestRefName(t *testing.T) {
+	testCases := []struct {
+		Origin   string
+		ID       string
+		Expected string
+	}{
+		{Origin: "example.com", ID: "1", Expected: "example.com:1"},
+		{Origin: "[::1]:8080", ID: "1", Expected: "origin-::1-:8080:1"},
+		{Origin: "-example.com", ID: "1", Expected: "origin-example.com:1"},
+		{Origin: "", ID: "1", Expected: "origin:1"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Origin, func(t *testing.T) {
+			assert.Equal(t, testCase.Expected, RefName(testCase.Origin, testCase.ID))
+		})
+	}
+}
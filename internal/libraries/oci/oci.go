@@ -0,0 +1,150 @@
+package oci
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+// SEE: https://github.com/opencontainers/image-spec/blob/main/image-layout.md.
+// SEE: https://github.com/opencontainers/image-spec/blob/main/manifest.md.
+
+const (
+	MediaTypeImageIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeEmptyJSON     = "application/vnd.oci.empty.v1+json"
+
+	// ArtifactTypeSnapshot is the artifact type of snapshot manifests.
+	ArtifactTypeSnapshot = "application/vnd.larch.snapshot.index.v1+json"
+)
+
+const (
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+
+	AnnotationSnapshotOrigin = "larch.snapshot.origin"
+	AnnotationSnapshotID     = "larch.snapshot.id"
+	// AnnotationContentEncoding holds the content encoding of an artifact.
+	AnnotationContentEncoding = "larch.artifact.contentEncoding"
+)
+
+// EmptyJSON is the empty descriptor's content, used as the config of artifact
+// manifests.
+var EmptyJSON = []byte("{}")
+
+// EmptyJSONDescriptor describes [EmptyJSON].
+var EmptyJSONDescriptor = Descriptor{
+	MediaType: MediaTypeEmptyJSON,
+	Digest:    "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+	Size:      2,
+}
+
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type Index struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Manifests     []Descriptor      `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// NewManifest returns the manifest of a snapshot. Each artifact is a layer.
+func NewManifest(origin string, id string, index libraries.SnapshotIndex) Manifest {
+	layers := make([]Descriptor, 0)
+	for _, artifact := range index.Artifacts {
+		var annotations map[string]string
+		if len(artifact.Annotations) > 0 || artifact.ContentEncoding != "" {
+			annotations = maps.Clone(artifact.Annotations)
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			if artifact.ContentEncoding != "" {
+				annotations[AnnotationContentEncoding] = artifact.ContentEncoding
+			}
+		}
+
+		layers = append(layers, Descriptor{
+			MediaType:   artifact.ContentType,
+			Digest:      artifact.Digest,
+			Size:        artifact.Size,
+			Annotations: annotations,
+		})
+	}
+
+	return Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		ArtifactType:  ArtifactTypeSnapshot,
+		Config:        EmptyJSONDescriptor,
+		Layers:        layers,
+		Annotations: map[string]string{
+			AnnotationSnapshotOrigin: origin,
+			AnnotationSnapshotID:     id,
+		},
+	}
+}
+
+// NewSnapshotIndex returns the snapshot index of a manifest.
+func NewSnapshotIndex(manifest Manifest) libraries.SnapshotIndex {
+	artifacts := make([]libraries.ArtifactManifest, 0)
+	for _, layer := range manifest.Layers {
+		var annotations map[string]string
+		if len(layer.Annotations) > 0 {
+			annotations = maps.Clone(layer.Annotations)
+			delete(annotations, AnnotationContentEncoding)
+			if len(annotations) == 0 {
+				annotations = nil
+			}
+		}
+
+		artifacts = append(artifacts, libraries.ArtifactManifest{
+			ContentType:     layer.MediaType,
+			Digest:          layer.Digest,
+			Size:            layer.Size,
+			ContentEncoding: layer.Annotations[AnnotationContentEncoding],
+			Annotations:     annotations,
+		})
+	}
+
+	return libraries.SnapshotIndex{
+		Schema:    ArtifactTypeSnapshot,
+		Artifacts: artifacts,
+	}
+}
+
+// RefName returns the reference name, or tag, of a snapshot.
+func RefName(origin string, id string) string {
+	// Only a limited set of characters are allowed
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("-._:@+/", r):
+			return r
+		default:
+			return '-'
+		}
+	}, origin+":"+id)
+
+	// Reference names must start with an alphanumeric character
+	if r := name[0]; !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+		name = "origin" + name
+	}
+
+	return name
+}
@@ -0,0 +1,29 @@
+package oci
+
+import (
+	"context"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.SnapshotReader = (*SnapshotReader)(nil)
+
+type SnapshotReader struct {
+	library *Library
+	index   libraries.SnapshotIndex
+}
+
+// Index implements SnapshotReader.
+func (s *SnapshotReader) Index() libraries.SnapshotIndex {
+	return s.index
+}
+
+// NextArtifactReader implements SnapshotReader.
+func (s *SnapshotReader) NextArtifactReader(ctx context.Context, digest string) (libraries.ArtifactReader, error) {
+	return s.library.ReadArtifact(ctx, digest)
+}
+
+// Close implements SnapshotReader.
+func (s *SnapshotReader) Close() error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.LinkingSnapshotWriter = (*SnapshotWriter)(nil)
+
+type SnapshotWriter struct {
+	library *Library
+	origin  string
+	id      string
+}
+
+// NextArtifactWriter implements SnapshotWriter.
+func (s *SnapshotWriter) NextArtifactWriter(ctx context.Context, name string) (libraries.ArtifactWriter, error) {
+	return newArtifactWriter(s.library), nil
+}
+
+// WriteArtifact implements SnapshotWriter.
+func (s *SnapshotWriter) WriteArtifact(ctx context.Context, name string, data []byte) (int64, string, error) {
+	hash := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+	err := s.LinkArtifact(ctx, name, digest)
+	if err == nil {
+		return int64(len(data)), digest, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, "", err
+	}
+
+	digest, size, err := s.library.writeBlob(bytes.NewReader(data))
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, digest, nil
+}
+
+// LinkArtifact implements LinkingSnapshotWriter.
+func (s *SnapshotWriter) LinkArtifact(ctx context.Context, name string, digest string) error {
+	path, err := blobPath(digest)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.library.root.Stat(path)
+	return err
+}
+
+// WriteArtifactManifest implements SnapshotWriter.
+func (s *SnapshotWriter) WriteArtifactManifest(ctx context.Context, manifest libraries.ArtifactManifest) error {
+	return s.library.writeManifest(s.origin, s.id, manifest)
+}
+
+// Close implements SnapshotWriter.
+func (s *SnapshotWriter) Close() error {
+	return nil
+}
+
+var _ libraries.AbortableArtifactWriter = (*artifactWriter)(nil)
+
+// errAborted is the error blobs of aborted artifact writers fail with.
+var errAborted = errors.New("artifact writer aborted")
+
+// artifactWriter streams an artifact into a blob of the layout.
+type artifactWriter struct {
+	writer *io.PipeWriter
+	done   chan struct{}
+	digest string
+	err    error
+}
+
+func newArtifactWriter(library *Library) *artifactWriter {
+	reader, writer := io.Pipe()
+
+	a := &artifactWriter{
+		writer: writer,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(a.done)
+		a.digest, _, a.err = library.writeBlob(reader)
+		// Unblock the writer on failure
+		reader.CloseWithError(a.err)
+	}()
+
+	return a
+}
+
+// Write implements libraries.ArtifactWriter.
+func (a *artifactWriter) Write(p []byte) (int, error) {
+	return a.writer.Write(p)
+}
+
+// Abort implements libraries.AbortableArtifactWriter. The partially written
+// blob is removed.
+func (a *artifactWriter) Abort() error {
+	a.writer.CloseWithError(errAborted)
+	<-a.done
+	return nil
+}
+
+// Close implements libraries.ArtifactWriter.
+func (a *artifactWriter) Close() error {
+	if err := a.writer.Close(); err != nil {
+		return err
+	}
+
+	<-a.done
+	return a.err
+}
+
+// Digest implements libraries.ArtifactWriter.
+func (a *artifactWriter) Digest() string {
+	return a.digest
+}
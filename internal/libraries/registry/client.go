@@ -0,0 +1,464 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	urlpkg "net/url"
+
+	"github.com/AlexGustafsson/larch/internal/libraries/oci"
+)
+
+// Client is a minimal client of the OCI distribution API.
+//
+// SEE: https://github.com/opencontainers/distribution-spec/blob/main/spec.md.
+type Client struct {
+	// Endpoint is the registry's base URL, such as
+	// https://registry.home.internal.
+	Endpoint string
+	Username string
+	Password string
+	Client   *http.Client
+
+	mutex sync.Mutex
+	// token is the bearer token to use, if the registry uses token
+	// authentication
+	token string
+}
+
+// do performs a request against the registry. If the registry requires token
+// authentication, a token is requested and the request retried. The body, if
+// any, must therefore be seekable.
+func (c *Client) do(ctx context.Context, method string, url string, header http.Header, body io.ReadSeeker) (*http.Response, error) {
+	res, err := c.doOnce(ctx, method, url, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusUnauthorized {
+		return res, nil
+	}
+	res.Body.Close()
+
+	challenge := res.Header.Get("WWW-Authenticate")
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") {
+		return nil, fmt.Errorf("unauthorized")
+	}
+
+	if err := c.authenticate(ctx, params); err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.doOnce(ctx, method, url, header, body)
+}
+
+func (c *Client) doOnce(ctx context.Context, method string, url string, header http.Header, body io.ReadSeeker) (*http.Response, error) {
+	// Hide any Close method of the body, the transport would otherwise close it
+	// and the body could not be retried
+	var reader io.Reader
+	if body != nil {
+		reader = struct{ io.Reader }{body}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	// Set the length explicitly, as it's not known for all seekers
+	if body != nil {
+		size, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	c.mutex.Lock()
+	token := c.token
+	c.mutex.Unlock()
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	return c.client().Do(req)
+}
+
+func (c *Client) client() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+
+	return c.Client
+}
+
+// authenticate requests a bearer token as described by a challenge.
+//
+// SEE: https://distribution.github.io/distribution/spec/auth/token/.
+func (c *Client) authenticate(ctx context.Context, params map[string]string) error {
+	realm, err := urlpkg.Parse(params["realm"])
+	if err != nil || realm.Scheme == "" {
+		return fmt.Errorf("invalid token realm")
+	}
+
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope := params["scope"]; scope != "" {
+		query.Set("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	res, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+
+	return nil
+}
+
+// url returns the URL of a path of the API, relative to /v2/.
+func (c *Client) url(repository string, path string) string {
+	return strings.TrimSuffix(c.Endpoint, "/") + "/v2/" + repository + "/" + path
+}
+
+// HasBlob returns whether or not a blob exists in a repository.
+func (c *Client) HasBlob(ctx context.Context, repository string, digest string) (bool, error) {
+	res, err := c.do(ctx, http.MethodHead, c.url(repository, "blobs/"+digest), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+}
+
+// GetBlob returns a reader of a blob of a repository.
+func (c *Client) GetBlob(ctx context.Context, repository string, digest string) (io.ReadCloser, error) {
+	res, err := c.do(ctx, http.MethodGet, c.url(repository, "blobs/"+digest), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Body, nil
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, os.ErrNotExist
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+}
+
+// PushBlob uploads a blob to a repository in a single request.
+func (c *Client) PushBlob(ctx context.Context, repository string, digest string, body io.ReadSeeker) error {
+	res, err := c.do(ctx, http.MethodPost, c.url(repository, "blobs/uploads/"), nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	location, err := res.Request.URL.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/octet-stream")
+
+	res, err = c.do(ctx, http.MethodPut, location.String(), header, body)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// MountBlob mounts a blob of another repository in a repository, without
+// uploading it. Returns [os.ErrNotExist] if the registry did not mount the
+// blob.
+func (c *Client) MountBlob(ctx context.Context, repository string, digest string, from string) error {
+	query := urlpkg.Values{}
+	query.Set("mount", digest)
+	query.Set("from", from)
+
+	res, err := c.do(ctx, http.MethodPost, c.url(repository, "blobs/uploads/")+"?"+query.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusCreated:
+		return nil
+	case http.StatusAccepted:
+		// The registry started an upload session instead, which is left to
+		// expire
+		return os.ErrNotExist
+	default:
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+}
+
+// GetManifest returns a manifest of a repository by tag or digest.
+func (c *Client) GetManifest(ctx context.Context, repository string, reference string) (*oci.Manifest, error) {
+	header := make(http.Header)
+	header.Set("Accept", oci.MediaTypeImageManifest)
+
+	res, err := c.do(ctx, http.MethodGet, c.url(repository, "manifests/"+reference), header, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, os.ErrNotExist
+	default:
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	var manifest oci.Manifest
+	if err := json.NewDecoder(res.Body).Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// PutManifest uploads a manifest to a repository and tags it.
+func (c *Client) PutManifest(ctx context.Context, repository string, reference string, manifest oci.Manifest) error {
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", manifest.MediaType)
+
+	res, err := c.do(ctx, http.MethodPut, c.url(repository, "manifests/"+reference), header, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// DeleteManifest deletes a manifest of a repository by reference, such as a
+// tag. Registries delete manifests by digest, deleting all of its tags.
+func (c *Client) DeleteManifest(ctx context.Context, repository string, reference string) error {
+	header := make(http.Header)
+	header.Set("Accept", oci.MediaTypeImageManifest)
+
+	res, err := c.do(ctx, http.MethodHead, c.url(repository, "manifests/"+reference), header, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	default:
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	digest := res.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return fmt.Errorf("registry did not return the manifest's digest")
+	}
+
+	res, err = c.do(ctx, http.MethodDelete, c.url(repository, "manifests/"+digest), nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	case http.StatusMethodNotAllowed:
+		return fmt.Errorf("registry does not support deletion")
+	default:
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+}
+
+// ListTags returns all tags of a repository. A repository that does not exist
+// has no tags.
+func (c *Client) ListTags(ctx context.Context, repository string) ([]string, error) {
+	tags := make([]string, 0)
+
+	url := c.url(repository, "tags/list")
+	for url != "" {
+		res, err := c.do(ctx, http.MethodGet, url, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			return tags, nil
+		} else if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+
+		// Follow pagination
+		url = ""
+		if next := nextLink(res.Header.Get("Link")); next != "" {
+			u, err := res.Request.URL.Parse(next)
+			if err != nil {
+				return nil, err
+			}
+			url = u.String()
+		}
+	}
+
+	return tags, nil
+}
+
+// nextLink returns the target of a Link header's next link, if any.
+func nextLink(header string) string {
+	target, params, ok := strings.Cut(header, ";")
+	if !ok || !strings.Contains(params, `rel="next"`) {
+		return ""
+	}
+
+	target = strings.TrimSpace(target)
+	return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+}
+
+// parseChallenge parses a WWW-Authenticate header value such as
+// Bearer realm="https://auth.example.com/token",service="registry".
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+
+	for rest != "" {
+		var key string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			// Quoted values may contain commas, such as scopes
+			end := strings.Index(rest[1:], `"`)
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		if key != "" {
+			if unquoted, err := strconv.Unquote(`"` + value + `"`); err == nil {
+				value = unquoted
+			}
+			params[key] = value
+		}
+	}
+
+	return scheme, params
+}
@@ -0,0 +1,371 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/libraries/oci"
+)
+
+var _ libraries.LibraryWriter = (*Library)(nil)
+var _ libraries.LibraryReader = (*Library)(nil)
+
+// ArtifactTypeOrigin is the artifact type of the manifests tagging origins.
+const ArtifactTypeOrigin = "application/vnd.larch.origin.v1+json"
+
+// Library stores snapshots in an OCI distribution registry. Snapshots are
+// stored like in the OCI image layout library, in a repository per origin and
+// tagged by their id. As the distribution API has no standard way of listing
+// repositories, origins are tagged in an index repository.
+//
+//	registry.home.internal/larch/index:example.com
+//	registry.home.internal/larch/example.com:1231231231
+type Library struct {
+	client *Client
+	// repository is the prefix of all repositories, such as larch
+	repository string
+	// mutex guards manifest writes
+	mutex sync.Mutex
+	// blobsMutex guards blobs
+	blobsMutex sync.RWMutex
+	// blobs holds a repository containing a blob by digest. Blobs are stored per
+	// repository, populated as snapshots are read and written
+	blobs map[string]string
+}
+
+func NewLibrary(client *Client, repository string) *Library {
+	return &Library{
+		client:     client,
+		repository: strings.Trim(repository, "/"),
+		blobs:      make(map[string]string),
+	}
+}
+
+// indexRepository returns the name of the repository tagging origins.
+func (l *Library) indexRepository() string {
+	return l.join("index")
+}
+
+// originRepository returns the name of an origin's repository.
+func (l *Library) originRepository(origin string) string {
+	return l.join(componentName(origin))
+}
+
+func (l *Library) join(name string) string {
+	if l.repository == "" {
+		return name
+	}
+
+	return l.repository + "/" + name
+}
+
+// GetOrigins implements LibraryReader.
+func (l *Library) GetOrigins(ctx context.Context) ([]string, error) {
+	tags, err := l.client.ListTags(ctx, l.indexRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	// Tags are sanitized, the origin is kept as an annotation
+	origins := make([]string, 0)
+	for _, tag := range tags {
+		manifest, err := l.client.GetManifest(ctx, l.indexRepository(), tag)
+		if err != nil {
+			return nil, err
+		}
+
+		if origin, ok := manifest.Annotations[oci.AnnotationSnapshotOrigin]; ok {
+			origins = append(origins, origin)
+		}
+	}
+
+	return origins, nil
+}
+
+// GetSnapshots implements LibraryReader.
+func (l *Library) GetSnapshots(ctx context.Context, origin string) ([]string, error) {
+	return l.client.ListTags(ctx, l.originRepository(origin))
+}
+
+// ReadSnapshot implements LibraryReader.
+func (l *Library) ReadSnapshot(ctx context.Context, origin string, id string) (libraries.SnapshotReader, error) {
+	repository := l.originRepository(origin)
+
+	manifest, err := l.client.GetManifest(ctx, repository, tagName(id))
+	if err != nil {
+		return nil, err
+	}
+
+	l.blobsMutex.Lock()
+	for _, layer := range manifest.Layers {
+		l.blobs[layer.Digest] = repository
+	}
+	l.blobsMutex.Unlock()
+
+	return &SnapshotReader{
+		library: l,
+		index:   oci.NewSnapshotIndex(*manifest),
+	}, nil
+}
+
+// ReadArtifact implements LibraryReader.
+func (l *Library) ReadArtifact(ctx context.Context, digest string) (libraries.ArtifactReader, error) {
+	l.blobsMutex.RLock()
+	repository, ok := l.blobs[digest]
+	l.blobsMutex.RUnlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	body, err := l.client.GetBlob(ctx, repository, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+
+	return &ArtifactReader{
+		body:   body,
+		hash:   hash,
+		reader: io.TeeReader(body, hash),
+	}, nil
+}
+
+// WriteSnapshot implements LibraryWriter.
+func (l *Library) WriteSnapshot(ctx context.Context, origin string, id string) (libraries.SnapshotWriter, error) {
+	return &SnapshotWriter{
+		library: l,
+		origin:  origin,
+		id:      id,
+	}, nil
+}
+
+// Close implements LibraryReader.
+func (l *Library) Close() error {
+	return nil
+}
+
+// pushBlob pushes a blob to a repository, unless it already exists.
+func (l *Library) pushBlob(ctx context.Context, repository string, digest string, body io.ReadSeeker) error {
+	exists, err := l.client.HasBlob(ctx, repository, digest)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if err := l.client.PushBlob(ctx, repository, digest, body); err != nil {
+			return err
+		}
+	}
+
+	l.blobsMutex.Lock()
+	l.blobs[digest] = repository
+	l.blobsMutex.Unlock()
+
+	return nil
+}
+
+// linkBlob makes a blob available in a repository, if it exists in the
+// registry. Blobs known to exist in other repositories are mounted.
+func (l *Library) linkBlob(ctx context.Context, repository string, digest string) error {
+	exists, err := l.client.HasBlob(ctx, repository, digest)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		l.blobsMutex.RLock()
+		from, ok := l.blobs[digest]
+		l.blobsMutex.RUnlock()
+		if !ok {
+			return os.ErrNotExist
+		}
+
+		if err := l.client.MountBlob(ctx, repository, digest, from); err != nil {
+			return err
+		}
+	}
+
+	l.blobsMutex.Lock()
+	l.blobs[digest] = repository
+	l.blobsMutex.Unlock()
+
+	return nil
+}
+
+// writeManifest adds an artifact to a snapshot's manifest and tags the
+// snapshot's origin.
+func (l *Library) writeManifest(ctx context.Context, origin string, id string, artifact libraries.ArtifactManifest) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	repository := l.originRepository(origin)
+
+	snapshotIndex := libraries.SnapshotIndex{
+		Schema:    oci.ArtifactTypeSnapshot,
+		Artifacts: make([]libraries.ArtifactManifest, 0),
+	}
+
+	current, err := l.client.GetManifest(ctx, repository, tagName(id))
+	if err == nil {
+		snapshotIndex = oci.NewSnapshotIndex(*current)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	snapshotIndex.Artifacts = append(snapshotIndex.Artifacts, artifact)
+
+	// Layers must exist, make sure the well-known empty blobs do. The empty
+	// JSON blob is used as the config
+	if artifact.Size == 0 {
+		emptyDigest := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+		if err := l.pushBlob(ctx, repository, emptyDigest, bytes.NewReader(nil)); err != nil {
+			return err
+		}
+	}
+	if err := l.pushBlob(ctx, repository, oci.EmptyJSONDescriptor.Digest, bytes.NewReader(oci.EmptyJSON)); err != nil {
+		return err
+	}
+
+	if err := l.client.PutManifest(ctx, repository, tagName(id), oci.NewManifest(origin, id, snapshotIndex)); err != nil {
+		return err
+	}
+
+	// Tag the origin, unless already tagged
+	_, err = l.client.GetManifest(ctx, l.indexRepository(), tagName(origin))
+	if err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := l.pushBlob(ctx, l.indexRepository(), oci.EmptyJSONDescriptor.Digest, bytes.NewReader(oci.EmptyJSON)); err != nil {
+		return err
+	}
+
+	return l.client.PutManifest(ctx, l.indexRepository(), tagName(origin), oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.MediaTypeImageManifest,
+		ArtifactType:  ArtifactTypeOrigin,
+		Config:        oci.EmptyJSONDescriptor,
+		Layers:        make([]oci.Descriptor, 0),
+		Annotations: map[string]string{
+			oci.AnnotationSnapshotOrigin: origin,
+		},
+	})
+}
+
+// componentName returns a valid repository path component for a name. Runs
+// of other characters than letters and digits are replaced by a separator.
+func componentName(name string) string {
+	var builder strings.Builder
+	// run holds the characters since the last letter or digit
+	run := ""
+	for _, r := range strings.ToLower(name) {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			run += string(r)
+			continue
+		}
+
+		// Separators may not start a component, keep single dots as in hosts
+		if builder.Len() > 0 && run == "." {
+			builder.WriteRune('.')
+		} else if builder.Len() > 0 && run != "" {
+			builder.WriteRune('-')
+		}
+		run = ""
+		builder.WriteRune(r)
+	}
+
+	// Don't clash with the index repository
+	component := builder.String()
+	if component == "" {
+		return "origin"
+	} else if component == "index" {
+		return "origin-index"
+	}
+
+	return component
+}
+
+// tagName returns a valid tag for a name.
+func tagName(name string) string {
+	tag := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+
+	// Tags may not start with a period or dash and are at most 128 characters
+	if strings.HasPrefix(tag, ".") || strings.HasPrefix(tag, "-") {
+		tag = "_" + tag
+	}
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+
+	return tag
+}
+
+var _ libraries.ArtifactReader = (*ArtifactReader)(nil)
+
+type ArtifactReader struct {
+	body   io.ReadCloser
+	hash   hash.Hash
+	reader io.Reader
+}
+
+// Read implements libraries.ArtifactReader.
+func (a *ArtifactReader) Read(p []byte) (n int, err error) {
+	return a.reader.Read(p)
+}
+
+// Close implements libraries.ArtifactReader.
+func (a *ArtifactReader) Close() error {
+	return a.body.Close()
+}
+
+// Digest implements libraries.ArtifactReader.
+func (a *ArtifactReader) Digest() string {
+	return "sha256:" + hex.EncodeToString(a.hash.Sum(nil))
+}
+
+// DeleteSnapshot implements LibraryWriter. The snapshot's manifest is deleted,
+// its blobs are left for the registry to garbage collect. The origin is
+// untagged once its last snapshot is deleted.
+func (l *Library) DeleteSnapshot(ctx context.Context, origin string, id string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	repository := l.originRepository(origin)
+	if err := l.client.DeleteManifest(ctx, repository, tagName(id)); err != nil {
+		return err
+	}
+
+	tags, err := l.client.ListTags(ctx, repository)
+	if err != nil {
+		return err
+	}
+
+	if len(tags) > 0 {
+		return nil
+	}
+
+	err = l.client.DeleteManifest(ctx, l.indexRepository(), tagName(origin))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
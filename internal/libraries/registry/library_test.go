@@ -0,0 +1,247 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry is a minimal, in-memory stand-in of an OCI distribution
+// registry requiring token authentication.
+type testRegistry struct {
+	mutex     sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	pushes    int
+}
+
+var testRegistryPath = regexp.MustCompile(`^/v2/(.+)/(blobs/uploads|blobs|manifests|tags)/(.*)$`)
+
+func (t *testRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": "token"})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="registry",scope="repository:larch/x:pull,push"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	match := testRegistryPath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	repository, kind, reference := match[1], match[2], match[3]
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch {
+	case kind == "blobs/uploads" && r.Method == http.MethodPost && r.URL.Query().Has("mount"):
+		digest := r.URL.Query().Get("mount")
+		data, ok := t.blobs[r.URL.Query().Get("from")+"@"+digest]
+		if !ok {
+			w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/"+uuid.NewString())
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		t.blobs[repository+"@"+digest] = data
+		w.WriteHeader(http.StatusCreated)
+	case kind == "blobs/uploads" && r.Method == http.MethodPost:
+		w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/"+uuid.NewString())
+		w.WriteHeader(http.StatusAccepted)
+	case kind == "blobs/uploads" && r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		hash := sha256.Sum256(data)
+		digest := "sha256:" + hex.EncodeToString(hash[:])
+		if digest != r.URL.Query().Get("digest") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		t.blobs[repository+"@"+digest] = data
+		t.pushes++
+		w.WriteHeader(http.StatusCreated)
+	case kind == "blobs":
+		data, ok := t.blobs[repository+"@"+reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	case kind == "manifests" && r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		var manifest struct {
+			Config struct{ Digest string }
+			Layers []struct{ Digest string }
+		}
+		_ = json.Unmarshal(data, &manifest)
+
+		// Referenced blobs must exist
+		digests := []string{manifest.Config.Digest}
+		for _, layer := range manifest.Layers {
+			digests = append(digests, layer.Digest)
+		}
+		for _, digest := range digests {
+			if _, ok := t.blobs[repository+"@"+digest]; !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		t.manifests[repository+":"+reference] = data
+		w.WriteHeader(http.StatusCreated)
+	case kind == "manifests" && r.Method == http.MethodDelete:
+		// Manifests are deleted by digest, along with all of their tags
+		deleted := false
+		for key, data := range t.manifests {
+			hash := sha256.Sum256(data)
+			if strings.HasPrefix(key, repository+":") && "sha256:"+hex.EncodeToString(hash[:]) == reference {
+				delete(t.manifests, key)
+				deleted = true
+			}
+		}
+		if !deleted {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case kind == "manifests":
+		data, ok := t.manifests[repository+":"+reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		hash := sha256.Sum256(data)
+		w.Header().Set("Docker-Content-Digest", "sha256:"+hex.EncodeToString(hash[:]))
+		_, _ = w.Write(data)
+	case kind == "tags":
+		tags := make([]string, 0)
+		for key := range t.manifests {
+			if tag, ok := strings.CutPrefix(key, repository+":"); ok {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slices.Sort(tags)
+		_ = json.NewEncoder(w).Encode(map[string]any{"name": repository, "tags": tags})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	registry := &testRegistry{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	library := NewLibrary(&Client{Endpoint: server.URL, Username: "user", Password: "password"}, "larch")
+
+	data := []byte("<html><body>Hello, World!</body></html>")
+
+	var digest string
+	for _, id := range []string{"1", "2"} {
+		snapshotWriter, err := library.WriteSnapshot(context.TODO(), "Example.com:8080", id)
+		require.NoError(t, err)
+
+		require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "application/vnd.larch.snapshot.manifest.v1+json",
+			Digest:      "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		}))
+
+		writer, err := snapshotWriter.NextArtifactWriter(context.TODO(), "singlefile.html")
+		require.NoError(t, err)
+		_, err = writer.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		digest = writer.Digest()
+
+		require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "text/html",
+			Digest:      digest,
+			Size:        int64(len(data)),
+		}))
+		require.NoError(t, snapshotWriter.Close())
+	}
+
+	// Existing blobs are not pushed again. Empty blob, empty JSON, artifact and
+	// the empty JSON of the index repository
+	assert.Equal(t, 4, registry.pushes)
+
+	// Read using a new library, as a different instance would
+	library = NewLibrary(&Client{Endpoint: server.URL, Username: "user", Password: "password"}, "larch")
+
+	origins, err := library.GetOrigins(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Example.com:8080"}, origins)
+
+	snapshots, err := library.GetSnapshots(context.TODO(), "Example.com:8080")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, snapshots)
+
+	snapshotReader, err := library.ReadSnapshot(context.TODO(), "Example.com:8080", "2")
+	require.NoError(t, err)
+	defer snapshotReader.Close()
+
+	index := snapshotReader.Index()
+	require.Len(t, index.Artifacts, 2)
+	assert.Equal(t, digest, index.Artifacts[1].Digest)
+
+	reader, err := snapshotReader.NextArtifactReader(context.TODO(), digest)
+	require.NoError(t, err)
+	actual, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, data, actual)
+	assert.Equal(t, digest, reader.Digest())
+
+	// Deleting a snapshot untags it, the origin is untagged along with its last
+	// snapshot
+	require.NoError(t, library.DeleteSnapshot(context.TODO(), "Example.com:8080", "1"))
+	assert.ErrorIs(t, library.DeleteSnapshot(context.TODO(), "Example.com:8080", "1"), os.ErrNotExist)
+
+	snapshots, err = library.GetSnapshots(context.TODO(), "Example.com:8080")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2"}, snapshots)
+
+	require.NoError(t, library.DeleteSnapshot(context.TODO(), "Example.com:8080", "2"))
+
+	origins, err = library.GetOrigins(context.TODO())
+	require.NoError(t, err)
+	assert.Empty(t, origins)
+}
+
+func TestComponentName(t *testing.T) {
+	assert.Equal(t, "example.com-8080", componentName("Example.com:8080"))
+	assert.Equal(t, "1-8080", componentName("[::1]:8080"))
+	assert.Equal(t, "origin-index", componentName("index"))
+	assert.Equal(t, "origin", componentName(""))
+}
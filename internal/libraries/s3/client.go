@@ -0,0 +1,365 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client is a minimal client of the S3 API, compatible with S3-compatible
+// object storage such as MinIO.
+type Client struct {
+	// Endpoint is the service's base URL, such as
+	// https://s3.eu-north-1.amazonaws.com or http://minio:9000.
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket as part of the path rather than as a
+	// subdomain of the endpoint.
+	PathStyle bool
+	Client    *http.Client
+}
+
+// objectURL returns the URL of an object.
+func (c *Client) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.PathStyle {
+		u.Path += "/" + c.Bucket + "/" + key
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+		u.Path += "/" + key
+	}
+
+	return u, nil
+}
+
+func (c *Client) credentials() credentials {
+	return credentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		Region:          c.Region,
+	}
+}
+
+// do performs a signed request of an object. Any body is sent unsigned.
+func (c *Client) do(ctx context.Context, method string, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u, err := c.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	// Hide any Close method of the body, it's owned by the caller
+	var reader io.Reader
+	if body != nil {
+		reader = struct{ io.Reader }{body}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	c.credentials().sign(req, time.Now())
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+// responseError returns an error describing an unexpected response.
+func responseError(res *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(res.Body, 64*1024)).Decode(&body); err == nil && body.Code != "" {
+		return fmt.Errorf("unexpected status code: %d: %s: %s", res.StatusCode, body.Code, body.Message)
+	}
+
+	return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+}
+
+// HeadObject returns whether or not an object exists.
+func (c *Client) HeadObject(ctx context.Context, key string) (bool, error) {
+	res, err := c.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+}
+
+// GetObject returns a reader of an object.
+func (c *Client) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := c.do(ctx, http.MethodGet, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Body, nil
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, os.ErrNotExist
+	default:
+		defer res.Body.Close()
+		return nil, responseError(res)
+	}
+}
+
+// PutObject uploads an object in a single request.
+func (c *Client) PutObject(ctx context.Context, key string, contentType string, body io.Reader, size int64) error {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	res, err := c.do(ctx, http.MethodPut, key, nil, header, body, size)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return responseError(res)
+	}
+
+	return nil
+}
+
+// DeleteObject deletes an object. Deleting an object that does not exist is
+// not an error.
+func (c *Client) DeleteObject(ctx context.Context, key string) error {
+	res, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return responseError(res)
+	}
+
+	return nil
+}
+
+// ListObjects lists the keys and common prefixes of objects with a prefix,
+// grouped by the delimiter, if any.
+func (c *Client) ListObjects(ctx context.Context, prefix string, delimiter string) ([]string, []string, error) {
+	keys := make([]string, 0)
+	prefixes := make([]string, 0)
+
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		res, err := c.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			err := responseError(res)
+			res.Body.Close()
+			return nil, nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			CommonPrefixes []struct {
+				Prefix string `xml:"Prefix"`
+			} `xml:"CommonPrefixes"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, content := range result.Contents {
+			keys = append(keys, content.Key)
+		}
+		for _, prefix := range result.CommonPrefixes {
+			prefixes = append(prefixes, prefix.Prefix)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		continuationToken = result.NextContinuationToken
+	}
+
+	return keys, prefixes, nil
+}
+
+// CreateMultipartUpload starts a multipart upload and returns its id.
+func (c *Client) CreateMultipartUpload(ctx context.Context, key string, contentType string) (string, error) {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	res, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", responseError(res)
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	return result.UploadID, nil
+}
+
+// UploadPart uploads a part of a multipart upload and returns its ETag.
+// Parts are numbered from 1.
+func (c *Client) UploadPart(ctx context.Context, key string, uploadID string, number int, body io.Reader, size int64) (string, error) {
+	query := url.Values{}
+	query.Set("partNumber", strconv.Itoa(number))
+	query.Set("uploadId", uploadID)
+
+	res, err := c.do(ctx, http.MethodPut, key, query, nil, body, size)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", responseError(res)
+	}
+
+	return res.Header.Get("ETag"), nil
+}
+
+// CompleteMultipartUpload completes a multipart upload of the parts, by their
+// ETags in order.
+func (c *Client) CompleteMultipartUpload(ctx context.Context, key string, uploadID string, etags []string) error {
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+
+	request := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range etags {
+		request.Parts = append(request.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return responseError(res)
+	}
+
+	// Errors may be returned after the status code has been sent
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&result); err == nil && result.XMLName.Local == "Error" {
+		return fmt.Errorf("failed to complete multipart upload: %s: %s", result.Code, result.Message)
+	}
+
+	return nil
+}
+
+// AbortMultipartUpload aborts a multipart upload, removing uploaded parts.
+func (c *Client) AbortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	res, err := c.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return responseError(res)
+	}
+
+	return nil
+}
+
+// PresignGetObject returns a presigned URL of an object, valid for the
+// duration. The content type to respond with may optionally be overridden.
+func (c *Client) PresignGetObject(key string, contentType string, expires time.Duration) (string, error) {
+	u, err := c.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	if contentType != "" {
+		u.RawQuery = url.Values{"response-content-type": {contentType}}.Encode()
+	}
+
+	return c.credentials().presign(u, time.Now(), expires), nil
+}
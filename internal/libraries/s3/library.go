@@ -0,0 +1,342 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.LibraryWriter = (*Library)(nil)
+var _ libraries.LibraryReader = (*Library)(nil)
+var _ libraries.RedirectLibraryReader = (*Library)(nil)
+
+// DefaultPartSize is the default size of parts of multipart uploads. Artifacts
+// larger than the part size are uploaded in parts.
+const DefaultPartSize = 16 * 1024 * 1024
+
+// Library stores snapshots in an S3-compatible bucket. Blobs are
+// content-addressed and shared between snapshots.
+//
+//	<prefix>/blobs/sha256/xx/yy/xxyy...
+//	<prefix>/snapshots/example.com/1231231231/index.json
+type Library struct {
+	client  *Client
+	options LibraryOptions
+	// mutex guards index writes
+	mutex sync.Mutex
+}
+
+type LibraryOptions struct {
+	// Prefix is the key prefix of all objects, such as larch.
+	Prefix string
+	// PartSize is the size of parts of multipart uploads. Defaults to
+	// [DefaultPartSize]. S3 requires parts of at least 5MiB.
+	PartSize int64
+	// Redirect lets clients read blobs directly from the bucket using presigned
+	// URLs valid for the duration. Redirects are disabled if zero.
+	Redirect time.Duration
+}
+
+func NewLibrary(client *Client, options *LibraryOptions) *Library {
+	if options == nil {
+		options = &LibraryOptions{}
+	}
+
+	o := *options
+	o.Prefix = strings.Trim(o.Prefix, "/")
+	if o.PartSize == 0 {
+		o.PartSize = DefaultPartSize
+	}
+
+	return &Library{
+		client:  client,
+		options: o,
+	}
+}
+
+// key returns the key of an object, relative to the prefix.
+func (l *Library) key(name string) string {
+	if l.options.Prefix == "" {
+		return name
+	}
+
+	return l.options.Prefix + "/" + name
+}
+
+// blobKey returns the key of a blob.
+func (l *Library) blobKey(digest string) (string, error) {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" || len(hex) != 64 || strings.ContainsAny(hex, "/.") {
+		return "", fmt.Errorf("invalid digest")
+	}
+
+	return l.key("blobs/sha256/" + hex[0:2] + "/" + hex[2:4] + "/" + hex), nil
+}
+
+// indexKey returns the key of a snapshot's index.
+func (l *Library) indexKey(origin string, id string) (string, error) {
+	if origin == "" || id == "" || strings.Contains(origin, "/") || strings.Contains(id, "/") || origin == ".." || id == ".." {
+		return "", fmt.Errorf("invalid snapshot")
+	}
+
+	return l.key("snapshots/" + origin + "/" + id + "/index.json"), nil
+}
+
+// GetOrigins implements LibraryReader.
+func (l *Library) GetOrigins(ctx context.Context) ([]string, error) {
+	prefix := l.key("snapshots/")
+
+	_, prefixes, err := l.client.ListObjects(ctx, prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	origins := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		origins = append(origins, strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/"))
+	}
+
+	return origins, nil
+}
+
+// GetSnapshots implements LibraryReader.
+func (l *Library) GetSnapshots(ctx context.Context, origin string) ([]string, error) {
+	if origin == "" || strings.Contains(origin, "/") {
+		return nil, fmt.Errorf("invalid origin")
+	}
+
+	prefix := l.key("snapshots/" + origin + "/")
+
+	_, prefixes, err := l.client.ListObjects(ctx, prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		snapshots = append(snapshots, strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/"))
+	}
+
+	return snapshots, nil
+}
+
+// ReadSnapshot implements LibraryReader.
+func (l *Library) ReadSnapshot(ctx context.Context, origin string, id string) (libraries.SnapshotReader, error) {
+	index, err := l.readIndex(ctx, origin, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotReader{
+		library: l,
+		index:   *index,
+	}, nil
+}
+
+// ReadArtifact implements LibraryReader.
+func (l *Library) ReadArtifact(ctx context.Context, digest string) (libraries.ArtifactReader, error) {
+	key, err := l.blobKey(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := l.client.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+
+	return &ArtifactReader{
+		body:   body,
+		hash:   hash,
+		reader: io.TeeReader(body, hash),
+	}, nil
+}
+
+// ArtifactURL implements RedirectLibraryReader.
+func (l *Library) ArtifactURL(ctx context.Context, digest string, contentType string) (string, error) {
+	if l.options.Redirect == 0 {
+		return "", nil
+	}
+
+	key, err := l.blobKey(digest)
+	if err != nil {
+		return "", err
+	}
+
+	return l.client.PresignGetObject(key, contentType, l.options.Redirect)
+}
+
+// WriteSnapshot implements LibraryWriter.
+func (l *Library) WriteSnapshot(ctx context.Context, origin string, id string) (libraries.SnapshotWriter, error) {
+	if _, err := l.indexKey(origin, id); err != nil {
+		return nil, err
+	}
+
+	return &SnapshotWriter{
+		library: l,
+		origin:  origin,
+		id:      id,
+	}, nil
+}
+
+// Close implements LibraryReader.
+func (l *Library) Close() error {
+	return nil
+}
+
+// readIndex reads a snapshot's index.
+func (l *Library) readIndex(ctx context.Context, origin string, id string) (*libraries.SnapshotIndex, error) {
+	key, err := l.indexKey(origin, id)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := l.client.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var index libraries.SnapshotIndex
+	if err := json.NewDecoder(body).Decode(&index); err != nil {
+		return nil, err
+	}
+
+	return &index, nil
+}
+
+// writeManifest adds an artifact to a snapshot's index.
+func (l *Library) writeManifest(ctx context.Context, origin string, id string, artifact libraries.ArtifactManifest) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	key, err := l.indexKey(origin, id)
+	if err != nil {
+		return err
+	}
+
+	index, err := l.readIndex(ctx, origin, id)
+	if errors.Is(err, os.ErrNotExist) {
+		index = &libraries.SnapshotIndex{
+			Schema:    "application/vnd.larch.snapshot.index.v1+json",
+			Artifacts: make([]libraries.ArtifactManifest, 0),
+		}
+	} else if err != nil {
+		return err
+	}
+
+	index.Artifacts = append(index.Artifacts, artifact)
+
+	body, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	return l.client.PutObject(ctx, key, "application/json", bytes.NewReader(body), int64(len(body)))
+}
+
+// pushBlob uploads a blob, unless it already exists. Blobs larger than the part
+// size are uploaded in parts.
+func (l *Library) pushBlob(ctx context.Context, digest string, body io.ReaderAt, size int64) error {
+	key, err := l.blobKey(digest)
+	if err != nil {
+		return err
+	}
+
+	exists, err := l.client.HeadObject(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	if size <= l.options.PartSize {
+		return l.client.PutObject(ctx, key, "application/octet-stream", io.NewSectionReader(body, 0, size), size)
+	}
+
+	uploadID, err := l.client.CreateMultipartUpload(ctx, key, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+
+	etags := make([]string, 0)
+	for offset := int64(0); offset < size; offset += l.options.PartSize {
+		partSize := min(l.options.PartSize, size-offset)
+
+		etag, err := l.client.UploadPart(ctx, key, uploadID, len(etags)+1, io.NewSectionReader(body, offset, partSize), partSize)
+		if err != nil {
+			// Best effort, incomplete uploads are otherwise kept until aborted
+			_ = l.client.AbortMultipartUpload(context.Background(), key, uploadID)
+			return err
+		}
+
+		etags = append(etags, etag)
+	}
+
+	if err := l.client.CompleteMultipartUpload(ctx, key, uploadID, etags); err != nil {
+		_ = l.client.AbortMultipartUpload(context.Background(), key, uploadID)
+		return err
+	}
+
+	return nil
+}
+
+var _ libraries.ArtifactReader = (*ArtifactReader)(nil)
+
+type ArtifactReader struct {
+	body   io.ReadCloser
+	hash   hash.Hash
+	reader io.Reader
+}
+
+// Read implements libraries.ArtifactReader.
+func (a *ArtifactReader) Read(p []byte) (n int, err error) {
+	return a.reader.Read(p)
+}
+
+// Close implements libraries.ArtifactReader.
+func (a *ArtifactReader) Close() error {
+	return a.body.Close()
+}
+
+// Digest implements libraries.ArtifactReader.
+func (a *ArtifactReader) Digest() string {
+	return "sha256:" + hex.EncodeToString(a.hash.Sum(nil))
+}
+
+// DeleteSnapshot implements LibraryWriter. The snapshot's index is deleted, its
+// blobs are left in the bucket.
+func (l *Library) DeleteSnapshot(ctx context.Context, origin string, id string) error {
+	key, err := l.indexKey(origin, id)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ok, err := l.client.HeadObject(ctx, key)
+	if err != nil {
+		return err
+	} else if !ok {
+		return os.ErrNotExist
+	}
+
+	return l.client.DeleteObject(ctx, key)
+}
@@ -0,0 +1,251 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBucket is a minimal, in-memory stand-in of an S3-compatible bucket,
+// addressed using path-style requests.
+type testBucket struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	puts    int
+}
+
+func (t *testBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") && r.URL.Query().Get("X-Amz-Signature") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok && r.URL.Path != "/bucket" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		t.list(w, query.Get("prefix"), query.Get("delimiter"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID := uuid.NewString()
+		t.uploads[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := t.uploads[query.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number], _ = io.ReadAll(r.Body)
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, number))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := t.uploads[query.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var request struct {
+			Parts []struct {
+				PartNumber int `xml:"PartNumber"`
+			} `xml:"Part"`
+		}
+		_ = xml.NewDecoder(r.Body).Decode(&request)
+		data := make([]byte, 0)
+		for _, part := range request.Parts {
+			data = append(data, parts[part.PartNumber]...)
+		}
+		t.objects[key] = data
+		t.puts++
+		delete(t.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(t.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		t.objects[key], _ = io.ReadAll(r.Body)
+		t.puts++
+	case r.Method == http.MethodDelete:
+		delete(t.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := t.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (t *testBucket) list(w http.ResponseWriter, prefix string, delimiter string) {
+	keys := make([]string, 0)
+	prefixes := make([]string, 0)
+	for key := range t.objects {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			if p := prefix + rest[:i+len(delimiter)]; !slices.Contains(prefixes, p) {
+				prefixes = append(prefixes, p)
+			}
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	slices.Sort(prefixes)
+
+	fmt.Fprint(w, "<ListBucketResult>")
+	for _, key := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", key)
+	}
+	for _, prefix := range prefixes {
+		fmt.Fprintf(w, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", prefix)
+	}
+	fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+}
+
+func TestLibrary(t *testing.T) {
+	bucket := &testBucket{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+
+	client := &Client{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "bucket",
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+	}
+
+	// Use a small part size to upload the artifact in parts
+	library := NewLibrary(client, &LibraryOptions{Prefix: "larch", PartSize: 16, Redirect: time.Minute})
+
+	data := []byte("<html><body>Hello, World!</body></html>")
+
+	var digest string
+	for _, id := range []string{"1", "2"} {
+		snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com:8080", id)
+		require.NoError(t, err)
+
+		require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "application/vnd.larch.snapshot.manifest.v1+json",
+			Digest:      "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		}))
+
+		writer, err := snapshotWriter.NextArtifactWriter(context.TODO(), "singlefile.html")
+		require.NoError(t, err)
+		_, err = writer.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		digest = writer.Digest()
+
+		require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "text/html",
+			Digest:      digest,
+			Size:        int64(len(data)),
+		}))
+		require.NoError(t, snapshotWriter.Close())
+	}
+
+	// Existing blobs are not uploaded again. Two index writes per snapshot and
+	// the artifact
+	assert.Equal(t, 5, bucket.puts)
+	assert.Empty(t, bucket.uploads)
+	assert.Contains(t, bucket.objects, "larch/blobs/sha256/"+digest[7:9]+"/"+digest[9:11]+"/"+digest[7:])
+
+	origins, err := library.GetOrigins(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com:8080"}, origins)
+
+	snapshots, err := library.GetSnapshots(context.TODO(), "example.com:8080")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, snapshots)
+
+	snapshotReader, err := library.ReadSnapshot(context.TODO(), "example.com:8080", "2")
+	require.NoError(t, err)
+	defer snapshotReader.Close()
+
+	index := snapshotReader.Index()
+	require.Len(t, index.Artifacts, 2)
+	assert.Equal(t, digest, index.Artifacts[1].Digest)
+
+	reader, err := snapshotReader.NextArtifactReader(context.TODO(), digest)
+	require.NoError(t, err)
+	actual, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, data, actual)
+	assert.Equal(t, digest, reader.Digest())
+
+	// Artifacts may be read directly from the bucket
+	artifactURL, err := library.ArtifactURL(context.TODO(), digest, "text/html")
+	require.NoError(t, err)
+
+	u, err := url.Parse(artifactURL)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", u.Query().Get("response-content-type"))
+	assert.Equal(t, "60", u.Query().Get("X-Amz-Expires"))
+
+	res, err := http.Get(artifactURL)
+	require.NoError(t, err)
+	actual, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, data, actual)
+
+	_, err = library.ReadArtifact(context.TODO(), "sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Deleting a snapshot leaves its blobs, which may be shared, behind
+	require.NoError(t, library.DeleteSnapshot(context.TODO(), "example.com:8080", "1"))
+	assert.ErrorIs(t, library.DeleteSnapshot(context.TODO(), "example.com:8080", "1"), os.ErrNotExist)
+
+	snapshots, err = library.GetSnapshots(context.TODO(), "example.com:8080")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2"}, snapshots)
+
+	_, err = library.ReadSnapshot(context.TODO(), "example.com:8080", "1")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	reader, err = library.ReadArtifact(context.TODO(), digest)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+}
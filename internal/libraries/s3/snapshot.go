@@ -0,0 +1,141 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.SnapshotReader = (*SnapshotReader)(nil)
+
+type SnapshotReader struct {
+	library *Library
+	index   libraries.SnapshotIndex
+}
+
+// Index implements SnapshotReader.
+func (s *SnapshotReader) Index() libraries.SnapshotIndex {
+	return s.index
+}
+
+// NextArtifactReader implements SnapshotReader.
+func (s *SnapshotReader) NextArtifactReader(ctx context.Context, digest string) (libraries.ArtifactReader, error) {
+	return s.library.ReadArtifact(ctx, digest)
+}
+
+// Close implements SnapshotReader.
+func (s *SnapshotReader) Close() error {
+	return nil
+}
+
+var _ libraries.LinkingSnapshotWriter = (*SnapshotWriter)(nil)
+
+type SnapshotWriter struct {
+	library *Library
+	origin  string
+	id      string
+}
+
+// NextArtifactWriter implements SnapshotWriter.
+func (s *SnapshotWriter) NextArtifactWriter(ctx context.Context, name string) (libraries.ArtifactWriter, error) {
+	// The digest must be known before uploading, buffer the artifact
+	tempFile, err := os.CreateTemp("", "larch-temp-*")
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+
+	return &ArtifactWriter{
+		ctx:      ctx,
+		library:  s.library,
+		tempFile: tempFile,
+		hash:     hash,
+		writer:   io.MultiWriter(tempFile, hash),
+	}, nil
+}
+
+// WriteArtifact implements SnapshotWriter.
+func (s *SnapshotWriter) WriteArtifact(ctx context.Context, name string, data []byte) (int64, string, error) {
+	hash := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+
+	if err := s.library.pushBlob(ctx, digest, bytes.NewReader(data), int64(len(data))); err != nil {
+		return 0, "", err
+	}
+
+	return int64(len(data)), digest, nil
+}
+
+// LinkArtifact implements LinkingSnapshotWriter.
+func (s *SnapshotWriter) LinkArtifact(ctx context.Context, name string, digest string) error {
+	key, err := s.library.blobKey(digest)
+	if err != nil {
+		return err
+	}
+
+	exists, err := s.library.client.HeadObject(ctx, key)
+	if err != nil {
+		return err
+	} else if !exists {
+		return os.ErrNotExist
+	}
+
+	return nil
+}
+
+// WriteArtifactManifest implements SnapshotWriter.
+func (s *SnapshotWriter) WriteArtifactManifest(ctx context.Context, manifest libraries.ArtifactManifest) error {
+	return s.library.writeManifest(ctx, s.origin, s.id, manifest)
+}
+
+// Close implements SnapshotWriter.
+func (s *SnapshotWriter) Close() error {
+	return nil
+}
+
+var _ libraries.AbortableArtifactWriter = (*ArtifactWriter)(nil)
+
+type ArtifactWriter struct {
+	ctx      context.Context
+	library  *Library
+	tempFile *os.File
+	size     int64
+	hash     hash.Hash
+	digest   string
+	writer   io.Writer
+}
+
+// Write implements libraries.ArtifactWriter.
+func (a *ArtifactWriter) Write(p []byte) (n int, err error) {
+	n, err = a.writer.Write(p)
+	a.size += int64(n)
+	return n, err
+}
+
+// Abort implements libraries.AbortableArtifactWriter.
+func (a *ArtifactWriter) Abort() error {
+	defer os.Remove(a.tempFile.Name())
+	return a.tempFile.Close()
+}
+
+// Close implements libraries.ArtifactWriter.
+func (a *ArtifactWriter) Close() error {
+	defer os.Remove(a.tempFile.Name())
+	defer a.tempFile.Close()
+
+	a.digest = "sha256:" + hex.EncodeToString(a.hash.Sum(nil))
+
+	return a.library.pushBlob(a.ctx, a.digest, a.tempFile, a.size)
+}
+
+// Digest implements libraries.ArtifactWriter.
+func (a *ArtifactWriter) Digest() string {
+	return a.digest
+}
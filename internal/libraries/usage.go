@@ -0,0 +1,93 @@
+package libraries
+
+import (
+	"context"
+)
+
+// UsageReporter is implemented by libraries that can report their usage more
+// accurately than by reading all snapshot indexes, see [ComputeUsage].
+type UsageReporter interface {
+	// Usage returns the storage usage of the library.
+	Usage(context.Context) (*Usage, error)
+}
+
+type Usage struct {
+	// Bytes is the number of bytes stored. Blobs shared between snapshots are
+	// counted once.
+	Bytes int64 `json:"bytes"`
+	// LogicalBytes is the size of all snapshots' artifacts, as if no blobs were
+	// shared.
+	LogicalBytes int64 `json:"logicalBytes"`
+	// Blobs is the number of blobs stored.
+	Blobs int `json:"blobs"`
+	// Snapshots is the number of snapshots stored.
+	Snapshots int `json:"snapshots"`
+	// Origins holds the logical bytes of snapshots, by origin.
+	Origins map[string]int64 `json:"origins"`
+}
+
+// ComputeUsage returns the storage usage of a library. Libraries implementing
+// [UsageReporter] report their own usage. For other libraries, the index of
+// every snapshot is read and blobs are assumed to be stored once per library,
+// unencoded.
+func ComputeUsage(ctx context.Context, library LibraryReader) (*Usage, error) {
+	if reporter, ok := library.(UsageReporter); ok {
+		return reporter.Usage(ctx)
+	}
+
+	return ComputeLogicalUsage(ctx, library)
+}
+
+// ComputeLogicalUsage returns the storage usage of a library based on the
+// index of every snapshot. Unreadable snapshots are not accounted for.
+func ComputeLogicalUsage(ctx context.Context, library LibraryReader) (*Usage, error) {
+	usage := &Usage{
+		Origins: make(map[string]int64),
+	}
+
+	blobs := make(map[string]struct{})
+
+	origins, err := library.GetOrigins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, origin := range origins {
+		snapshots, err := library.GetSnapshots(ctx, origin)
+		if err != nil {
+			return nil, err
+		}
+
+		usage.Origins[origin] = 0
+		for _, id := range snapshots {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			snapshotReader, err := library.ReadSnapshot(ctx, origin, id)
+			if err != nil {
+				continue
+			}
+			index := snapshotReader.Index()
+			snapshotReader.Close()
+
+			usage.Snapshots++
+			for _, artifact := range index.Artifacts {
+				if artifact.Digest == EmptyDigest {
+					continue
+				}
+
+				usage.LogicalBytes += artifact.Size
+				usage.Origins[origin] += artifact.Size
+
+				if _, ok := blobs[artifact.Digest]; !ok {
+					blobs[artifact.Digest] = struct{}{}
+					usage.Blobs++
+					usage.Bytes += artifact.Size
+				}
+			}
+		}
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,182 @@
+package libraries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// EmptyDigest is the digest of empty content. Artifacts of no content, such
+// as the snapshot manifest, are not stored.
+const EmptyDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Problems found when verifying a library.
+const (
+	// ProblemInvalidIndex is a snapshot index that is missing or can't be
+	// parsed.
+	ProblemInvalidIndex = "invalidIndex"
+	// ProblemMissingBlob is an artifact whose blob is not stored.
+	ProblemMissingBlob = "missingBlob"
+	// ProblemCorruptBlob is a blob whose content does not match its digest.
+	ProblemCorruptBlob = "corruptBlob"
+	// ProblemSizeMismatch is an artifact whose blob is not of the recorded
+	// size.
+	ProblemSizeMismatch = "sizeMismatch"
+	// ProblemBrokenSymlink is a convenience symlink whose target is missing.
+	ProblemBrokenSymlink = "brokenSymlink"
+	// ProblemPartialSnapshot is a snapshot whose writing was interrupted, see
+	// [SnapshotIndex.Partial].
+	ProblemPartialSnapshot = "partialSnapshot"
+)
+
+// Verifier is implemented by libraries that can verify their integrity in
+// more depth than reading all artifacts, see [Verify].
+type Verifier interface {
+	// Verify verifies the integrity of the library.
+	Verify(context.Context, VerifyOptions) (*VerificationReport, error)
+}
+
+type VerifyOptions struct {
+	// Repair repairs problems that can be repaired without loss, such as
+	// convenience symlinks.
+	Repair bool
+	// Quarantine moves corrupt blobs out of the library, for manual inspection.
+	Quarantine bool
+}
+
+type VerificationReport struct {
+	// Snapshots is the number of verified snapshots.
+	Snapshots int `json:"snapshots"`
+	// Blobs is the number of verified blobs.
+	Blobs    int       `json:"blobs"`
+	Problems []Problem `json:"problems"`
+}
+
+type Problem struct {
+	// Type is the type of problem, such as [ProblemCorruptBlob].
+	Type     string `json:"type"`
+	Origin   string `json:"origin,omitempty"`
+	Snapshot string `json:"snapshot,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+	// Path is the path of the problematic file, if any, relative to the
+	// library.
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+	// Repaired is whether or not the problem was repaired.
+	Repaired bool `json:"repaired,omitempty"`
+	// Quarantined is whether or not the blob was quarantined.
+	Quarantined bool `json:"quarantined,omitempty"`
+}
+
+// Verify verifies the integrity of a library. Libraries implementing [Verifier]
+// verify themselves. For other libraries, the index of every snapshot is read
+// and every referenced artifact is read and compared to its recorded digest
+// and size. Problems can't be repaired for other libraries.
+func Verify(ctx context.Context, library LibraryReader, options VerifyOptions) (*VerificationReport, error) {
+	if verifier, ok := library.(Verifier); ok {
+		return verifier.Verify(ctx, options)
+	}
+
+	report := &VerificationReport{
+		Problems: make([]Problem, 0),
+	}
+
+	// verified holds the size of verified blobs by digest, or -1 for blobs with
+	// problems
+	verified := make(map[string]int64)
+
+	origins, err := library.GetOrigins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, origin := range origins {
+		snapshots, err := library.GetSnapshots(ctx, origin)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range snapshots {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			report.Snapshots++
+
+			snapshotReader, err := library.ReadSnapshot(ctx, origin, id)
+			if err != nil {
+				report.Problems = append(report.Problems, Problem{
+					Type:     ProblemInvalidIndex,
+					Origin:   origin,
+					Snapshot: id,
+					Message:  err.Error(),
+				})
+				continue
+			}
+
+			index := snapshotReader.Index()
+			snapshotReader.Close()
+
+			for _, artifact := range index.Artifacts {
+				if artifact.Digest == EmptyDigest {
+					continue
+				}
+
+				size, ok := verified[artifact.Digest]
+				if !ok {
+					report.Blobs++
+
+					var problem *Problem
+					size, problem = verifyArtifact(ctx, library, artifact.Digest)
+					if problem != nil {
+						problem.Origin = origin
+						problem.Snapshot = id
+						report.Problems = append(report.Problems, *problem)
+						size = -1
+					}
+					verified[artifact.Digest] = size
+				}
+
+				if size >= 0 && size != artifact.Size {
+					report.Problems = append(report.Problems, Problem{
+						Type:     ProblemSizeMismatch,
+						Origin:   origin,
+						Snapshot: id,
+						Digest:   artifact.Digest,
+						Message:  fmt.Sprintf("expected %d bytes, got %d", artifact.Size, size),
+					})
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// verifyArtifact reads an artifact, returning its size or the problem found.
+func verifyArtifact(ctx context.Context, library LibraryReader, digest string) (int64, *Problem) {
+	reader, err := library.ReadArtifact(ctx, digest)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, &Problem{Type: ProblemMissingBlob, Digest: digest, Message: "blob does not exist"}
+	} else if err != nil {
+		return 0, &Problem{Type: ProblemMissingBlob, Digest: digest, Message: err.Error()}
+	}
+
+	size, err := io.Copy(io.Discard, reader)
+	if err != nil {
+		reader.Close()
+		return 0, &Problem{Type: ProblemCorruptBlob, Digest: digest, Message: err.Error()}
+	}
+
+	if err := reader.Close(); err != nil {
+		return 0, &Problem{Type: ProblemCorruptBlob, Digest: digest, Message: err.Error()}
+	}
+
+	if actual := reader.Digest(); actual != digest {
+		return 0, &Problem{Type: ProblemCorruptBlob, Digest: digest, Message: fmt.Sprintf("content has digest %s", actual)}
+	}
+
+	return size, nil
+}
@@ -0,0 +1,50 @@
+package warc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.ArtifactReader = (*ArtifactReader)(nil)
+
+type ArtifactReader struct {
+	record *recordReader
+	hash   hash.Hash
+	reader io.Reader
+}
+
+// NewArtifactReader returns a reader of the block of a resource record.
+func NewArtifactReader(root *os.Root, location recordLocation) (*ArtifactReader, error) {
+	record, err := openRecord(root, location)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+
+	return &ArtifactReader{
+		record: record,
+		hash:   hash,
+		reader: io.TeeReader(record, hash),
+	}, nil
+}
+
+// Read implements libraries.ArtifactReader.
+func (a *ArtifactReader) Read(p []byte) (n int, err error) {
+	return a.reader.Read(p)
+}
+
+// Close implements libraries.ArtifactReader.
+func (a *ArtifactReader) Close() error {
+	return a.record.Close()
+}
+
+// Digest implements libraries.ArtifactReader.
+func (a *ArtifactReader) Digest() string {
+	return "sha256:" + hex.EncodeToString(a.hash.Sum(nil))
+}
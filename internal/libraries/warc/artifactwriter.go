@@ -0,0 +1,155 @@
+package warc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.AbortableArtifactWriter = (*ArtifactWriter)(nil)
+
+// ArtifactWriter writes an artifact as a resource record. As the record's
+// header holds the block's length and digest, the artifact is buffered in a
+// temporary file until closed.
+type ArtifactWriter struct {
+	library  *Library
+	origin   string
+	id       string
+	name     string
+	tempFile *os.File
+	hash     hash.Hash
+	digest   string
+	writer   io.Writer
+}
+
+func NewArtifactWriter(library *Library, origin string, id string, name string) (*ArtifactWriter, error) {
+	tempFile, err := os.CreateTemp("", "larch-temp-*")
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+
+	return &ArtifactWriter{
+		library:  library,
+		origin:   origin,
+		id:       id,
+		name:     name,
+		tempFile: tempFile,
+		hash:     hash,
+		writer:   io.MultiWriter(tempFile, hash),
+	}, nil
+}
+
+// Write implements libraries.ArtifactWriter.
+func (a *ArtifactWriter) Write(p []byte) (n int, err error) {
+	return a.writer.Write(p)
+}
+
+// Abort implements libraries.AbortableArtifactWriter.
+func (a *ArtifactWriter) Abort() error {
+	defer os.Remove(a.tempFile.Name())
+	return a.tempFile.Close()
+}
+
+// Close implements libraries.ArtifactWriter.
+func (a *ArtifactWriter) Close() error {
+	defer os.Remove(a.tempFile.Name())
+	defer a.tempFile.Close()
+
+	a.digest = "sha256:" + hex.EncodeToString(a.hash.Sum(nil))
+
+	info, err := a.tempFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	// The artifact's content type is first known when its manifest is written,
+	// sniff it for the sake of other tools
+	sniff := make([]byte, 512)
+	n, err := a.tempFile.ReadAt(sniff, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	contentType := http.DetectContentType(sniff[:n])
+
+	if _, err := a.tempFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Page resources are recorded as captures of the snapshot's URL for tools
+	// such as pywb to replay them. Other artifacts are larch-specific
+	uri := artifactURI(a.origin, a.id, a.name)
+	if pageArtifacts[a.name] {
+		index, err := a.library.readSnapshotIndex(a.origin, a.id)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if pageURL := snapshotURL(index); pageURL != "" {
+			uri = pageURL
+		}
+	}
+
+	date := time.Now().UTC()
+
+	return a.library.appendRecord(a.origin, a.id, cdxEntry{
+		URL:    uri,
+		Date:   date,
+		Type:   "resource",
+		MIME:   contentType,
+		Digest: a.digest,
+	}, []recordHeader{
+		{Key: "WARC-Type", Value: "resource"},
+		{Key: "WARC-Record-ID", Value: newRecordID()},
+		{Key: "WARC-Date", Value: date.Format(time.RFC3339)},
+		{Key: "WARC-Target-URI", Value: uri},
+		{Key: "WARC-Block-Digest", Value: a.digest},
+		{Key: "WARC-Payload-Digest", Value: a.digest},
+		{Key: "Content-Type", Value: contentType},
+	}, a.tempFile, info.Size())
+}
+
+// Digest implements libraries.ArtifactWriter.
+func (a *ArtifactWriter) Digest() string {
+	return a.digest
+}
+
+// pageArtifacts holds the names of artifacts that are renditions of the
+// snapshot's page itself.
+var pageArtifacts = map[string]bool{
+	"chrome/singlepage.html": true,
+}
+
+// snapshotURL returns the URL of the page a snapshot was made of, if known.
+func snapshotURL(index *libraries.SnapshotIndex) string {
+	if index == nil {
+		return ""
+	}
+
+	for _, manifest := range index.Artifacts {
+		if url := manifest.Annotations["larch.snapshot.url"]; url != "" {
+			return url
+		}
+	}
+
+	return ""
+}
+
+// artifactURI returns the URI identifying an artifact of a snapshot.
+func artifactURI(origin string, id string, name string) string {
+	return snapshotURI(origin, id) + "/" + url.PathEscape(name)
+}
+
+// snapshotURI returns the URI identifying a snapshot.
+func snapshotURI(origin string, id string) string {
+	return "urn:larch:" + url.PathEscape(origin) + "/" + url.PathEscape(id)
+}
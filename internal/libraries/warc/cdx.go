@@ -0,0 +1,135 @@
+package warc
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	urlpkg "net/url"
+)
+
+// cdxEntry is an entry of a CDXJ index, as used by pywb, locating a record of
+// a WARC file.
+//
+// SEE: https://pywb.readthedocs.io/en/latest/manual/indexing.html#cdxj-format.
+type cdxEntry struct {
+	URL  string    `json:"url"`
+	Date time.Time `json:"-"`
+	// Type is the WARC record type, either resource or metadata
+	Type     string `json:"type"`
+	MIME     string `json:"mime,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+	Offset   int64  `json:"offset,string"`
+	Length   int64  `json:"length,string"`
+	Filename string `json:"filename"`
+}
+
+const cdxTimestampLayout = "20060102150405"
+
+// line formats the entry as a CDXJ line, including a trailing newline.
+func (e cdxEntry) line() ([]byte, error) {
+	fields, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return fmt.Appendf(nil, "%s %s %s\n", surt(e.URL), e.Date.UTC().Format(cdxTimestampLayout), fields), nil
+}
+
+// sortCDX sorts entries the way CDXJ indexes are sorted, by their SURT key
+// and timestamp. Entries of the same key and timestamp are kept in the order
+// they were written.
+func sortCDX(entries []cdxEntry) {
+	slices.SortStableFunc(entries, func(a cdxEntry, b cdxEntry) int {
+		return cmp.Or(
+			strings.Compare(surt(a.URL), surt(b.URL)),
+			strings.Compare(a.Date.UTC().Format(cdxTimestampLayout), b.Date.UTC().Format(cdxTimestampLayout)),
+			cmp.Compare(a.Offset, b.Offset),
+		)
+	})
+}
+
+// surtWWWPattern matches the www prefixes removed from hosts of SURT keys.
+var surtWWWPattern = regexp.MustCompile(`^www\d*\.`)
+
+// surt returns the Sort-friendly URI Reordering Transform (SURT) of a URL, as
+// used as the key of CDXJ indexes. The URL is canonicalized like pywb does, so
+// that the key of a URL looked up by pywb matches. For example,
+// https://www.example.com/path?b=2&a=1 becomes com,example)/path?a=1&b=2.
+// URIs other than http(s) URLs, such as urn:larch: URIs, are kept as-is.
+//
+// SEE: https://pywb.readthedocs.io/en/latest/manual/indexing.html#surt.
+func surt(uri string) string {
+	u, err := urlpkg.Parse(uri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return uri
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	host = surtWWWPattern.ReplaceAllString(host, "")
+
+	parts := strings.Split(host, ".")
+	slices.Reverse(parts)
+	key := strings.Join(parts, ",")
+
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		key += ":" + port
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	key += ")" + strings.ToLower(path)
+
+	if u.RawQuery != "" {
+		parameters := strings.Split(u.RawQuery, "&")
+		slices.Sort(parameters)
+		key += "?" + strings.ToLower(strings.Join(parameters, "&"))
+	}
+
+	return key
+}
+
+// readCDX reads all entries of a CDXJ index.
+func readCDX(r io.Reader) ([]cdxEntry, error) {
+	entries := make([]cdxEntry, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		_, rest, _ := strings.Cut(line, " ")
+		timestamp, fields, ok := strings.Cut(rest, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid cdx line")
+		}
+
+		var entry cdxEntry
+		if err := json.Unmarshal([]byte(fields), &entry); err != nil {
+			return nil, err
+		}
+
+		date, err := time.Parse(cdxTimestampLayout, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		entry.Date = date
+
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
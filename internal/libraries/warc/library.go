@@ -0,0 +1,504 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/google/uuid"
+)
+
+var _ libraries.LibraryWriter = (*Library)(nil)
+var _ libraries.LibraryReader = (*Library)(nil)
+
+// Library stores each snapshot as a WARC 1.1 file. Artifacts are stored as
+// resource records and the snapshot index as a metadata record. As WARC files
+// are append-only, the index is rewritten as a new metadata record whenever
+// it changes and the last one wins.
+//
+// Each WARC file has a CDXJ index next to it, locating its records.
+//
+//	/snapshots/example.com/1231231.warc
+//	/snapshots/example.com/1231231.cdxj
+type Library struct {
+	root  *os.Root
+	mutex sync.RWMutex
+	// blobs holds the location of a resource record by digest
+	blobs map[string]recordLocation
+	// manifestMutex serializes writes of snapshot indexes
+	manifestMutex sync.Mutex
+}
+
+type recordLocation struct {
+	// Path is the path of the WARC file, relative to the root
+	Path   string
+	Offset int64
+	Length int64
+}
+
+func NewLibrary(basePath string) (*Library, error) {
+	err := os.MkdirAll(filepath.Join(basePath, "snapshots"), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := os.OpenRoot(filepath.Join(basePath, "snapshots"))
+	if err != nil {
+		return nil, err
+	}
+
+	library := &Library{
+		root:  root,
+		blobs: make(map[string]recordLocation),
+	}
+
+	// Index all blobs up front, the CDX indexes are small
+	origins, err := library.GetOrigins(context.Background())
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+
+	for _, origin := range origins {
+		ids, err := library.GetSnapshots(context.Background(), origin)
+		if err != nil {
+			root.Close()
+			return nil, err
+		}
+
+		for _, id := range ids {
+			if _, err := library.readCDX(origin, id); err != nil {
+				root.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return library, nil
+}
+
+// GetOrigins implements LibraryReader.
+func (l *Library) GetOrigins(ctx context.Context) ([]string, error) {
+	file, err := l.root.Open(".")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries, err := file.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	origins := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			origins = append(origins, entry.Name())
+		}
+	}
+
+	return origins, nil
+}
+
+// GetSnapshots implements LibraryReader.
+func (l *Library) GetSnapshots(ctx context.Context, origin string) ([]string, error) {
+	file, err := l.root.Open(origin)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries, err := file.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]string, 0)
+	for _, entry := range entries {
+		if id, ok := strings.CutSuffix(entry.Name(), ".warc"); ok && entry.Type().IsRegular() {
+			snapshots = append(snapshots, id)
+		}
+	}
+
+	return snapshots, nil
+}
+
+// ReadSnapshot implements LibraryReader.
+func (l *Library) ReadSnapshot(ctx context.Context, origin string, id string) (libraries.SnapshotReader, error) {
+	return NewSnapshotReader(l, origin, id)
+}
+
+// ReadArtifact implements LibraryReader.
+func (l *Library) ReadArtifact(ctx context.Context, digest string) (libraries.ArtifactReader, error) {
+	l.mutex.RLock()
+	location, ok := l.blobs[digest]
+	l.mutex.RUnlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return NewArtifactReader(l.root, location)
+}
+
+// WriteSnapshot implements LibraryWriter.
+func (l *Library) WriteSnapshot(ctx context.Context, origin string, id string) (libraries.SnapshotWriter, error) {
+	return NewSnapshotWriter(l, origin, id)
+}
+
+// Close implements LibraryReader.
+func (l *Library) Close() error {
+	return l.root.Close()
+}
+
+// warcPath returns the path of a snapshot's WARC file.
+func warcPath(origin string, id string) string {
+	return filepath.Join(origin, id+".warc")
+}
+
+// cdxPath returns the path of a snapshot's CDX index.
+func cdxPath(origin string, id string) string {
+	return filepath.Join(origin, id+".cdxj")
+}
+
+// readCDX reads the CDX index of a snapshot and indexes its blobs. If the
+// index does not exist, it is rebuilt from the WARC file.
+func (l *Library) readCDX(origin string, id string) ([]cdxEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var entries []cdxEntry
+	file, err := l.root.Open(cdxPath(origin, id))
+	if errors.Is(err, os.ErrNotExist) {
+		entries, err = l.rebuildCDX(origin, id)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		entries, err = readCDX(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Type == "resource" && entry.Digest != "" {
+			l.blobs[entry.Digest] = recordLocation{
+				Path:   warcPath(origin, id),
+				Offset: entry.Offset,
+				Length: entry.Length,
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// rebuildCDX indexes the records of a snapshot's WARC file and writes the CDX
+// index. Expects the lock to be held.
+func (l *Library) rebuildCDX(origin string, id string) ([]cdxEntry, error) {
+	file, err := l.root.Open(warcPath(origin, id))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]cdxEntry, 0)
+	err = scanRecords(file, func(header textproto.MIMEHeader, offset int64, length int64) error {
+		recordType := header.Get("WARC-Type")
+		if recordType != "resource" && recordType != "metadata" {
+			return nil
+		}
+
+		date, _ := time.Parse(time.RFC3339, header.Get("WARC-Date"))
+		entries = append(entries, cdxEntry{
+			URL:      header.Get("WARC-Target-URI"),
+			Date:     date,
+			Type:     recordType,
+			MIME:     header.Get("Content-Type"),
+			Digest:   header.Get("WARC-Payload-Digest"),
+			Offset:   offset,
+			Length:   length,
+			Filename: filepath.Base(warcPath(origin, id)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.writeCDX(origin, id, entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// writeCDX sorts the entries and replaces a snapshot's CDX index with them.
+// Expects the lock to be held.
+func (l *Library) writeCDX(origin string, id string, entries []cdxEntry) error {
+	sortCDX(entries)
+
+	var buffer bytes.Buffer
+	for _, entry := range entries {
+		line, err := entry.line()
+		if err != nil {
+			return err
+		}
+		buffer.Write(line)
+	}
+
+	// Write to a hidden file first, readers never see a partial index
+	tempPath := filepath.Join(origin, "."+id+".cdxj.tmp")
+	if err := l.root.WriteFile(tempPath, buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	if err := l.root.Rename(tempPath, cdxPath(origin, id)); err != nil {
+		_ = l.root.Remove(tempPath)
+		return err
+	}
+
+	return nil
+}
+
+// sortCDX sorts a snapshot's CDX index. Records are appended to the index as
+// they are written, the index is sorted once the snapshot is closed.
+func (l *Library) sortCDX(origin string, id string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	file, err := l.root.Open(cdxPath(origin, id))
+	if errors.Is(err, os.ErrNotExist) {
+		// Nothing was written
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	entries, err := readCDX(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	return l.writeCDX(origin, id, entries)
+}
+
+// appendRecord appends a record to a snapshot's WARC file and indexes it.
+func (l *Library) appendRecord(origin string, id string, entry cdxEntry, headers []recordHeader, block io.Reader, length int64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.root.MkdirAll(origin, 0755); err != nil {
+		return err
+	}
+
+	file, err := l.root.OpenFile(warcPath(origin, id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+
+	// Start each file with a record describing it
+	if offset == 0 {
+		fields := "software: larch\r\nformat: WARC File Format 1.1\r\n"
+		n, err := writeRecord(file, []recordHeader{
+			{Key: "WARC-Type", Value: "warcinfo"},
+			{Key: "WARC-Record-ID", Value: newRecordID()},
+			{Key: "WARC-Date", Value: time.Now().UTC().Format(time.RFC3339)},
+			{Key: "WARC-Filename", Value: filepath.Base(warcPath(origin, id))},
+			{Key: "Content-Type", Value: "application/warc-fields"},
+		}, strings.NewReader(fields), int64(len(fields)))
+		if err != nil {
+			return err
+		}
+		offset += n
+	}
+
+	n, err := writeRecord(file, headers, block, length)
+	if err != nil {
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	entry.Offset = offset
+	entry.Length = n
+	entry.Filename = filepath.Base(warcPath(origin, id))
+
+	line, err := entry.line()
+	if err != nil {
+		return err
+	}
+
+	cdxFile, err := l.root.OpenFile(cdxPath(origin, id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer cdxFile.Close()
+
+	if _, err := cdxFile.Write(line); err != nil {
+		return err
+	}
+
+	if entry.Type == "resource" && entry.Digest != "" {
+		l.blobs[entry.Digest] = recordLocation{
+			Path:   warcPath(origin, id),
+			Offset: entry.Offset,
+			Length: entry.Length,
+		}
+	}
+
+	return nil
+}
+
+// readSnapshotIndex reads the snapshot index of the last metadata record of a
+// snapshot's WARC file.
+func (l *Library) readSnapshotIndex(origin string, id string) (*libraries.SnapshotIndex, error) {
+	entries, err := l.readCDX(origin, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The CDX index is sorted, the last record is the one furthest into the file
+	var last *cdxEntry
+	for i, entry := range entries {
+		if entry.Type == "metadata" && (last == nil || entry.Offset > last.Offset) {
+			last = &entries[i]
+		}
+	}
+	if last == nil {
+		return nil, os.ErrNotExist
+	}
+
+	reader, err := openRecord(l.root, recordLocation{
+		Path:   warcPath(origin, id),
+		Offset: last.Offset,
+		Length: last.Length,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var index libraries.SnapshotIndex
+	if err := json.NewDecoder(reader).Decode(&index); err != nil {
+		return nil, err
+	}
+
+	return &index, nil
+}
+
+// recordReader reads the block of a record.
+type recordReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (r *recordReader) Close() error {
+	return r.file.Close()
+}
+
+// openRecord opens the block of the record at the location.
+func openRecord(root *os.Root, location recordLocation) (*recordReader, error) {
+	file, err := root.Open(location.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(location.Offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(io.LimitReader(file, location.Length))
+	_, length, err := readRecordHeader(reader)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &recordReader{
+		Reader: io.LimitReader(reader, length),
+		file:   file,
+	}, nil
+}
+
+func newRecordID() string {
+	return "<urn:uuid:" + uuid.NewString() + ">"
+}
+
+// DeleteSnapshot implements LibraryWriter. The snapshot's WARC file and CDX
+// index are removed.
+func (l *Library) DeleteSnapshot(ctx context.Context, origin string, id string) error {
+	l.manifestMutex.Lock()
+	defer l.manifestMutex.Unlock()
+
+	if err := l.root.Remove(warcPath(origin, id)); err != nil {
+		return err
+	}
+
+	if err := l.root.Remove(cdxPath(origin, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// Remove the origin once empty
+	_ = l.root.Remove(origin)
+
+	// Forget the records of the removed file. Other snapshots may hold records
+	// of the same blobs, index them again
+	l.mutex.Lock()
+	removed := false
+	for digest, location := range l.blobs {
+		if location.Path == warcPath(origin, id) {
+			delete(l.blobs, digest)
+			removed = true
+		}
+	}
+	l.mutex.Unlock()
+
+	if !removed {
+		return nil
+	}
+
+	origins, err := l.GetOrigins(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, origin := range origins {
+		ids, err := l.GetSnapshots(ctx, origin)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if _, err := l.readCDX(origin, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package warc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibrary(t *testing.T) {
+	basePath := t.TempDir()
+
+	library, err := NewLibrary(basePath)
+	require.NoError(t, err)
+
+	snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", "1")
+	require.NoError(t, err)
+
+	require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+		ContentType: "application/vnd.larch.snapshot.manifest.v1+json",
+		Digest:      "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		Annotations: map[string]string{"larch.snapshot.url": "https://example.com"},
+	}))
+
+	data := []byte("<html><body>Hello, World!</body></html>")
+	size, digest, err := snapshotWriter.WriteArtifact(context.TODO(), "singlefile.html", data)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+
+	require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+		ContentType: "text/html",
+		Digest:      digest,
+		Size:        size,
+	}))
+	require.NoError(t, snapshotWriter.Close())
+	require.NoError(t, library.Close())
+
+	// The library should be readable without the CDX index, as written by other
+	// tools
+	require.NoError(t, os.Remove(filepath.Join(basePath, "snapshots", "example.com", "1.cdxj")))
+
+	library, err = NewLibrary(basePath)
+	require.NoError(t, err)
+	defer library.Close()
+
+	origins, err := library.GetOrigins(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, origins)
+
+	snapshots, err := library.GetSnapshots(context.TODO(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1"}, snapshots)
+
+	snapshotReader, err := library.ReadSnapshot(context.TODO(), "example.com", "1")
+	require.NoError(t, err)
+	defer snapshotReader.Close()
+
+	index := snapshotReader.Index()
+	require.Len(t, index.Artifacts, 2)
+	assert.Equal(t, "text/html", index.Artifacts[1].ContentType)
+
+	reader, err := snapshotReader.NextArtifactReader(context.TODO(), digest)
+	require.NoError(t, err)
+
+	actual, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	assert.Equal(t, data, actual)
+	assert.Equal(t, digest, reader.Digest())
+
+	// Deleting a snapshot removes its files and forgets its records
+	require.NoError(t, library.DeleteSnapshot(context.TODO(), "example.com", "1"))
+	assert.ErrorIs(t, library.DeleteSnapshot(context.TODO(), "example.com", "1"), os.ErrNotExist)
+
+	_, err = os.Stat(filepath.Join(basePath, "snapshots", "example.com"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = library.ReadArtifact(context.TODO(), digest)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestLibraryCDX(t *testing.T) {
+	basePath := t.TempDir()
+
+	library, err := NewLibrary(basePath)
+	require.NoError(t, err)
+	defer library.Close()
+
+	snapshotWriter, err := library.WriteSnapshot(context.TODO(), "www.example.com", "1")
+	require.NoError(t, err)
+
+	require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+		ContentType: "application/vnd.larch.snapshot.manifest.v1+json",
+		Digest:      libraries.EmptyDigest,
+		Annotations: map[string]string{"larch.snapshot.url": "https://www.example.com/path?b=2&a=1"},
+	}))
+
+	for _, name := range []string{"opengraph/meta.json", "chrome/singlepage.html", "archive.org/url.txt"} {
+		_, _, err := snapshotWriter.WriteArtifact(context.TODO(), name, []byte(name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, snapshotWriter.Close())
+
+	file, err := os.Open(filepath.Join(basePath, "snapshots", "www.example.com", "1.cdxj"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	keys := make([]string, 0)
+	targets := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), " ")
+		require.True(t, ok)
+		timestamp, fields, ok := strings.Cut(rest, " ")
+		require.True(t, ok)
+
+		var entry struct {
+			URL string `json:"url"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(fields), &entry))
+		assert.Equal(t, surt(entry.URL), key)
+
+		keys = append(keys, key+" "+timestamp)
+		targets[key] = entry.URL
+	}
+	require.NoError(t, scanner.Err())
+
+	// 4 records, the manifest and three artifacts
+	require.Len(t, keys, 4)
+	assert.True(t, slices.IsSorted(keys), "CDX index is not sorted: %v", keys)
+
+	// The single page is a capture of the page, other artifacts are not
+	assert.Equal(t, "https://www.example.com/path?b=2&a=1", targets["com,example)/path?a=1&b=2"])
+	assert.Equal(t, "urn:larch:www.example.com/1/opengraph%2Fmeta.json", targets["urn:larch:www.example.com/1/opengraph%2Fmeta.json"])
+}
+
+func TestSURT(t *testing.T) {
+	testCases := []struct {
+		URI      string
+		Expected string
+	}{
+		{URI: "https://example.com", Expected: "com,example)/"},
+		{URI: "http://www.Example.com/Path/", Expected: "com,example)/path/"},
+		{URI: "https://www2.example.com:443/", Expected: "com,example)/"},
+		{URI: "http://sub.example.com:8080/a?b=2&a=1#fragment", Expected: "com,example,sub:8080)/a?a=1&b=2"},
+		{URI: "urn:larch:example.com/1", Expected: "urn:larch:example.com/1"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.URI, func(t *testing.T) {
+			assert.Equal(t, testCase.Expected, surt(testCase.URI))
+		})
+	}
+}
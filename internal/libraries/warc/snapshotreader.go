@@ -0,0 +1,41 @@
+package warc
+
+import (
+	"context"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.SnapshotReader = (*SnapshotReader)(nil)
+
+type SnapshotReader struct {
+	library *Library
+	index   libraries.SnapshotIndex
+}
+
+func NewSnapshotReader(library *Library, origin string, id string) (*SnapshotReader, error) {
+	index, err := library.readSnapshotIndex(origin, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotReader{
+		library: library,
+		index:   *index,
+	}, nil
+}
+
+// Index implements SnapshotReader.
+func (s *SnapshotReader) Index() libraries.SnapshotIndex {
+	return s.index
+}
+
+// NextArtifactReader implements SnapshotReader.
+func (s *SnapshotReader) NextArtifactReader(ctx context.Context, digest string) (libraries.ArtifactReader, error) {
+	return s.library.ReadArtifact(ctx, digest)
+}
+
+// Close implements SnapshotReader.
+func (s *SnapshotReader) Close() error {
+	return nil
+}
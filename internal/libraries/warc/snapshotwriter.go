@@ -0,0 +1,115 @@
+package warc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+var _ libraries.SnapshotWriter = (*SnapshotWriter)(nil)
+
+type SnapshotWriter struct {
+	library *Library
+	origin  string
+	id      string
+	index   libraries.SnapshotIndex
+}
+
+func NewSnapshotWriter(library *Library, origin string, id string) (*SnapshotWriter, error) {
+	index, err := library.readSnapshotIndex(origin, id)
+	if errors.Is(err, os.ErrNotExist) {
+		index = &libraries.SnapshotIndex{
+			Schema:    "application/vnd.larch.snapshot.index.v1+json",
+			Artifacts: make([]libraries.ArtifactManifest, 0),
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotWriter{
+		library: library,
+		origin:  origin,
+		id:      id,
+		index:   *index,
+	}, nil
+}
+
+// NextArtifactWriter implements SnapshotWriter.
+func (s *SnapshotWriter) NextArtifactWriter(ctx context.Context, name string) (libraries.ArtifactWriter, error) {
+	return NewArtifactWriter(s.library, s.origin, s.id, name)
+}
+
+// WriteArtifact implements SnapshotWriter.
+func (s *SnapshotWriter) WriteArtifact(ctx context.Context, name string, data []byte) (int64, string, error) {
+	w, err := s.NextArtifactWriter(ctx, name)
+	if err != nil {
+		return 0, "", err
+	}
+
+	n, err := io.Copy(w, bytes.NewReader(data))
+	if err != nil {
+		w.Close()
+		return n, "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return n, "", err
+	}
+
+	return n, w.Digest(), nil
+}
+
+// WriteArtifactManifest implements SnapshotWriter.
+func (s *SnapshotWriter) WriteArtifactManifest(ctx context.Context, manifest libraries.ArtifactManifest) error {
+	// Several writers may write manifests of the same snapshot, always append to
+	// the latest index
+	s.library.manifestMutex.Lock()
+	defer s.library.manifestMutex.Unlock()
+
+	index, err := s.library.readSnapshotIndex(s.origin, s.id)
+	if err == nil {
+		s.index = *index
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	s.index.Artifacts = append(s.index.Artifacts, manifest)
+
+	block, err := json.Marshal(s.index)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(block)
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+
+	uri := snapshotURI(s.origin, s.id)
+	date := time.Now().UTC()
+
+	return s.library.appendRecord(s.origin, s.id, cdxEntry{
+		URL:  uri,
+		Date: date,
+		Type: "metadata",
+		MIME: s.index.Schema,
+	}, []recordHeader{
+		{Key: "WARC-Type", Value: "metadata"},
+		{Key: "WARC-Record-ID", Value: newRecordID()},
+		{Key: "WARC-Date", Value: date.Format(time.RFC3339)},
+		{Key: "WARC-Target-URI", Value: uri},
+		{Key: "WARC-Block-Digest", Value: digest},
+		{Key: "Content-Type", Value: s.index.Schema},
+	}, bytes.NewReader(block), int64(len(block)))
+}
+
+// Close implements SnapshotWriter. The snapshot's CDX index is sorted.
+func (s *SnapshotWriter) Close() error {
+	return s.library.sortCDX(s.origin, s.id)
+}
@@ -0,0 +1,129 @@
+package warc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// SEE: https://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/.
+
+const warcVersion = "WARC/1.1"
+
+// recordHeader is a named field of a record's header.
+type recordHeader struct {
+	Key   string
+	Value string
+}
+
+// writeRecord writes a record with the headers and a block of the given
+// length. The Content-Length header is added automatically. Returns the number
+// of bytes written.
+func writeRecord(w io.Writer, headers []recordHeader, block io.Reader, length int64) (int64, error) {
+	var builder strings.Builder
+	builder.WriteString(warcVersion + "\r\n")
+	for _, header := range headers {
+		builder.WriteString(header.Key + ": " + header.Value + "\r\n")
+	}
+	builder.WriteString("Content-Length: " + strconv.FormatInt(length, 10) + "\r\n")
+	builder.WriteString("\r\n")
+
+	written := int64(0)
+
+	n, err := io.WriteString(w, builder.String())
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	copied, err := io.CopyN(w, block, length)
+	written += copied
+	if err != nil {
+		return written, err
+	}
+
+	n, err = io.WriteString(w, "\r\n\r\n")
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	return written, nil
+}
+
+// readRecordHeader reads the version line and header of a record.
+func readRecordHeader(r *bufio.Reader) (textproto.MIMEHeader, int64, error) {
+	version, err := r.ReadString('\n')
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, 0, fmt.Errorf("invalid warc record: unexpected version line")
+	}
+
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, 0, fmt.Errorf("invalid warc record: bad content length")
+	}
+
+	return header, length, nil
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// scanRecords calls fn for each record of a WARC file, with the record's
+// header, offset and length. The block is skipped.
+func scanRecords(r io.Reader, fn func(header textproto.MIMEHeader, offset int64, length int64) error) error {
+	counter := &countingReader{reader: r}
+	reader := bufio.NewReader(counter)
+
+	for {
+		offset := counter.n - int64(reader.Buffered())
+
+		// Stop at the end of the file
+		if _, err := reader.Peek(1); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		header, blockLength, err := readRecordHeader(reader)
+		if err != nil {
+			return err
+		}
+
+		// Skip the block and the two trailing newlines
+		if _, err := reader.Discard(int(blockLength)); err != nil {
+			return err
+		}
+
+		trailer := make([]byte, 4)
+		if _, err := io.ReadFull(reader, trailer); err != nil {
+			return err
+		}
+
+		end := counter.n - int64(reader.Buffered())
+		if err := fn(header, offset, end-offset); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+// Quota limits the number of bytes stored in a library. Zero means no limit.
+type Quota struct {
+	// Soft is the number of bytes after which warnings are logged.
+	Soft int64
+	// Hard is the number of bytes after which no new snapshots are written to
+	// the library.
+	Hard int64
+}
+
+// ExceededError is returned when a library is at or over its hard quota.
+type ExceededError struct {
+	Library string
+	Bytes   int64
+	Quota   int64
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("library %s is over its hard quota: %d of %d bytes stored", e.Library, e.Bytes, e.Quota)
+}
+
+// Status is the usage of a library, along with its quota.
+type Status struct {
+	Library string
+	Usage   libraries.Usage
+	Quota   Quota
+	// Updated is the time the usage was last computed. Bytes written since are
+	// part of the usage's bytes.
+	Updated time.Time
+}
+
+// SoftQuotaExceeded returns whether or not the library is at or over its soft
+// quota.
+func (s *Status) SoftQuotaExceeded() bool {
+	return s.Quota.Soft > 0 && s.Usage.Bytes >= s.Quota.Soft
+}
+
+// HardQuotaExceeded returns whether or not the library is at or over its hard
+// quota.
+func (s *Status) HardQuotaExceeded() bool {
+	return s.Quota.Hard > 0 && s.Usage.Bytes >= s.Quota.Hard
+}
+
+// Tracker tracks the usage of libraries. Usage is computed periodically, as it
+// requires reading all of a library's snapshots. Bytes written in between are
+// accounted for as they are written, see [Tracker.Add].
+type Tracker struct {
+	mutex          sync.Mutex
+	libraryReaders map[string]libraries.LibraryReader
+	quotas         map[string]Quota
+	usage          map[string]*libraries.Usage
+	updated        map[string]time.Time
+	// written holds the bytes written to libraries since their usage was last
+	// computed
+	written map[string]int64
+}
+
+func NewTracker(libraryReaders map[string]libraries.LibraryReader, quotas map[string]Quota) *Tracker {
+	return &Tracker{
+		libraryReaders: libraryReaders,
+		quotas:         quotas,
+		usage:          make(map[string]*libraries.Usage),
+		updated:        make(map[string]time.Time),
+		written:        make(map[string]int64),
+	}
+}
+
+// Run computes the usage of all libraries every interval, until the context
+// is cancelled.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) error {
+	for {
+		if err := t.Refresh(ctx); err != nil {
+			slog.Error("Failed to compute library usage", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Refresh computes the usage of all libraries.
+func (t *Tracker) Refresh(ctx context.Context) error {
+	var errs []error
+	for libraryID := range t.libraryReaders {
+		if err := t.RefreshLibrary(ctx, libraryID); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", libraryID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// RefreshLibrary computes the usage of a library.
+func (t *Tracker) RefreshLibrary(ctx context.Context, libraryID string) error {
+	library, ok := t.libraryReaders[libraryID]
+	if !ok {
+		return fmt.Errorf("no such library")
+	}
+
+	// Writes made while computing may or may not be part of the usage. Count
+	// them twice rather than not at all until the next refresh, only the writes
+	// made before computing are known to be part of it
+	t.mutex.Lock()
+	written := t.written[libraryID]
+	t.mutex.Unlock()
+
+	started := time.Now()
+	usage, err := libraries.ComputeUsage(ctx, library)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	t.usage[libraryID] = usage
+	t.updated[libraryID] = started
+	t.written[libraryID] -= written
+	t.mutex.Unlock()
+
+	status, _ := t.Status(libraryID)
+	if status.SoftQuotaExceeded() {
+		slog.Warn("Library is over its soft quota", slog.String("library", libraryID), slog.Int64("bytes", status.Usage.Bytes), slog.Int64("quota", status.Quota.Soft))
+	}
+
+	return nil
+}
+
+// Add accounts for bytes newly stored in a library. Writes of blobs already
+// stored in the library must not be added, as they're deduplicated.
+func (t *Tracker) Add(libraryID string, n int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.written[libraryID] += n
+}
+
+// Status returns the usage of a library. Returns false if the usage is not
+// yet computed.
+func (t *Tracker) Status(libraryID string) (*Status, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	usage, ok := t.usage[libraryID]
+	if !ok {
+		return nil, false
+	}
+
+	status := &Status{
+		Library: libraryID,
+		Usage:   *usage,
+		Quota:   t.quotas[libraryID],
+		Updated: t.updated[libraryID],
+	}
+	status.Usage.Origins = maps.Clone(usage.Origins)
+	status.Usage.Bytes += t.written[libraryID]
+
+	return status, true
+}
+
+// Check returns an [ExceededError] if the library is at or over its hard
+// quota. Libraries whose usage is not yet computed are never over quota.
+func (t *Tracker) Check(libraryID string) error {
+	status, ok := t.Status(libraryID)
+	if !ok {
+		return nil
+	}
+
+	if status.HardQuotaExceeded() {
+		return &ExceededError{
+			Library: libraryID,
+			Bytes:   status.Usage.Bytes,
+			Quota:   status.Quota.Hard,
+		}
+	}
+
+	if status.SoftQuotaExceeded() {
+		slog.Warn("Writing to library over its soft quota", slog.String("library", libraryID), slog.Int64("bytes", status.Usage.Bytes), slog.Int64("quota", status.Quota.Soft))
+	}
+
+	return nil
+}
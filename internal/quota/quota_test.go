@@ -0,0 +1,108 @@
+package quota
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/libraries/disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	library, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	write := func(origin string, id string, data []byte) {
+		snapshotWriter, err := library.WriteSnapshot(context.TODO(), origin, id)
+		require.NoError(t, err)
+		defer snapshotWriter.Close()
+
+		size, digest, err := snapshotWriter.WriteArtifact(context.TODO(), "artifact.txt", data)
+		require.NoError(t, err)
+		require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "text/plain",
+			Digest:      digest,
+			Size:        size,
+		}))
+	}
+
+	// The same content in two snapshots is stored once
+	write("example.com", "1", []byte("hello, world"))
+	write("example.com", "2", []byte("hello, world"))
+	write("example.org", "1", []byte("hello"))
+
+	tracker := NewTracker(map[string]libraries.LibraryReader{"disk": library}, map[string]Quota{
+		"disk": {Soft: 10, Hard: 20},
+	})
+
+	// Usage is unknown until computed
+	_, ok := tracker.Status("disk")
+	assert.False(t, ok)
+	assert.NoError(t, tracker.Check("disk"))
+
+	require.NoError(t, tracker.Refresh(context.TODO()))
+
+	status, ok := tracker.Status("disk")
+	require.True(t, ok)
+	assert.Equal(t, libraries.Usage{
+		Bytes:        17,
+		LogicalBytes: 29,
+		Blobs:        2,
+		Snapshots:    3,
+		Origins: map[string]int64{
+			"example.com": 24,
+			"example.org": 5,
+		},
+	}, status.Usage)
+	assert.True(t, status.SoftQuotaExceeded())
+	assert.False(t, status.HardQuotaExceeded())
+	assert.NoError(t, tracker.Check("disk"))
+
+	// Writes are accounted for until the next refresh
+	tracker.Add("disk", 3)
+	err = tracker.Check("disk")
+	var exceeded *ExceededError
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, int64(20), exceeded.Bytes)
+
+	require.NoError(t, tracker.Refresh(context.TODO()))
+	assert.NoError(t, tracker.Check("disk"))
+}
+
+// writingLibrary is a library written to while its usage is computed.
+type writingLibrary struct {
+	libraries.LibraryReader
+	write func()
+}
+
+func (w *writingLibrary) GetOrigins(ctx context.Context) ([]string, error) {
+	w.write()
+	return w.LibraryReader.GetOrigins(ctx)
+}
+
+func TestTrackerRefreshConcurrentWrites(t *testing.T) {
+	library, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	var tracker *Tracker
+	writing := &writingLibrary{LibraryReader: library, write: func() {}}
+	tracker = NewTracker(map[string]libraries.LibraryReader{"disk": writing}, map[string]Quota{"disk": {Hard: 10}})
+	require.NoError(t, tracker.Refresh(context.TODO()))
+
+	// Writes made before computing are part of the usage, writes made while
+	// computing are still accounted for
+	tracker.Add("disk", 3)
+	writing.write = func() { tracker.Add("disk", 10) }
+	require.NoError(t, tracker.Refresh(context.TODO()))
+
+	status, ok := tracker.Status("disk")
+	require.True(t, ok)
+	assert.Equal(t, int64(10), status.Usage.Bytes)
+
+	var exceeded *ExceededError
+	assert.ErrorAs(t, tracker.Check("disk"), &exceeded)
+}
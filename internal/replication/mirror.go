@@ -0,0 +1,47 @@
+package replication
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+// Mirror continuously replicates a source library to a target library.
+type Mirror struct {
+	// ID identifies the mirror in logs.
+	ID     string
+	Source libraries.LibraryReader
+	Target Target
+	// Interval is the time between replications. If zero, the source is only
+	// replicated once on start.
+	Interval time.Duration
+}
+
+// Run replicates the source library to the target library every interval,
+// until the context is cancelled.
+//
+// NOTE: Replicated snapshots are not indexed until restarted, the index keeps
+// serving them from the source library.
+func (m *Mirror) Run(ctx context.Context) error {
+	for {
+		slog.Debug("Replicating library", slog.String("mirror", m.ID))
+		report, err := Replicate(ctx, m.Source, m.Target)
+		if err != nil {
+			slog.Error("Failed to replicate library", slog.String("mirror", m.ID), slog.Any("error", err))
+		} else {
+			slog.Info("Replicated library", slog.String("mirror", m.ID), slog.Int("snapshots", report.Snapshots), slog.Int("blobs", report.Blobs), slog.Int("failed", len(report.Failed)))
+		}
+
+		if m.Interval == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.Interval):
+		}
+	}
+}
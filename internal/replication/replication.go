@@ -0,0 +1,194 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+)
+
+// Target is a library to replicate snapshots to.
+type Target interface {
+	libraries.LibraryReader
+	libraries.LibraryWriter
+}
+
+type Report struct {
+	// Snapshots is the number of snapshots replicated, fully or in part.
+	Snapshots int `json:"snapshots"`
+	// Artifacts is the number of artifacts replicated.
+	Artifacts int `json:"artifacts"`
+	// Blobs is the number of blobs copied. Blobs already stored in the target
+	// are not copied.
+	Blobs int `json:"blobs"`
+	// Bytes is the number of bytes copied.
+	Bytes int64 `json:"bytes"`
+	// Failed holds the snapshots that failed to replicate, formatted like so:
+	// <origin>/<id>: <error>.
+	Failed []string `json:"failed"`
+}
+
+// Replicate copies snapshots from the source library to the target library.
+// Replication is incremental. Only artifacts missing from the target's
+// snapshots are replicated and only blobs missing from the target are copied.
+// Manifests, including annotations, are preserved. A failing snapshot does not
+// stop replication of other snapshots.
+func Replicate(ctx context.Context, source libraries.LibraryReader, target Target) (*Report, error) {
+	report := &Report{
+		Failed: make([]string, 0),
+	}
+
+	origins, err := source.GetOrigins(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get origins: %w", err)
+	}
+
+	for _, origin := range origins {
+		snapshots, err := source.GetSnapshots(ctx, origin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get snapshots for origin: %w", err)
+		}
+
+		for _, id := range snapshots {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			if err := replicateSnapshot(ctx, source, target, origin, id, report); err != nil {
+				slog.Warn("Failed to replicate snapshot", slog.String("origin", origin), slog.String("snapshotId", id), slog.Any("error", err))
+				report.Failed = append(report.Failed, fmt.Sprintf("%s/%s: %s", origin, id, err))
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// ReplicateSnapshot replicates a single snapshot, see [Replicate].
+func ReplicateSnapshot(ctx context.Context, source libraries.LibraryReader, target Target, origin string, id string) (*Report, error) {
+	report := &Report{
+		Failed: make([]string, 0),
+	}
+
+	if err := replicateSnapshot(ctx, source, target, origin, id, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func replicateSnapshot(ctx context.Context, source libraries.LibraryReader, target Target, origin string, id string, report *Report) error {
+	snapshotReader, err := source.ReadSnapshot(ctx, origin, id)
+	if err != nil {
+		return err
+	}
+	index := snapshotReader.Index()
+	snapshotReader.Close()
+
+	// Only replicate artifacts the target is missing, such as of snapshots that
+	// were partially replicated or not yet complete when last replicated
+	missing := index.Artifacts
+	targetReader, err := target.ReadSnapshot(ctx, origin, id)
+	if err == nil {
+		replicated := targetReader.Index().Artifacts
+		targetReader.Close()
+
+		missing = slices.DeleteFunc(slices.Clone(missing), func(artifact libraries.ArtifactManifest) bool {
+			return slices.ContainsFunc(replicated, func(other libraries.ArtifactManifest) bool {
+				return other.Digest == artifact.Digest && other.ContentType == artifact.ContentType
+			})
+		})
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	snapshotWriter, err := target.WriteSnapshot(ctx, origin, id)
+	if err != nil {
+		return err
+	}
+	defer snapshotWriter.Close()
+
+	report.Snapshots++
+	for _, artifact := range missing {
+		// Artifacts of no content are not stored
+		if artifact.Digest != libraries.EmptyDigest {
+			name := artifact.Annotations["larch.artifact.path"]
+			if name == "" {
+				name = strings.ReplaceAll(artifact.Digest, ":", "-")
+			}
+
+			copied, n, err := CopyArtifact(ctx, source, snapshotWriter, name, artifact.Digest)
+			if err != nil {
+				return err
+			}
+
+			if copied {
+				report.Blobs++
+				report.Bytes += n
+			}
+		}
+
+		// The content encoding is specific to how the source stores the blob
+		artifact.ContentEncoding = ""
+		if err := snapshotWriter.WriteArtifactManifest(ctx, artifact); err != nil {
+			return err
+		}
+
+		report.Artifacts++
+	}
+
+	return snapshotWriter.Close()
+}
+
+// CopyArtifact writes an artifact of the source library to a snapshot. The
+// artifact's blob is linked if already stored in the snapshot's library,
+// otherwise it is copied. Returns whether or not the blob was copied and the
+// number of bytes copied.
+func CopyArtifact(ctx context.Context, source libraries.LibraryReader, snapshotWriter libraries.SnapshotWriter, name string, digest string) (bool, int64, error) {
+	if linkingWriter, ok := snapshotWriter.(libraries.LinkingSnapshotWriter); ok {
+		err := linkingWriter.LinkArtifact(ctx, name, digest)
+		if err == nil {
+			return false, 0, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return false, 0, err
+		}
+	}
+
+	reader, err := source.ReadArtifact(ctx, digest)
+	if err != nil {
+		return false, 0, err
+	}
+	defer reader.Close()
+
+	writer, err := snapshotWriter.NextArtifactWriter(ctx, name)
+	if err != nil {
+		return false, 0, err
+	}
+
+	n, err := io.Copy(writer, reader)
+	if err != nil {
+		writer.Close()
+		return false, n, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return false, n, err
+	}
+
+	// Never replicate corrupt blobs
+	if reader.Digest() != digest || writer.Digest() != digest {
+		return false, n, fmt.Errorf("digest mismatch for %s: read %s, wrote %s", digest, reader.Digest(), writer.Digest())
+	}
+
+	return true, n, nil
+}
@@ -0,0 +1,84 @@
+package replication
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/libraries/disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicate(t *testing.T) {
+	source, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer source.Close()
+
+	target, err := disk.NewLibrary(t.TempDir(), &disk.LibraryOptions{ContentEncoding: "gzip"})
+	require.NoError(t, err)
+	defer target.Close()
+
+	write := func(library *disk.Library, id string, name string, data []byte) string {
+		snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", id)
+		require.NoError(t, err)
+		defer snapshotWriter.Close()
+
+		size, digest, err := snapshotWriter.WriteArtifact(context.TODO(), name, data)
+		require.NoError(t, err)
+		require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "text/plain",
+			Digest:      digest,
+			Size:        size,
+			Annotations: map[string]string{
+				"larch.artifact.path": name,
+			},
+		}))
+		require.NoError(t, snapshotWriter.Close())
+		return digest
+	}
+
+	first := write(source, "1", "first.txt", []byte("first"))
+	second := write(source, "2", "second.txt", []byte("second"))
+	// Shares its blob with the first snapshot
+	write(source, "3", "first.txt", []byte("first"))
+
+	// Partially replicated already
+	write(target, "2", "second.txt", []byte("second"))
+
+	report, err := Replicate(context.TODO(), source, target)
+	require.NoError(t, err)
+
+	assert.Equal(t, &Report{
+		Snapshots: 2,
+		Artifacts: 2,
+		Blobs:     1,
+		Bytes:     5,
+		Failed:    []string{},
+	}, report)
+
+	for id, digest := range map[string]string{"1": first, "2": second, "3": first} {
+		snapshotReader, err := target.ReadSnapshot(context.TODO(), "example.com", id)
+		require.NoError(t, err)
+
+		index := snapshotReader.Index()
+		require.Len(t, index.Artifacts, 1)
+		assert.Equal(t, digest, index.Artifacts[0].Digest)
+		assert.NotEmpty(t, index.Artifacts[0].Annotations["larch.artifact.path"])
+
+		reader, err := snapshotReader.NextArtifactReader(context.TODO(), digest)
+		require.NoError(t, err)
+		_, err = io.Copy(io.Discard, reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, digest, reader.Digest())
+
+		snapshotReader.Close()
+	}
+
+	// Nothing left to replicate
+	report, err = Replicate(context.TODO(), source, target)
+	require.NoError(t, err)
+	assert.Equal(t, &Report{Failed: []string{}}, report)
+}
@@ -0,0 +1,68 @@
+package retention
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditEntry is an entry of the audit log.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Action is the action taken, such as "deleteSnapshot".
+	Action   string `json:"action"`
+	Library  string `json:"library"`
+	Origin   string `json:"origin"`
+	Snapshot string `json:"snapshot"`
+	URL      string `json:"url,omitempty"`
+	// Reason is why the action was taken, such as the retention policy that
+	// applied or "api".
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AuditLog is an append-only log of destructive actions, stored as JSON
+// lines.
+type AuditLog struct {
+	mutex sync.Mutex
+	path  string
+}
+
+func NewAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	return &AuditLog{
+		path: path,
+	}, nil
+}
+
+// Record appends an entry to the log.
+func (a *AuditLog) Record(entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,33 @@
+package retention
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "audit.jsonl")
+
+	audit, err := NewAuditLog(path)
+	require.NoError(t, err)
+
+	date := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, audit.Record(AuditEntry{Time: date, Action: "deleteSnapshot", Snapshot: "1", Reason: "api"}))
+	require.NoError(t, audit.Record(AuditEntry{Action: "deleteSnapshot", Snapshot: "2", Error: "failed"}))
+
+	// Entries are appended as JSON lines, the time is set if unset
+	entries := readAuditLog(t, path)
+	require.Len(t, entries, 2)
+
+	assert.True(t, date.Equal(entries[0].Time))
+	assert.Equal(t, "1", entries[0].Snapshot)
+	assert.Equal(t, "api", entries[0].Reason)
+
+	assert.False(t, entries[1].Time.IsZero())
+	assert.Equal(t, "2", entries[1].Snapshot)
+	assert.Equal(t, "failed", entries[1].Error)
+}
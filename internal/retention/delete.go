@@ -0,0 +1,140 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/AlexGustafsson/larch/internal/indexers"
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/quota"
+)
+
+// Deleter deletes snapshots from libraries. Deletions are removed from the
+// index and recorded in the audit log. The usage of libraries deleted from is
+// computed again once done.
+type Deleter struct {
+	LibraryReaders map[string]libraries.LibraryReader
+	LibraryWriters map[string]libraries.LibraryWriter
+	// Index is optional.
+	Index indexers.Indexer
+	// Audit is optional.
+	Audit *AuditLog
+	// Quotas is optional.
+	Quotas *quota.Tracker
+}
+
+// DeleteSnapshot deletes a snapshot from a library.
+func (d *Deleter) DeleteSnapshot(ctx context.Context, libraryID string, origin string, id string, reason string) error {
+	if err := d.deleteSnapshot(ctx, libraryID, origin, id, reason); err != nil {
+		return err
+	}
+
+	d.refreshUsage(ctx, libraryID)
+	return nil
+}
+
+// refreshUsage computes the usage of a library again, after deleting from it.
+// Deleting many snapshots should refresh once done, as it reads all of the
+// library's snapshots.
+func (d *Deleter) refreshUsage(ctx context.Context, libraryID string) {
+	if d.Quotas == nil {
+		return
+	}
+
+	if err := d.Quotas.RefreshLibrary(ctx, libraryID); err != nil {
+		slog.Warn("Failed to compute library usage after deletion", slog.String("library", libraryID), slog.Any("error", err))
+	}
+}
+
+// deleteSnapshot deletes a snapshot from a library, without refreshing the
+// library's usage.
+func (d *Deleter) deleteSnapshot(ctx context.Context, libraryID string, origin string, id string, reason string) error {
+	library, ok := d.LibraryWriters[libraryID]
+	if !ok {
+		return fmt.Errorf("library is read-only: %s", libraryID)
+	}
+
+	// Record the URL for posterity, the snapshot is gone once deleted
+	url := ""
+	if libraryReader, ok := d.LibraryReaders[libraryID]; ok {
+		snapshotReader, err := libraryReader.ReadSnapshot(ctx, origin, id)
+		if err == nil {
+			if index := snapshotReader.Index(); len(index.Artifacts) > 0 {
+				url = index.Artifacts[0].Annotations["larch.snapshot.url"]
+			}
+			snapshotReader.Close()
+		}
+	}
+
+	err := library.DeleteSnapshot(ctx, origin, id)
+	if errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	entry := AuditEntry{
+		Action:   "deleteSnapshot",
+		Library:  libraryID,
+		Origin:   origin,
+		Snapshot: id,
+		URL:      url,
+		Reason:   reason,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if d.Audit != nil {
+		if err := d.Audit.Record(entry); err != nil {
+			slog.Error("Failed to record audit entry", slog.Any("error", err))
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Deleted snapshot", slog.String("library", libraryID), slog.String("origin", origin), slog.String("snapshotId", id), slog.String("reason", reason))
+
+	if d.Index != nil {
+		if err := d.Index.RemoveSnapshot(ctx, libraryID, origin, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteOrigin deletes all snapshots of an origin from a library. Returns the
+// ids of the deleted snapshots.
+func (d *Deleter) DeleteOrigin(ctx context.Context, libraryID string, origin string, reason string) ([]string, error) {
+	libraryReader, ok := d.LibraryReaders[libraryID]
+	if !ok {
+		return nil, fmt.Errorf("no such library: %s", libraryID)
+	}
+
+	snapshots, err := libraryReader.GetSnapshots(ctx, origin)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	deleted := make([]string, 0)
+	defer func() {
+		if len(deleted) > 0 {
+			d.refreshUsage(ctx, libraryID)
+		}
+	}()
+
+	for _, id := range snapshots {
+		if err := d.deleteSnapshot(ctx, libraryID, origin, id, reason); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, err
+		}
+		deleted = append(deleted, id)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,129 @@
+package retention
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AlexGustafsson/larch/internal/indexers"
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/libraries/disk"
+	"github.com/AlexGustafsson/larch/internal/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAuditLog(t *testing.T, path string) []AuditEntry {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	defer file.Close()
+
+	entries := make([]AuditEntry, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+
+	return entries
+}
+
+func TestDeleter(t *testing.T) {
+	library, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer library.Close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		snapshotWriter, err := library.WriteSnapshot(context.TODO(), "example.com", id)
+		require.NoError(t, err)
+
+		require.NoError(t, snapshotWriter.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "application/vnd.larch.snapshot.manifest.v1+json",
+			Digest:      "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			Annotations: map[string]string{"larch.snapshot.url": "https://example.com"},
+		}))
+		require.NoError(t, snapshotWriter.Close())
+	}
+
+	index := indexers.NewInMemoryIndex()
+	require.NoError(t, index.IndexLibrary(context.TODO(), "local", library))
+
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := NewAuditLog(auditPath)
+	require.NoError(t, err)
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": library, "readonly": library}
+
+	quotas := quota.NewTracker(libraryReaders, map[string]quota.Quota{})
+	require.NoError(t, quotas.Refresh(context.TODO()))
+
+	deleter := &Deleter{
+		LibraryReaders: libraryReaders,
+		LibraryWriters: map[string]libraries.LibraryWriter{"local": library},
+		Index:          index,
+		Audit:          audit,
+		Quotas:         quotas,
+	}
+
+	snapshots := func() int {
+		status, ok := quotas.Status("local")
+		require.True(t, ok)
+		return status.Usage.Snapshots
+	}
+	assert.Equal(t, 3, snapshots())
+
+	// Deleted snapshots are removed from the library and the index, and recorded
+	require.NoError(t, deleter.DeleteSnapshot(context.TODO(), "local", "example.com", "1", "api"))
+
+	_, err = library.ReadSnapshot(context.TODO(), "example.com", "1")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = index.GetSnapshot(context.TODO(), "example.com", "1")
+	assert.ErrorIs(t, err, indexers.ErrNotFound)
+
+	// The library's usage is computed again
+	assert.Equal(t, 2, snapshots())
+
+	entries := readAuditLog(t, auditPath)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "deleteSnapshot", entries[0].Action)
+	assert.Equal(t, "local", entries[0].Library)
+	assert.Equal(t, "example.com", entries[0].Origin)
+	assert.Equal(t, "1", entries[0].Snapshot)
+	assert.Equal(t, "https://example.com", entries[0].URL)
+	assert.Equal(t, "api", entries[0].Reason)
+	assert.Empty(t, entries[0].Error)
+
+	// Missing snapshots are not recorded
+	err = deleter.DeleteSnapshot(context.TODO(), "local", "example.com", "1", "api")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Len(t, readAuditLog(t, auditPath), 1)
+
+	// Read-only libraries can't be deleted from
+	err = deleter.DeleteSnapshot(context.TODO(), "readonly", "example.com", "2", "api")
+	assert.ErrorContains(t, err, "library is read-only")
+	assert.Len(t, readAuditLog(t, auditPath), 1)
+
+	// Deleting an origin deletes all of its snapshots
+	deleted, err := deleter.DeleteOrigin(context.TODO(), "local", "example.com", "api")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"2", "3"}, deleted)
+	assert.Len(t, readAuditLog(t, auditPath), 3)
+	assert.Equal(t, 0, snapshots())
+
+	indexed, err := index.ListSnapshots(context.TODO(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, indexed)
+
+	deleted, err = deleter.DeleteOrigin(context.TODO(), "local", "example.com", "api")
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+}
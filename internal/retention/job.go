@@ -0,0 +1,172 @@
+package retention
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Job periodically applies retention policies.
+type Job struct {
+	Deleter *Deleter
+	// LibraryPolicies holds the policies of snapshots by library.
+	LibraryPolicies map[string]*Policy
+	// StrategyPolicies holds the policies of snapshots by the strategy they were
+	// scheduled with. Takes precedence over library policies.
+	StrategyPolicies map[string]*Policy
+	// Interval is the time between applying the policies.
+	Interval time.Duration
+}
+
+type Report struct {
+	DryRun bool `json:"dryRun"`
+	// Snapshots is the number of snapshots subject to a policy.
+	Snapshots int `json:"snapshots"`
+	// Deleted holds the deleted snapshots, formatted like so:
+	// <library>/<origin>/<id>.
+	Deleted []string `json:"deleted"`
+	// Failed holds the snapshots that failed to be deleted, formatted like so:
+	// <library>/<origin>/<id>: <error>.
+	Failed []string `json:"failed"`
+}
+
+// Run applies the policies every interval, until the context is cancelled.
+func (j *Job) Run(ctx context.Context) error {
+	for {
+		report, err := j.Apply(ctx, false)
+		if err != nil {
+			slog.Error("Failed to apply retention policies", slog.Any("error", err))
+		} else {
+			slog.Info("Applied retention policies", slog.Int("snapshots", report.Snapshots), slog.Int("deleted", len(report.Deleted)), slog.Int("failed", len(report.Failed)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(j.Interval):
+		}
+	}
+}
+
+// Apply applies the policies once. If dry run is set, snapshots to delete are
+// reported but not deleted.
+func (j *Job) Apply(ctx context.Context, dryRun bool) (*Report, error) {
+	report := &Report{
+		DryRun:  dryRun,
+		Deleted: make([]string, 0),
+		Failed:  make([]string, 0),
+	}
+
+	now := time.Now()
+	for libraryID := range j.Deleter.LibraryWriters {
+		deleted := false
+
+		// Snapshots by the policy that applies to them, either strategy:<id> or
+		// library:<id>
+		subjects, err := j.subjects(ctx, libraryID)
+		if err != nil {
+			return nil, err
+		}
+
+		for reason, snapshots := range subjects {
+			report.Snapshots += len(snapshots)
+
+			policy := j.policy(reason)
+			for _, snapshot := range policy.Apply(snapshots, now) {
+				name := snapshot.Library + "/" + snapshot.Origin + "/" + snapshot.ID
+				if dryRun {
+					report.Deleted = append(report.Deleted, name)
+					continue
+				}
+
+				if err := j.Deleter.deleteSnapshot(ctx, snapshot.Library, snapshot.Origin, snapshot.ID, "retention: "+reason); err != nil {
+					report.Failed = append(report.Failed, name+": "+err.Error())
+				} else {
+					report.Deleted = append(report.Deleted, name)
+					deleted = true
+				}
+			}
+		}
+
+		if deleted {
+			j.Deleter.refreshUsage(ctx, libraryID)
+		}
+	}
+
+	return report, nil
+}
+
+// policy returns the policy of a subject, see [Job.subjects].
+func (j *Job) policy(subject string) *Policy {
+	if id, ok := strings.CutPrefix(subject, "library:"); ok {
+		return j.LibraryPolicies[id]
+	}
+
+	return j.StrategyPolicies[strings.TrimPrefix(subject, "strategy:")]
+}
+
+// subjects returns the snapshots of a library subject to policies, by the
+// policy that applies.
+func (j *Job) subjects(ctx context.Context, libraryID string) (map[string][]Snapshot, error) {
+	subjects := make(map[string][]Snapshot)
+
+	library, ok := j.Deleter.LibraryReaders[libraryID]
+	if !ok {
+		return subjects, nil
+	}
+
+	origins, err := library.GetOrigins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, origin := range origins {
+		snapshots, err := library.GetSnapshots(ctx, origin)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range snapshots {
+			snapshotReader, err := library.ReadSnapshot(ctx, origin, id)
+			if err != nil {
+				slog.Warn("Skipping unreadable snapshot", slog.String("library", libraryID), slog.String("origin", origin), slog.String("snapshotId", id), slog.Any("error", err))
+				continue
+			}
+			index := snapshotReader.Index()
+			snapshotReader.Close()
+
+			// Leave snapshots being written alone
+			if len(index.Artifacts) == 0 || index.Partial {
+				continue
+			}
+
+			annotations := index.Artifacts[0].Annotations
+
+			subject := ""
+			if strategy := annotations["larch.snapshot.strategy"]; strategy != "" && j.StrategyPolicies[strategy] != nil {
+				subject = "strategy:" + strategy
+			} else if j.LibraryPolicies[libraryID] != nil {
+				subject = "library:" + libraryID
+			} else {
+				continue
+			}
+
+			// Snapshots of unknown age are never subject to policies
+			date, err := time.Parse(time.RFC3339, annotations["larch.snapshot.date"])
+			if err != nil {
+				continue
+			}
+
+			subjects[subject] = append(subjects[subject], Snapshot{
+				Library: libraryID,
+				Origin:  origin,
+				ID:      id,
+				URL:     annotations["larch.snapshot.url"],
+				Date:    date,
+			})
+		}
+	}
+
+	return subjects, nil
+}
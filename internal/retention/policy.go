@@ -0,0 +1,110 @@
+package retention
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Policy decides what snapshots to keep. Snapshots are grouped by URL, each
+// group being subject to the policy on its own. A snapshot is kept if any of
+// the keep rules keep it, or if there are no keep rules. Snapshots older than
+// the max age are removed, unless kept by KeepLast. The last snapshot of a URL
+// is never removed, no matter its age.
+type Policy struct {
+	// KeepLast keeps the last n snapshots.
+	KeepLast int
+	// KeepWithin keeps all snapshots newer than the duration.
+	KeepWithin time.Duration
+	// KeepDaily keeps the last snapshot of each of the last n days with
+	// snapshots.
+	KeepDaily int
+	// KeepWeekly keeps the last snapshot of each of the last n weeks with
+	// snapshots.
+	KeepWeekly int
+	// KeepMonthly keeps the last snapshot of each of the last n months with
+	// snapshots.
+	KeepMonthly int
+	// MaxAge removes snapshots older than the duration. Takes precedence over
+	// all keep rules but KeepLast.
+	MaxAge time.Duration
+}
+
+// Snapshot is a snapshot subject to a policy.
+type Snapshot struct {
+	Library string
+	Origin  string
+	ID      string
+	URL     string
+	Date    time.Time
+}
+
+func (p *Policy) hasKeepRules() bool {
+	return p.KeepLast > 0 || p.KeepWithin > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0
+}
+
+// Apply returns the snapshots to remove according to the policy, at the time
+// now.
+func (p *Policy) Apply(snapshots []Snapshot, now time.Time) []Snapshot {
+	groups := make(map[string][]Snapshot)
+	for _, snapshot := range snapshots {
+		groups[snapshot.URL] = append(groups[snapshot.URL], snapshot)
+	}
+
+	remove := make([]Snapshot, 0)
+	for _, group := range groups {
+		// Newest first
+		slices.SortFunc(group, func(a Snapshot, b Snapshot) int {
+			return b.Date.Compare(a.Date)
+		})
+
+		var lastDay, lastWeek, lastMonth string
+		daily, weekly, monthly := p.KeepDaily, p.KeepWeekly, p.KeepMonthly
+		for i, snapshot := range group {
+			age := now.Sub(snapshot.Date)
+			// Never remove all snapshots of a URL, a URL not archived for a long
+			// time would otherwise be lost
+			if p.MaxAge > 0 && age > p.MaxAge && i >= max(p.KeepLast, 1) {
+				remove = append(remove, snapshot)
+				continue
+			}
+
+			keep := !p.hasKeepRules()
+
+			if i < p.KeepLast {
+				keep = true
+			}
+
+			if p.KeepWithin > 0 && age <= p.KeepWithin {
+				keep = true
+			}
+
+			date := snapshot.Date.UTC()
+
+			if day := date.Format(time.DateOnly); daily > 0 && day != lastDay {
+				lastDay = day
+				daily--
+				keep = true
+			}
+
+			year, week := date.ISOWeek()
+			if week := fmt.Sprintf("%d-W%02d", year, week); weekly > 0 && week != lastWeek {
+				lastWeek = week
+				weekly--
+				keep = true
+			}
+
+			if month := date.Format("2006-01"); monthly > 0 && month != lastMonth {
+				lastMonth = month
+				monthly--
+				keep = true
+			}
+
+			if !keep {
+				remove = append(remove, snapshot)
+			}
+		}
+	}
+
+	return remove
+}
@@ -0,0 +1,119 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyApply(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	// Two snapshots a day, for 60 days
+	snapshots := make([]Snapshot, 0)
+	for i := range 120 {
+		snapshots = append(snapshots, Snapshot{
+			ID:   now.Add(-time.Duration(i) * 12 * time.Hour).Format(time.RFC3339),
+			URL:  "https://example.com",
+			Date: now.Add(-time.Duration(i) * 12 * time.Hour),
+		})
+	}
+
+	other := Snapshot{
+		ID:   "other",
+		URL:  "https://example.com/other",
+		Date: now.Add(-59 * 24 * time.Hour),
+	}
+
+	testCases := []struct {
+		Name      string
+		Policy    Policy
+		Snapshots []Snapshot
+		Kept      int
+	}{
+		{
+			Name:      "no rules",
+			Policy:    Policy{},
+			Snapshots: snapshots,
+			Kept:      120,
+		},
+		{
+			Name:      "keep last",
+			Policy:    Policy{KeepLast: 3},
+			Snapshots: snapshots,
+			Kept:      3,
+		},
+		{
+			Name:      "keep within",
+			Policy:    Policy{KeepWithin: 48 * time.Hour},
+			Snapshots: snapshots,
+			Kept:      5,
+		},
+		{
+			Name:      "keep daily",
+			Policy:    Policy{KeepDaily: 7},
+			Snapshots: snapshots,
+			Kept:      7,
+		},
+		{
+			// 2024-03-15 is a friday, the weeks' last snapshots are on the 15th,
+			// 10th, 3rd and 25th of february
+			Name:      "keep weekly",
+			Policy:    Policy{KeepWeekly: 4},
+			Snapshots: snapshots,
+			Kept:      4,
+		},
+		{
+			Name:      "keep monthly",
+			Policy:    Policy{KeepMonthly: 12},
+			Snapshots: snapshots,
+			Kept:      3,
+		},
+		{
+			// Rules overlap, the daily snapshots include the last snapshot and the
+			// snapshots of the last two weeks
+			Name:      "keep last, daily and weekly",
+			Policy:    Policy{KeepLast: 1, KeepDaily: 7, KeepWeekly: 4},
+			Snapshots: snapshots,
+			Kept:      9,
+		},
+		{
+			Name:      "max age",
+			Policy:    Policy{MaxAge: 24 * time.Hour},
+			Snapshots: snapshots,
+			Kept:      3,
+		},
+		{
+			Name:      "max age takes precedence over keep within",
+			Policy:    Policy{KeepWithin: 48 * time.Hour, MaxAge: 24 * time.Hour},
+			Snapshots: snapshots,
+			Kept:      3,
+		},
+		{
+			Name:      "keep last takes precedence over max age",
+			Policy:    Policy{KeepLast: 10, MaxAge: 24 * time.Hour},
+			Snapshots: snapshots,
+			Kept:      10,
+		},
+		{
+			Name:      "max age keeps the last snapshot",
+			Policy:    Policy{MaxAge: 24 * time.Hour},
+			Snapshots: []Snapshot{other},
+			Kept:      1,
+		},
+		{
+			Name:      "grouped by url",
+			Policy:    Policy{KeepLast: 1},
+			Snapshots: append([]Snapshot{other}, snapshots...),
+			Kept:      2,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			removed := testCase.Policy.Apply(testCase.Snapshots, now)
+			assert.Equal(t, testCase.Kept, len(testCase.Snapshots)-len(removed))
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package rules
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+
+	urlpkg "net/url"
+)
+
+// Rewrite replaces matches of a pattern in a URL.
+type Rewrite struct {
+	Pattern *regexp.Regexp
+	// Replacement is the replacement of matches, as supported by
+	// [regexp.Regexp.ReplaceAllString].
+	Replacement string
+}
+
+// Rewriter normalizes and rewrites URLs before they are scheduled, so that
+// variants of the same URL share a URL and origin.
+//
+// URLs are always canonicalized by lowercasing the scheme and host, removing
+// default ports and the host's trailing dot and using / as an empty path.
+type Rewriter struct {
+	// StripParameters holds glob patterns, as supported by [path.Match], of
+	// query parameters to remove. For example utm_* or fbclid.
+	StripParameters []string
+	// StripFragment removes the fragment.
+	StripFragment bool
+	// StripHostPrefixes holds prefixes to remove from the host, such as www.
+	// or m.
+	StripHostPrefixes []string
+	// Rewrites are applied in order, after the URL is normalized.
+	Rewrites []Rewrite
+}
+
+// Rewrite returns the normalized and rewritten URL.
+func (r *Rewriter) Rewrite(url string) (string, error) {
+	u, err := urlpkg.Parse(url)
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	for _, prefix := range r.StripHostPrefixes {
+		// Don't strip the prefix of hosts such as www.com
+		if stripped, ok := strings.CutPrefix(host, strings.ToLower(prefix)); ok && strings.Contains(stripped, ".") {
+			host = stripped
+			break
+		}
+	}
+
+	if port == "" {
+		if strings.Contains(host, ":") {
+			// IPv6
+			u.Host = "[" + host + "]"
+		} else {
+			u.Host = host
+		}
+	} else {
+		u.Host = net.JoinHostPort(host, port)
+	}
+
+	if u.Host != "" && u.Path == "" {
+		u.Path = "/"
+	}
+
+	if len(r.StripParameters) > 0 && u.RawQuery != "" {
+		// Filter the raw query rather than re-encoding it, to keep the order and
+		// encoding of the remaining parameters
+		parameters := make([]string, 0)
+		for parameter := range strings.SplitSeq(u.RawQuery, "&") {
+			key, _, _ := strings.Cut(parameter, "=")
+			if unescaped, err := urlpkg.QueryUnescape(key); err == nil {
+				key = unescaped
+			}
+
+			strip, err := r.strip(key)
+			if err != nil {
+				return "", err
+			} else if !strip && parameter != "" {
+				parameters = append(parameters, parameter)
+			}
+		}
+		u.RawQuery = strings.Join(parameters, "&")
+		u.ForceQuery = false
+	}
+
+	if r.StripFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	rewritten := u.String()
+	for _, rewrite := range r.Rewrites {
+		rewritten = rewrite.Pattern.ReplaceAllString(rewritten, rewrite.Replacement)
+	}
+
+	return rewritten, nil
+}
+
+func (r *Rewriter) strip(parameter string) (bool, error) {
+	for _, pattern := range r.StripParameters {
+		ok, err := path.Match(pattern, parameter)
+		if err != nil {
+			return false, fmt.Errorf("invalid parameter pattern: %w", err)
+		} else if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
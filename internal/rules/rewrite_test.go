@@ -0,0 +1,43 @@
+package rules
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriterRewrite(t *testing.T) {
+	rewriter := &Rewriter{
+		StripParameters:   []string{"utm_*", "fbclid"},
+		StripFragment:     true,
+		StripHostPrefixes: []string{"www.", "m."},
+		Rewrites: []Rewrite{
+			{Pattern: regexp.MustCompile(`^https://reddit\.com/`), Replacement: "https://old.reddit.com/"},
+		},
+	}
+
+	testCases := []struct {
+		URL      string
+		Expected string
+	}{
+		{URL: "HTTPS://WWW.Example.com:443", Expected: "https://example.com/"},
+		{URL: "http://example.com.:8080/a", Expected: "http://example.com:8080/a"},
+		{URL: "https://m.example.com/a#section", Expected: "https://example.com/a"},
+		{URL: "https://www.com/", Expected: "https://www.com/"},
+		{URL: "https://example.com/?utm_source=feed&id=1&utm_medium=rss&fbclid=x", Expected: "https://example.com/?id=1"},
+		{URL: "https://example.com/?utm_source=feed", Expected: "https://example.com/"},
+		{URL: "https://example.com/?q=a+b&flag", Expected: "https://example.com/?q=a+b&flag"},
+		{URL: "https://www.reddit.com/r/golang", Expected: "https://old.reddit.com/r/golang"},
+		{URL: "http://[::1]:80/", Expected: "http://[::1]/"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.URL, func(t *testing.T) {
+			actual, err := rewriter.Rewrite(testCase.URL)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.Expected, actual)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	urlpkg "net/url"
+)
+
+// Rule routes matching URLs to a strategy and, optionally, a library. All of
+// the rule's conditions must match. A rule without conditions matches all
+// URLs.
+type Rule struct {
+	// Host is a glob pattern, as supported by [path.Match], matched against
+	// the URL's host name. For example *.youtube.com.
+	Host string
+	// Path is matched against the URL's path.
+	Path *regexp.Regexp
+	// ContentType is a glob pattern, as supported by [path.Match], matched
+	// against the media type returned by a HEAD request to the URL. For
+	// example application/pdf or image/*.
+	ContentType string
+	Strategy    string
+	// Library overrides the strategy's library, if set.
+	Library string
+}
+
+// Route is the result of routing a URL.
+type Route struct {
+	Strategy string
+	// Library is the library to use instead of the strategy's library. Empty
+	// if the strategy's library should be used.
+	Library string
+}
+
+// Router routes URLs using a list of rules, where the first matching rule
+// wins.
+type Router struct {
+	Rules  []Rule
+	Client *http.Client
+}
+
+// Route returns the route of the first rule matching the URL. If no rule
+// matches, the fallback strategy is used.
+func (r *Router) Route(ctx context.Context, url string, fallback string) (*Route, error) {
+	u, err := urlpkg.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only resolve the content type if needed, and at most once
+	contentType := ""
+	resolvedContentType := false
+
+	for _, rule := range r.Rules {
+		if rule.Host != "" {
+			ok, err := path.Match(strings.ToLower(rule.Host), strings.ToLower(u.Hostname()))
+			if err != nil {
+				return nil, fmt.Errorf("invalid host pattern: %w", err)
+			} else if !ok {
+				continue
+			}
+		}
+
+		if rule.Path != nil && !rule.Path.MatchString(u.Path) {
+			continue
+		}
+
+		if rule.ContentType != "" {
+			if !resolvedContentType {
+				contentType, err = r.contentType(ctx, url)
+				if err != nil {
+					slog.Warn("Failed to resolve content type for routing", slog.String("url", url), slog.Any("error", err))
+				}
+				resolvedContentType = true
+			}
+
+			ok, err := path.Match(strings.ToLower(rule.ContentType), contentType)
+			if err != nil {
+				return nil, fmt.Errorf("invalid content type pattern: %w", err)
+			} else if !ok {
+				continue
+			}
+		}
+
+		return &Route{
+			Strategy: rule.Strategy,
+			Library:  rule.Library,
+		}, nil
+	}
+
+	return &Route{Strategy: fallback}, nil
+}
+
+// contentType returns the media type of the resource at the URL.
+func (r *Router) contentType(ctx context.Context, url string) (string, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+
+	// Not all servers support HEAD requests, fall back to a GET request and
+	// only read the headers
+	if res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return "", err
+		}
+
+		res, err = client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		res.Body.Close()
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return "", err
+	}
+
+	return mediaType, nil
+}
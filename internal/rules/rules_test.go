@@ -0,0 +1,57 @@
+package rules
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterRoute(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/paper":
+			w.Header().Set("Content-Type", "application/pdf")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+	}))
+	defer server.Close()
+
+	router := &Router{
+		Rules: []Rule{
+			{Host: "*.youtube.com", Strategy: "bookmark"},
+			{Host: "youtube.com", Strategy: "bookmark"},
+			{Host: "news.example", Path: regexp.MustCompile(`^/articles/`), Strategy: "archive", Library: "offsite"},
+			{ContentType: "application/pdf", Strategy: "download"},
+			{ContentType: "image/*", Strategy: "download"},
+		},
+		Client: server.Client(),
+	}
+
+	testCases := []struct {
+		URL      string
+		Expected Route
+	}{
+		{URL: "https://www.youtube.com/watch?v=1", Expected: Route{Strategy: "bookmark"}},
+		{URL: "https://YouTube.com/watch?v=1", Expected: Route{Strategy: "bookmark"}},
+		{URL: "https://news.example/articles/1", Expected: Route{Strategy: "archive", Library: "offsite"}},
+		{URL: server.URL + "/paper", Expected: Route{Strategy: "download"}},
+		{URL: server.URL + "/image", Expected: Route{Strategy: "download"}},
+		{URL: server.URL + "/page", Expected: Route{Strategy: "fallback"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.URL, func(t *testing.T) {
+			route, err := router.Route(context.Background(), testCase.URL, "fallback")
+			require.NoError(t, err)
+			assert.Equal(t, testCase.Expected, *route)
+		})
+	}
+}
@@ -0,0 +1,409 @@
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	urlpkg "net/url"
+)
+
+var _ Source = (*FeedSource)(nil)
+
+// FeedSource is a source of the entries of an RSS, Atom or JSON Feed document.
+type FeedSource struct {
+	URL    string
+	Client *http.Client
+}
+
+// Items implements Source.
+func (f *FeedSource) Items(ctx context.Context, state *State) ([]Item, error) {
+	feed, err := f.fetch(ctx, state)
+	if err != nil {
+		return nil, err
+	} else if feed == nil {
+		// Not modified
+		return nil, nil
+	}
+
+	items := make([]Item, 0)
+	for _, entry := range feed.Entries {
+		if entry.URL == "" {
+			continue
+		}
+
+		items = append(items, Item{
+			ID:  entry.ID,
+			URL: entry.URL,
+		})
+	}
+
+	return items, nil
+}
+
+// fetch fetches and parses the feed. Returns nil if the feed has not been
+// modified since the state was last updated.
+func (f *FeedSource) fetch(ctx context.Context, state *State) (*Feed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/feed+json, application/xml;q=0.9, application/json;q=0.9, */*;q=0.8")
+
+	if state.ETag != "" {
+		req.Header.Set("If-None-Match", state.ETag)
+	}
+
+	if state.LastModified != "" {
+		req.Header.Set("If-Modified-Since", state.LastModified)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	feed, err := ParseFeed(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	state.ETag = res.Header.Get("ETag")
+	state.LastModified = res.Header.Get("Last-Modified")
+
+	// Entries may link relative to the feed itself
+	base, err := urlpkg.Parse(f.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range feed.Entries {
+		u, err := base.Parse(entry.URL)
+		if err != nil {
+			continue
+		}
+
+		feed.Entries[i].URL = u.String()
+	}
+
+	return feed, nil
+}
+
+// Feed is the common representation of a parsed RSS, Atom or JSON Feed
+// document.
+type Feed struct {
+	Title   string
+	Entries []FeedEntry
+}
+
+type FeedEntry struct {
+	// ID is the entry's unique id, if specified by the feed. Defaults to the
+	// entry's URL.
+	ID    string
+	URL   string
+	Title string
+	// Date is the time the entry was last updated or published, if known.
+	Date time.Time
+}
+
+// ParseFeed parses an RSS 2.0 (or RSS 1.0), Atom or JSON Feed document.
+func ParseFeed(r io.Reader) (*Feed, error) {
+	reader := bufio.NewReader(r)
+
+	// Skip any byte order mark and leading whitespace to sniff the format
+	for {
+		c, _, err := reader.ReadRune()
+		if err != nil {
+			return nil, fmt.Errorf("invalid feed: %w", err)
+		}
+
+		if c == '\uFEFF' || c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+
+		if err := reader.UnreadRune(); err != nil {
+			return nil, err
+		}
+
+		if c == '{' {
+			return parseJSONFeed(reader)
+		}
+
+		return parseXMLFeed(reader)
+	}
+}
+
+type rssDocument struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	// RSS 1.0 (RDF) documents have their items next to the channel
+	Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+	Date    string `xml:"http://purl.org/dc/elements/1.1/ date"`
+}
+
+type atomDocument struct {
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+func parseXMLFeed(r io.Reader) (*Feed, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.CharsetReader = charsetReader
+
+	var root xml.StartElement
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid feed: %w", err)
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			root = start
+			break
+		}
+	}
+
+	switch root.Name.Local {
+	case "rss", "RDF":
+		var document rssDocument
+		if err := decoder.DecodeElement(&document, &root); err != nil {
+			return nil, fmt.Errorf("invalid rss feed: %w", err)
+		}
+
+		feed := &Feed{
+			Title:   strings.TrimSpace(document.Channel.Title),
+			Entries: make([]FeedEntry, 0),
+		}
+
+		for _, item := range append(document.Channel.Items, document.Items...) {
+			entry := FeedEntry{
+				ID:    strings.TrimSpace(item.GUID),
+				URL:   strings.TrimSpace(item.Link),
+				Title: strings.TrimSpace(item.Title),
+				Date:  parseDate(item.PubDate, item.Date),
+			}
+
+			// Items may only specify a permalink guid
+			if entry.URL == "" && (strings.HasPrefix(entry.ID, "http://") || strings.HasPrefix(entry.ID, "https://")) {
+				entry.URL = entry.ID
+			}
+
+			if entry.ID == "" {
+				entry.ID = entry.URL
+			}
+
+			feed.Entries = append(feed.Entries, entry)
+		}
+
+		return feed, nil
+	case "feed":
+		var document atomDocument
+		if err := decoder.DecodeElement(&document, &root); err != nil {
+			return nil, fmt.Errorf("invalid atom feed: %w", err)
+		}
+
+		feed := &Feed{
+			Title:   strings.TrimSpace(document.Title),
+			Entries: make([]FeedEntry, 0),
+		}
+
+		for _, item := range document.Entries {
+			entry := FeedEntry{
+				ID:    strings.TrimSpace(item.ID),
+				Title: strings.TrimSpace(item.Title),
+				Date:  parseDate(item.Updated, item.Published),
+			}
+
+			for _, link := range item.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					entry.URL = strings.TrimSpace(link.Href)
+					break
+				}
+			}
+
+			if entry.ID == "" {
+				entry.ID = entry.URL
+			}
+
+			feed.Entries = append(feed.Entries, entry)
+		}
+
+		return feed, nil
+	default:
+		return nil, fmt.Errorf("unsupported feed format: %s", root.Name.Local)
+	}
+}
+
+type jsonFeedDocument struct {
+	Version string `json:"version"`
+	Title   string `json:"title"`
+	Items   []struct {
+		ID            json.RawMessage `json:"id"`
+		URL           string          `json:"url"`
+		ExternalURL   string          `json:"external_url"`
+		Title         string          `json:"title"`
+		DatePublished string          `json:"date_published"`
+		DateModified  string          `json:"date_modified"`
+	} `json:"items"`
+}
+
+func parseJSONFeed(r io.Reader) (*Feed, error) {
+	var document jsonFeedDocument
+	if err := json.NewDecoder(r).Decode(&document); err != nil {
+		return nil, fmt.Errorf("invalid json feed: %w", err)
+	}
+
+	if !strings.HasPrefix(document.Version, "https://jsonfeed.org/version/") {
+		return nil, fmt.Errorf("unsupported feed format: %s", document.Version)
+	}
+
+	feed := &Feed{
+		Title:   document.Title,
+		Entries: make([]FeedEntry, 0),
+	}
+
+	for _, item := range document.Items {
+		// NOTE: The id should be a string, but some feeds use numbers
+		var id string
+		if err := json.Unmarshal(item.ID, &id); err != nil {
+			id = string(bytes.TrimSpace(item.ID))
+		}
+
+		entry := FeedEntry{
+			ID:    id,
+			URL:   item.URL,
+			Title: item.Title,
+			Date:  parseDate(item.DateModified, item.DatePublished),
+		}
+
+		if entry.URL == "" {
+			entry.URL = item.ExternalURL
+		}
+
+		if entry.ID == "" {
+			entry.ID = entry.URL
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return feed, nil
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02",
+}
+
+// parseDate returns the first of the values that parse as a date.
+func parseDate(values ...string) time.Time {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		for _, layout := range dateLayouts {
+			date, err := time.Parse(layout, value)
+			if err == nil {
+				return date
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// charsetReader supports the most common non-UTF-8 encodings found in feeds.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "latin1", "latin-1":
+		return &latin1Reader{reader: bufio.NewReader(input)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	}
+}
+
+type latin1Reader struct {
+	reader  *bufio.Reader
+	pending []byte
+}
+
+// Read implements io.Reader.
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.pending) > 0 {
+			c := copy(p[n:], l.pending)
+			l.pending = l.pending[c:]
+			n += c
+			continue
+		}
+
+		b, err := l.reader.ReadByte()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+
+		// Latin-1 maps 1:1 to the first 256 code points
+		l.pending = []byte(string(rune(b)))
+	}
+
+	return n, nil
+}
@@ -0,0 +1,127 @@
+package sources
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFeed(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		File     string
+		Expected []FeedEntry
+	}{
+		{
+			Name: "RSS",
+			File: "testdata/rss.xml",
+			Expected: []FeedEntry{
+				{
+					ID:    "https://example.com/posts/second/",
+					URL:   "https://example.com/posts/second/",
+					Title: "Second post",
+					Date:  time.Date(2025, 6, 3, 10, 0, 0, 0, time.UTC),
+				},
+				{
+					ID:    "first",
+					URL:   "/posts/first/",
+					Title: "First post",
+					Date:  time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			Name: "Atom",
+			File: "testdata/atom.xml",
+			Expected: []FeedEntry{
+				{
+					ID:    "urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a",
+					URL:   "https://example.com/posts/second/",
+					Title: "Second post",
+					Date:  time.Date(2025, 6, 3, 10, 0, 0, 0, time.UTC),
+				},
+				{
+					ID:    "urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6b",
+					URL:   "https://example.com/posts/first/",
+					Title: "First post",
+					Date:  time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			Name: "JSON Feed",
+			File: "testdata/feed.json",
+			Expected: []FeedEntry{
+				{
+					ID:    "2",
+					URL:   "https://example.com/posts/second/",
+					Title: "Second post",
+					Date:  time.Date(2025, 6, 3, 10, 0, 0, 0, time.UTC),
+				},
+				{
+					ID:    "1",
+					URL:   "https://example.org/linked/",
+					Title: "First post",
+					Date:  time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			file, err := os.Open(testCase.File)
+			require.NoError(t, err)
+			defer file.Close()
+
+			feed, err := ParseFeed(file)
+			require.NoError(t, err)
+
+			assert.Equal(t, "Example blog", feed.Title)
+			require.Len(t, feed.Entries, len(testCase.Expected))
+			for i, expected := range testCase.Expected {
+				assert.Equal(t, expected.ID, feed.Entries[i].ID)
+				assert.Equal(t, expected.URL, feed.Entries[i].URL)
+				assert.Equal(t, expected.Title, feed.Entries[i].Title)
+				assert.True(t, expected.Date.Equal(feed.Entries[i].Date), "expected %s, got %s", expected.Date, feed.Entries[i].Date)
+			}
+		})
+	}
+}
+
+func TestFeedSource(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	source := &FeedSource{
+		URL:    server.URL + "/rss.xml",
+		Client: server.Client(),
+	}
+
+	var state State
+	items, err := source.Items(context.Background(), &state)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Item{
+		{
+			ID:  "https://example.com/posts/second/",
+			URL: "https://example.com/posts/second/",
+		},
+		{
+			ID:  "first",
+			URL: server.URL + "/posts/first/",
+		},
+	}, items)
+
+	// The file server supports conditional requests
+	assert.NotEmpty(t, state.LastModified)
+	items, err = source.Items(context.Background(), &state)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
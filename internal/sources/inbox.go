@@ -0,0 +1,260 @@
+package sources
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	urlpkg "net/url"
+)
+
+var _ Source = (*InboxSource)(nil)
+var _ Committer = (*InboxSource)(nil)
+
+// inboxSettleTime is the time a file must have been left untouched before it
+// is processed, to not read files which are still being written.
+const inboxSettleTime = 5 * time.Second
+
+// InboxSource is a source of the URLs in files dropped in a directory. Once
+// processed, files are moved to the done or failed subdirectory.
+//
+// Supported files are Windows Internet shortcuts (.url), macOS Web locations
+// (.webloc), text files with one URL per line (.txt) and bookmark files in the
+// Netscape bookmark file format (.html). Files holding anything but absolute
+// http(s) URLs are moved to the failed subdirectory. Hidden files are ignored,
+// so writers can write to a hidden file before renaming it to have it picked
+// up.
+type InboxSource struct {
+	Path string
+
+	mutex sync.Mutex
+	// pending holds the name of the files processed by the last run
+	pending map[string]struct{}
+}
+
+// Items implements Source.
+func (i *InboxSource) Items(ctx context.Context, state *State) ([]Item, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	root, err := os.OpenRoot(i.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	dir, err := root.Open(".")
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	i.pending = make(map[string]struct{})
+
+	items := make([]Item, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		if time.Since(info.ModTime()) < inboxSettleTime {
+			continue
+		}
+
+		urls, err := readInboxFile(root, name)
+		if err != nil {
+			slog.Warn("Failed to read inbox file", slog.String("file", name), slog.Any("error", err))
+			if err := moveInboxFile(root, name, "failed"); err != nil {
+				slog.Warn("Failed to move inbox file", slog.String("file", name), slog.Any("error", err))
+			}
+			continue
+		}
+
+		i.pending[name] = struct{}{}
+		for j, url := range urls {
+			items = append(items, Item{
+				ID:  name + "#" + strconv.Itoa(j),
+				URL: url,
+			})
+		}
+	}
+
+	return items, nil
+}
+
+// Commit implements Committer.
+func (i *InboxSource) Commit(ctx context.Context, failed []Item) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	root, err := os.OpenRoot(i.Path)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	failedFiles := make(map[string]struct{})
+	for _, item := range failed {
+		name := item.ID[:strings.LastIndex(item.ID, "#")]
+		failedFiles[name] = struct{}{}
+	}
+
+	var errs []error
+	for name := range i.pending {
+		dir := "done"
+		if _, ok := failedFiles[name]; ok {
+			dir = "failed"
+		}
+
+		errs = append(errs, moveInboxFile(root, name, dir))
+	}
+
+	i.pending = nil
+	return errors.Join(errs...)
+}
+
+func moveInboxFile(root *os.Root, name string, dir string) error {
+	if err := root.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Prefix the file to not overwrite previous files of the same name
+	target := filepath.Join(dir, time.Now().UTC().Format("20060102T150405")+"-"+name)
+	return root.Rename(name, target)
+}
+
+func readInboxFile(root *os.Root, name string) ([]string, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var urls []string
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".url":
+		urls, err = parseInternetShortcut(file)
+	case ".webloc":
+		urls, err = parseWebloc(file)
+	case ".txt":
+		urls, err = parseURLList(file)
+	case ".html", ".htm":
+		var bookmarks []NetscapeBookmark
+		bookmarks, err = ParseNetscapeBookmarks(file)
+		for _, bookmark := range bookmarks {
+			urls = append(urls, bookmark.URL)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no urls found")
+	}
+
+	// Only schedule web pages, never local files or scripts
+	for _, url := range urls {
+		u, err := urlpkg.Parse(url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid url: %q", url)
+		}
+	}
+
+	return urls, nil
+}
+
+// parseInternetShortcut parses a Windows Internet shortcut (.url).
+func parseInternetShortcut(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	section := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if ok && section == "[InternetShortcut]" && strings.EqualFold(strings.TrimSpace(key), "URL") {
+			return []string{strings.TrimSpace(value)}, nil
+		}
+	}
+
+	return nil, scanner.Err()
+}
+
+// parseWebloc parses a macOS Web location (.webloc) in the XML property list
+// format.
+func parseWebloc(r io.Reader) ([]string, error) {
+	decoder := xml.NewDecoder(r)
+
+	// The URL is the string following the URL key of the root dictionary
+	key := ""
+	element := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			element = token.Name.Local
+		case xml.EndElement:
+			element = ""
+		case xml.CharData:
+			switch element {
+			case "key":
+				key = string(token)
+			case "string":
+				if key == "URL" {
+					return []string{strings.TrimSpace(string(token))}, nil
+				}
+			}
+		}
+	}
+}
+
+// parseURLList parses a text file with one URL per line. Empty lines and lines
+// starting with # are ignored.
+func parseURLList(r io.Reader) ([]string, error) {
+	urls := make([]string, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		urls = append(urls, line)
+	}
+
+	return urls, scanner.Err()
+}
@@ -0,0 +1,149 @@
+package sources
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadInboxFile(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		File     string
+		Content  string
+		Expected []string
+		Error    bool
+	}{
+		{
+			Name:     "Internet shortcut",
+			File:     "example.url",
+			Content:  "[DEFAULT]\r\nBASEURL=https://example.org/\r\n[InternetShortcut]\r\nURL=https://example.com/\r\n",
+			Expected: []string{"https://example.com/"},
+		},
+		{
+			Name:     "Web location",
+			File:     "example.webloc",
+			Content:  `<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><dict><key>URL</key><string>https://example.com/</string></dict></plist>`,
+			Expected: []string{"https://example.com/"},
+		},
+		{
+			Name:     "URL list",
+			File:     "urls.txt",
+			Content:  "# Comment\nhttps://example.com/\n\n  http://example.org/  \n",
+			Expected: []string{"https://example.com/", "http://example.org/"},
+		},
+		{
+			Name:     "Bookmarks",
+			File:     "bookmarks.html",
+			Content:  `<DL><p><DT><A HREF="https://example.com/">Example</A><DT><A HREF="javascript:alert(1)">Bookmarklet</A></DL>`,
+			Expected: []string{"https://example.com/"},
+		},
+		{
+			Name:    "Local file",
+			File:    "example.url",
+			Content: "[InternetShortcut]\nURL=file:///etc/passwd\n",
+			Error:   true,
+		},
+		{
+			Name:    "Script",
+			File:    "example.webloc",
+			Content: `<plist version="1.0"><dict><key>URL</key><string>javascript:alert(1)</string></dict></plist>`,
+			Error:   true,
+		},
+		{
+			Name:    "Relative URL",
+			File:    "urls.txt",
+			Content: "https://example.com/\n/relative\n",
+			Error:   true,
+		},
+		{
+			Name:    "Empty",
+			File:    "urls.txt",
+			Content: "# Nothing here\n",
+			Error:   true,
+		},
+		{
+			Name:    "Unsupported file",
+			File:    "example.pdf",
+			Content: "https://example.com/",
+			Error:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			root, err := os.OpenRoot(t.TempDir())
+			require.NoError(t, err)
+			defer root.Close()
+
+			require.NoError(t, root.WriteFile(testCase.File, []byte(testCase.Content), 0644))
+
+			urls, err := readInboxFile(root, testCase.File)
+			if testCase.Error {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, testCase.Expected, urls)
+			}
+		})
+	}
+}
+
+func TestInboxSource(t *testing.T) {
+	path := t.TempDir()
+
+	settled := time.Now().Add(-time.Minute)
+	write := func(name string, content string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(filepath.Join(path, name), []byte(content), 0644))
+		require.NoError(t, os.Chtimes(filepath.Join(path, name), modTime, modTime))
+	}
+
+	write("ok.txt", "https://example.com/1\nhttps://example.com/2\n", settled)
+	write("failing.txt", "https://example.com/3\n", settled)
+	write("invalid.txt", "file:///etc/passwd\n", settled)
+	write("recent.txt", "https://example.com/4\n", time.Now())
+	write(".hidden.txt", "https://example.com/5\n", settled)
+
+	source := &InboxSource{Path: path}
+
+	items, err := source.Items(context.Background(), &State{})
+	require.NoError(t, err)
+
+	urls := make(map[string]Item)
+	for _, item := range items {
+		urls[item.URL] = item
+	}
+	assert.Len(t, urls, 3)
+	assert.Contains(t, urls, "https://example.com/1")
+	assert.Contains(t, urls, "https://example.com/2")
+	assert.Contains(t, urls, "https://example.com/3")
+
+	require.NoError(t, source.Commit(context.Background(), []Item{urls["https://example.com/3"]}))
+
+	list := func(dir string) []string {
+		entries, err := os.ReadDir(filepath.Join(path, dir))
+		require.NoError(t, err)
+
+		names := make([]string, 0)
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				// Strip the timestamp prefix of processed files
+				name := entry.Name()
+				if dir != "." {
+					name = name[len("20060102T150405-"):]
+				}
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	assert.ElementsMatch(t, []string{"ok.txt"}, list("done"))
+	assert.ElementsMatch(t, []string{"failing.txt", "invalid.txt"}, list("failed"))
+	assert.ElementsMatch(t, []string{"recent.txt", ".hidden.txt"}, list("."))
+}
@@ -0,0 +1,198 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	urlpkg "net/url"
+)
+
+var _ Source = (*JSONSource)(nil)
+
+// defaultJSONMaxPages is the default maximum number of pages to request per
+// run.
+const defaultJSONMaxPages = 100
+
+// JSONSource is a source of the URLs in the response of a JSON HTTP endpoint,
+// such as the bookmarks API of a bookmarking service.
+type JSONSource struct {
+	URL    string
+	Client *http.Client
+	// Headers are sent with requests of pages of the same scheme and host as
+	// URL, as responses may point at other hosts which must not receive
+	// credentials.
+	Headers map[string]string
+	// Path selects the URLs of a response.
+	Path       *JSONPath
+	Pagination JSONPagination
+}
+
+// JSONPagination controls how pages are followed. If no option is set, only
+// the first page is requested.
+type JSONPagination struct {
+	// Next selects the URL of the next page in a response.
+	Next *JSONPath
+	// Cursor selects the cursor of the next page in a response. The cursor is
+	// sent as the CursorParameter query parameter.
+	Cursor          *JSONPath
+	CursorParameter string
+	// LinkHeader follows the next link of the Link header of a response, as
+	// used by the GitHub API, for example.
+	LinkHeader bool
+	// MaxPages is the maximum number of pages to request per run. Defaults to
+	// 100.
+	MaxPages int
+}
+
+// Items implements Source.
+func (j *JSONSource) Items(ctx context.Context, state *State) ([]Item, error) {
+	if j.Pagination.Cursor != nil && j.Pagination.CursorParameter == "" {
+		return nil, fmt.Errorf("cursor pagination requires a cursor parameter")
+	}
+
+	maxPages := j.Pagination.MaxPages
+	if maxPages == 0 {
+		maxPages = defaultJSONMaxPages
+	}
+
+	items := make([]Item, 0)
+	visited := make(map[string]struct{})
+
+	url := j.URL
+	for page := 0; page < maxPages && url != ""; page++ {
+		if _, ok := visited[url]; ok {
+			break
+		}
+		visited[url] = struct{}{}
+
+		document, next, err := j.fetch(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		base, err := urlpkg.Parse(url)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range j.Path.SelectStrings(document) {
+			u, err := base.Parse(strings.TrimSpace(value))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+
+			items = append(items, Item{
+				ID:  u.String(),
+				URL: u.String(),
+			})
+		}
+
+		url = ""
+		if j.Pagination.Next != nil {
+			if values := j.Pagination.Next.SelectStrings(document); len(values) > 0 && values[0] != "" {
+				u, err := base.Parse(values[0])
+				if err != nil {
+					return nil, err
+				}
+				url = u.String()
+			}
+		} else if j.Pagination.Cursor != nil {
+			if values := j.Pagination.Cursor.SelectStrings(document); len(values) > 0 && values[0] != "" {
+				u, err := urlpkg.Parse(j.URL)
+				if err != nil {
+					return nil, err
+				}
+
+				query := u.Query()
+				query.Set(j.Pagination.CursorParameter, values[0])
+				u.RawQuery = query.Encode()
+				url = u.String()
+			}
+		} else if j.Pagination.LinkHeader && next != "" {
+			u, err := base.Parse(next)
+			if err != nil {
+				return nil, err
+			}
+			url = u.String()
+		}
+	}
+
+	return items, nil
+}
+
+// fetch requests a page, returning the decoded document and the next link of
+// the Link header, if any.
+func (j *JSONSource) fetch(ctx context.Context, url string) (any, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	origin, err := urlpkg.Parse(j.URL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if req.URL.Scheme == origin.Scheme && req.URL.Host == origin.Host {
+		for k, v := range j.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+
+	client := j.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	var document any
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, "", err
+	}
+
+	return document, nextLink(res.Header.Values("Link")), nil
+}
+
+// nextLink returns the target of the next link of Link headers, if any.
+//
+// SEE: https://www.rfc-editor.org/rfc/rfc8288.
+func nextLink(headers []string) string {
+	for _, header := range headers {
+		for link := range strings.SplitSeq(header, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			if !ok {
+				continue
+			}
+
+			target = strings.TrimSpace(target)
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+
+			for param := range strings.SplitSeq(params, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(key, "rel") && strings.Trim(value, `"`) == "next" {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,141 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONSource(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /next", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Query().Get("offset") {
+		case "":
+			fmt.Fprint(w, `{"next": "/next?offset=2", "results": [{"url": "https://example.com/1"}, {"url": "https://example.com/2"}]}`)
+		case "2":
+			fmt.Fprint(w, `{"next": null, "results": [{"url": "https://example.com/3"}, {"url": "javascript:alert(1)"}]}`)
+		}
+	})
+
+	mux.HandleFunc("GET /cursor", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			fmt.Fprint(w, `{"data": {"cursor": 42, "items": [{"link": "https://example.com/1"}]}}`)
+		case "42":
+			fmt.Fprint(w, `{"data": {"items": [{"link": "https://example.com/2"}]}}`)
+		}
+	})
+
+	var server *httptest.Server
+	mux.HandleFunc("GET /link", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s/link?page=2>; rel="next", <%s/link?page=2>; rel="last"`, server.URL, server.URL))
+			fmt.Fprint(w, `[{"html_url": "https://example.com/1"}]`)
+		case "2":
+			fmt.Fprint(w, `[{"html_url": "https://example.com/2"}]`)
+		}
+	})
+
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	// Another host, which must not receive the configured headers
+	otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, `{"next": null, "results": [{"url": "https://example.com/2"}]}`)
+	}))
+	defer otherServer.Close()
+
+	mux.HandleFunc("GET /other", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprintf(w, `{"next": "%s/next", "results": [{"url": "https://example.com/1"}]}`, otherServer.URL)
+	})
+
+	testCases := []struct {
+		Name     string
+		Source   *JSONSource
+		Expected []string
+	}{
+		{
+			Name: "Next link",
+			Source: &JSONSource{
+				URL:     server.URL + "/next",
+				Headers: map[string]string{"Authorization": "Token secret"},
+				Path:    MustCompileJSONPath("results[*].url"),
+				Pagination: JSONPagination{
+					Next: MustCompileJSONPath("next"),
+				},
+			},
+			Expected: []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"},
+		},
+		{
+			Name: "Next link of another host",
+			Source: &JSONSource{
+				URL:     server.URL + "/other",
+				Headers: map[string]string{"Authorization": "Token secret"},
+				Path:    MustCompileJSONPath("results[*].url"),
+				Pagination: JSONPagination{
+					Next: MustCompileJSONPath("next"),
+				},
+			},
+			Expected: []string{"https://example.com/1", "https://example.com/2"},
+		},
+		{
+			Name: "Cursor",
+			Source: &JSONSource{
+				URL:  server.URL + "/cursor",
+				Path: MustCompileJSONPath("$.data.items[*].link"),
+				Pagination: JSONPagination{
+					Cursor:          MustCompileJSONPath("data.cursor"),
+					CursorParameter: "cursor",
+				},
+			},
+			Expected: []string{"https://example.com/1", "https://example.com/2"},
+		},
+		{
+			Name: "Link header",
+			Source: &JSONSource{
+				URL:  server.URL + "/link",
+				Path: MustCompileJSONPath("[*].html_url"),
+				Pagination: JSONPagination{
+					LinkHeader: true,
+				},
+			},
+			Expected: []string{"https://example.com/1", "https://example.com/2"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			items, err := testCase.Source.Items(context.Background(), &State{})
+			require.NoError(t, err)
+
+			urls := make([]string, 0)
+			for _, item := range items {
+				urls = append(urls, item.URL)
+			}
+
+			assert.Equal(t, testCase.Expected, urls)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package sources
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONPath is a simple path expression selecting values of a JSON document.
+//
+// An expression is a dot-separated list of object keys, where each key may be
+// followed by any number of array subscripts. A subscript is either an index
+// or * to select all elements. The expression may optionally start with $.
+// For example: $.data.items[*].url.
+type JSONPath struct {
+	expression string
+	segments   []jsonPathSegment
+}
+
+type jsonPathSegment struct {
+	// key is the object key to select, if any
+	key string
+	// index is the array index to select, if any. -1 selects all elements
+	index *int
+}
+
+// CompileJSONPath compiles a path expression.
+func CompileJSONPath(expression string) (*JSONPath, error) {
+	path := &JSONPath{
+		expression: expression,
+		segments:   make([]jsonPathSegment, 0),
+	}
+
+	rest := strings.TrimPrefix(strings.TrimPrefix(expression, "$"), ".")
+	if rest == "" {
+		return path, nil
+	}
+
+	for part := range strings.SplitSeq(rest, ".") {
+		key, subscripts, _ := strings.Cut(part, "[")
+		if key == "" && subscripts == "" {
+			return nil, fmt.Errorf("invalid path expression: %s", expression)
+		}
+
+		if key != "" {
+			path.segments = append(path.segments, jsonPathSegment{key: key})
+		}
+
+		if subscripts == "" {
+			continue
+		}
+
+		for subscript := range strings.SplitSeq("["+subscripts, "[") {
+			if subscript == "" {
+				continue
+			}
+
+			value, ok := strings.CutSuffix(subscript, "]")
+			if !ok {
+				return nil, fmt.Errorf("invalid path expression: %s", expression)
+			}
+
+			index := -1
+			if value != "*" {
+				var err error
+				index, err = strconv.Atoi(value)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid path expression: %s", expression)
+				}
+			}
+
+			path.segments = append(path.segments, jsonPathSegment{index: &index})
+		}
+	}
+
+	return path, nil
+}
+
+// MustCompileJSONPath is like [CompileJSONPath], but panics if the expression
+// is invalid.
+func MustCompileJSONPath(expression string) *JSONPath {
+	path, err := CompileJSONPath(expression)
+	if err != nil {
+		panic(err)
+	}
+
+	return path
+}
+
+// Select returns all values selected by the path. The value is expected to be
+// decoded using encoding/json into an any.
+func (p *JSONPath) Select(value any) []any {
+	values := []any{value}
+	for _, segment := range p.segments {
+		next := make([]any, 0)
+		for _, value := range values {
+			if segment.index == nil {
+				object, ok := value.(map[string]any)
+				if !ok {
+					continue
+				}
+
+				if v, ok := object[segment.key]; ok {
+					next = append(next, v)
+				}
+			} else {
+				array, ok := value.([]any)
+				if !ok {
+					continue
+				}
+
+				if *segment.index == -1 {
+					next = append(next, array...)
+				} else if *segment.index < len(array) {
+					next = append(next, array[*segment.index])
+				}
+			}
+		}
+		values = next
+	}
+
+	return values
+}
+
+// SelectStrings returns all string values selected by the path. Numbers are
+// formatted as strings, other values are ignored.
+func (p *JSONPath) SelectStrings(value any) []string {
+	values := make([]string, 0)
+	for _, value := range p.Select(value) {
+		switch value := value.(type) {
+		case string:
+			values = append(values, value)
+		case json.Number:
+			values = append(values, value.String())
+		}
+	}
+
+	return values
+}
+
+// String returns the source expression.
+func (p *JSONPath) String() string {
+	return p.expression
+}
@@ -0,0 +1,324 @@
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"html"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var _ Source = (*MailSource)(nil)
+
+var (
+	mailTextURLPattern = regexp.MustCompile(`https?://[^\s<>"'()\[\]{}]+`)
+	mailHrefPattern    = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+)
+
+// MailSource is a source of the links in the messages of an mbox file or a
+// Maildir directory. Links are extracted from text/plain and text/html parts.
+type MailSource struct {
+	// Path is the path to an mbox file or a Maildir directory.
+	Path string
+	// Include holds patterns of which at least one must match a URL for it to
+	// be included. If empty, all URLs are included.
+	Include []*regexp.Regexp
+	// Exclude holds patterns of which none may match a URL for it to be
+	// included.
+	Exclude []*regexp.Regexp
+}
+
+// Items implements Source.
+func (m *MailSource) Items(ctx context.Context, state *State) ([]Item, error) {
+	items := make([]Item, 0)
+
+	if state.Seen == nil {
+		state.Seen = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	yield := func(raw []byte) {
+		hash := sha256.Sum256(raw)
+		hashID := "sha256:" + hex.EncodeToString(hash[:])
+
+		message, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			// Don't parse the message again
+			if _, ok := state.Seen[hashID]; !ok {
+				slog.Warn("Failed to parse message", slog.Any("error", err))
+				state.Seen[hashID] = now
+			}
+			return
+		}
+
+		// Processed messages are marked as seen and need not be parsed again
+		messageID := strings.TrimSpace(message.Header.Get("Message-ID"))
+		id := messageID
+		if id == "" {
+			id = hashID
+		}
+
+		if _, ok := state.Seen[id]; ok {
+			return
+		}
+
+		urls, err := extractMailURLs(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+		if err != nil {
+			slog.Warn("Failed to read message", slog.String("messageId", id), slog.Any("error", err))
+		}
+
+		annotations := make(map[string]string)
+		if messageID != "" {
+			annotations["larch.mail.messageId"] = messageID
+		}
+		if subject := message.Header.Get("Subject"); subject != "" {
+			decoded, err := new(mime.WordDecoder).DecodeHeader(subject)
+			if err != nil {
+				decoded = subject
+			}
+			annotations["larch.mail.subject"] = decoded
+		}
+		if from := message.Header.Get("From"); from != "" {
+			annotations["larch.mail.from"] = from
+		}
+		if date, err := message.Header.Date(); err == nil {
+			annotations["larch.mail.date"] = date.Format(time.RFC3339)
+		}
+
+		// Items are identified by their message and URL, so that links of a
+		// message are retried individually. The message is processed once all
+		// of its links have been seen
+		messageItems := make([]Item, 0)
+		pending := false
+		for _, url := range urls {
+			if !m.matches(url) {
+				continue
+			}
+
+			itemID := id + "#" + url
+			if slices.ContainsFunc(messageItems, func(item Item) bool { return item.ID == itemID }) {
+				continue
+			}
+
+			if _, ok := state.Seen[itemID]; !ok {
+				pending = true
+			}
+
+			messageItems = append(messageItems, Item{
+				ID:          itemID,
+				URL:         url,
+				Annotations: annotations,
+			})
+		}
+
+		if !pending {
+			state.Seen[id] = now
+			return
+		}
+
+		items = append(items, messageItems...)
+	}
+
+	info, err := os.Stat(m.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		err = readMaildir(ctx, m.Path, yield)
+	} else {
+		err = readMbox(ctx, m.Path, yield)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (m *MailSource) matches(url string) bool {
+	for _, pattern := range m.Exclude {
+		if pattern.MatchString(url) {
+			return false
+		}
+	}
+
+	if len(m.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range m.Include {
+		if pattern.MatchString(url) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readMaildir reads all messages of a Maildir directory, both new and current.
+//
+// SEE: https://cr.yp.to/proto/maildir.html.
+func readMaildir(ctx context.Context, path string, yield func([]byte)) error {
+	for _, dir := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(path, dir))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+
+			raw, err := os.ReadFile(filepath.Join(path, dir, entry.Name()))
+			if err != nil {
+				return err
+			}
+
+			yield(raw)
+		}
+	}
+
+	return nil
+}
+
+// readMbox reads all messages of an mbox file. Quoted From lines are
+// unquoted, as in the mboxrd format.
+func readMbox(ctx context.Context, path string, yield func([]byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	var message bytes.Buffer
+	inMessage := false
+	previousBlank := true
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if previousBlank && bytes.HasPrefix(line, []byte("From ")) {
+				if inMessage {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					yield(message.Bytes())
+				}
+				message = bytes.Buffer{}
+				inMessage = true
+			} else if inMessage {
+				unquoted := bytes.TrimLeft(line, ">")
+				if len(unquoted) < len(line) && bytes.HasPrefix(unquoted, []byte("From ")) {
+					line = line[1:]
+				}
+				message.Write(line)
+			}
+
+			previousBlank = len(bytes.TrimRight(line, "\r\n")) == 0
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if inMessage {
+		yield(message.Bytes())
+	}
+
+	return nil
+}
+
+// extractMailURLs extracts URLs from the text/plain and text/html parts of a
+// message body.
+func extractMailURLs(contentType string, transferEncoding string, body io.Reader) ([]string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// Messages without a content type are plain text
+		mediaType = "text/plain"
+	}
+
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		urls := make([]string, 0)
+
+		var errs []error
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				errs = append(errs, err)
+				break
+			}
+
+			partURLs, err := extractMailURLs(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			urls = append(urls, partURLs...)
+			errs = append(errs, err)
+		}
+
+		return urls, errors.Join(errs...)
+	case mediaType == "text/plain":
+		content, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+
+		urls := make([]string, 0)
+		for _, url := range mailTextURLPattern.FindAllString(string(content), -1) {
+			// Don't include punctuation ending a sentence
+			urls = append(urls, strings.TrimRight(url, ".,;:!?"))
+		}
+
+		return urls, nil
+	case mediaType == "text/html":
+		content, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+
+		urls := make([]string, 0)
+		for _, match := range mailHrefPattern.FindAllStringSubmatch(string(content), -1) {
+			url := strings.TrimSpace(html.UnescapeString(match[1]))
+			if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+				urls = append(urls, url)
+			}
+		}
+
+		return urls, nil
+	default:
+		return nil, nil
+	}
+}
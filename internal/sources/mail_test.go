@@ -0,0 +1,131 @@
+package sources
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractMailURLs(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		ContentType      string
+		TransferEncoding string
+		Body             string
+		Expected         []string
+	}{
+		{
+			Name:     "Plain text",
+			Body:     "See https://example.com/a, (or https://example.com/b).",
+			Expected: []string{"https://example.com/a", "https://example.com/b"},
+		},
+		{
+			Name:             "Quoted-printable HTML",
+			ContentType:      "text/html; charset=utf-8",
+			TransferEncoding: "quoted-printable",
+			Body:             "<a href=3D\"https://example.com/?a=3D1&amp;b=3D2\">link</a><a href=3D'javascript:alert(1)'>script</a>",
+			Expected:         []string{"https://example.com/?a=1&b=2"},
+		},
+		{
+			Name:             "Base64",
+			ContentType:      "text/plain",
+			TransferEncoding: "base64",
+			Body:             "aHR0cHM6Ly9leGFtcGxlLmNvbS8=",
+			Expected:         []string{"https://example.com/"},
+		},
+		{
+			Name:        "Multipart",
+			ContentType: `multipart/mixed; boundary="a"`,
+			Body:        "--a\r\nContent-Type: text/plain\r\n\r\nhttps://example.com/\r\n--a\r\nContent-Type: image/png\r\n\r\nhttps://example.org/\r\n--a--\r\n",
+			Expected:    []string{"https://example.com/"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			urls, err := extractMailURLs(testCase.ContentType, testCase.TransferEncoding, strings.NewReader(testCase.Body))
+			require.NoError(t, err)
+			assert.Equal(t, testCase.Expected, urls)
+		})
+	}
+}
+
+func TestMailSourceMbox(t *testing.T) {
+	source := &MailSource{
+		Path:    "testdata/mail.mbox",
+		Exclude: []*regexp.Regexp{regexp.MustCompile(`/second$`)},
+	}
+
+	state := &State{}
+	items, err := source.Items(context.Background(), state)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Item{
+		{
+			ID:  "<1@example.com>#https://example.com/first",
+			URL: "https://example.com/first",
+			Annotations: map[string]string{
+				"larch.mail.messageId": "<1@example.com>",
+				"larch.mail.subject":   "Links för you",
+				"larch.mail.from":      "Alice <alice@example.com>",
+				"larch.mail.date":      "2025-06-02T10:00:00Z",
+			},
+		},
+		{
+			ID:  "<2@example.com>#https://example.org/newsletter?id=1&lang=en",
+			URL: "https://example.org/newsletter?id=1&lang=en",
+			Annotations: map[string]string{
+				"larch.mail.messageId": "<2@example.com>",
+				"larch.mail.subject":   "Newsletter",
+				"larch.mail.from":      "Bob <bob@example.com>",
+			},
+		},
+	}, items)
+
+	// Messages without links are processed right away
+	assert.Contains(t, state.Seen, "<3@example.com>")
+
+	// Messages with links are processed once all links have been seen
+	state.Seen[items[0].ID] = state.LastRun
+	items, err = source.Items(context.Background(), state)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "https://example.org/newsletter?id=1&lang=en", items[0].URL)
+	assert.Contains(t, state.Seen, "<1@example.com>")
+	assert.NotContains(t, state.Seen, "<2@example.com>")
+}
+
+func TestMailSourceMaildir(t *testing.T) {
+	path := t.TempDir()
+	for _, dir := range []string{"new", "cur", "tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(path, dir), 0755))
+	}
+
+	write := func(name string, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(path, name), []byte(content), 0644))
+	}
+
+	write("new/1", "Subject: New\r\n\r\nhttps://example.com/new\r\n")
+	write("cur/2:2,S", "Subject: Current\r\n\r\nhttps://example.com/current\r\n")
+	write("tmp/3", "Subject: Incomplete\r\n\r\nhttps://example.com/tmp\r\n")
+	write("cur/.hidden", "Subject: Hidden\r\n\r\nhttps://example.com/hidden\r\n")
+
+	source := &MailSource{Path: path}
+
+	items, err := source.Items(context.Background(), &State{})
+	require.NoError(t, err)
+
+	urls := make([]string, 0)
+	for _, item := range items {
+		// Messages without an id are identified by their content
+		assert.True(t, strings.HasPrefix(item.ID, "sha256:"))
+		urls = append(urls, item.URL)
+	}
+	assert.Equal(t, []string{"https://example.com/new", "https://example.com/current"}, urls)
+}
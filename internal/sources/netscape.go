@@ -0,0 +1,198 @@
+package sources
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ Source = (*NetscapeBookmarksSource)(nil)
+
+// NetscapeBookmarksSource is a source of the bookmarks of a file in the
+// Netscape bookmark file format, as exported by most browsers.
+type NetscapeBookmarksSource struct {
+	Path string
+}
+
+// Items implements Source.
+func (n *NetscapeBookmarksSource) Items(ctx context.Context, state *State) ([]Item, error) {
+	file, err := os.Open(n.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bookmarks, err := ParseNetscapeBookmarks(file)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0)
+	for _, bookmark := range bookmarks {
+		annotations := make(map[string]string)
+
+		if bookmark.Title != "" {
+			annotations["larch.bookmark.title"] = bookmark.Title
+		}
+
+		if len(bookmark.Folders) > 0 {
+			annotations["larch.bookmark.folder"] = strings.Join(bookmark.Folders, "/")
+		}
+
+		if !bookmark.Added.IsZero() {
+			annotations["larch.bookmark.added"] = bookmark.Added.Format(time.RFC3339)
+		}
+
+		if len(bookmark.Tags) > 0 {
+			annotations["larch.bookmark.tags"] = strings.Join(bookmark.Tags, ",")
+		}
+
+		items = append(items, Item{
+			ID:          bookmark.URL,
+			URL:         bookmark.URL,
+			Annotations: annotations,
+		})
+	}
+
+	return items, nil
+}
+
+type NetscapeBookmark struct {
+	URL   string
+	Title string
+	// Folders is the path of folders the bookmark is in, from the root.
+	Folders []string
+	Added   time.Time
+	Tags    []string
+}
+
+// ParseNetscapeBookmarks parses a bookmark file in the Netscape bookmark file
+// format.
+//
+// SEE: https://learn.microsoft.com/en-us/previous-versions/windows/internet-explorer/ie-developer/platform-apis/aa753582(v=vs.85).
+func ParseNetscapeBookmarks(r io.Reader) ([]NetscapeBookmark, error) {
+	// The format is HTML that is not even valid HTML, use the lenient parser
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = append([]string{"dt", "dd", "p"}, xml.HTMLAutoClose...)
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = charsetReader
+
+	bookmarks := make([]NetscapeBookmark, 0)
+
+	// The folders of the <DL> elements currently open
+	folders := make([]string, 0)
+	// The name of the last folder (<H3>), applied to the next <DL>
+	folder := ""
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid bookmark file: %w", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(token.Name.Local) {
+			case "h3":
+				text, err := readText(decoder)
+				if err != nil {
+					return nil, fmt.Errorf("invalid bookmark file: %w", err)
+				}
+				folder = text
+			case "dl":
+				folders = append(folders, folder)
+				folder = ""
+			case "a":
+				bookmark := NetscapeBookmark{}
+				for _, attr := range token.Attr {
+					switch strings.ToLower(attr.Name.Local) {
+					case "href":
+						bookmark.URL = strings.TrimSpace(attr.Value)
+					case "add_date":
+						bookmark.Added = parseNetscapeDate(attr.Value)
+					case "tags":
+						for tag := range strings.SplitSeq(attr.Value, ",") {
+							tag = strings.TrimSpace(tag)
+							if tag != "" {
+								bookmark.Tags = append(bookmark.Tags, tag)
+							}
+						}
+					}
+				}
+
+				text, err := readText(decoder)
+				if err != nil {
+					return nil, fmt.Errorf("invalid bookmark file: %w", err)
+				}
+				bookmark.Title = text
+
+				for _, folder := range folders {
+					if folder != "" {
+						bookmark.Folders = append(bookmark.Folders, folder)
+					}
+				}
+
+				// Skip bookmarklets, separators and the like
+				if strings.HasPrefix(bookmark.URL, "http://") || strings.HasPrefix(bookmark.URL, "https://") {
+					bookmarks = append(bookmarks, bookmark)
+				}
+			}
+		case xml.EndElement:
+			if strings.ToLower(token.Name.Local) == "dl" && len(folders) > 0 {
+				folders = folders[:len(folders)-1]
+			}
+		}
+	}
+
+	return bookmarks, nil
+}
+
+// readText reads the text content of the current element, up until its end.
+func readText(decoder *xml.Decoder) (string, error) {
+	var builder strings.Builder
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+
+		switch token := token.(type) {
+		case xml.CharData:
+			builder.Write(token)
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return strings.TrimSpace(builder.String()), nil
+			}
+			depth--
+		}
+	}
+}
+
+// parseNetscapeDate parses a unix timestamp. Some exporters use milli- or
+// microseconds rather than seconds.
+func parseNetscapeDate(value string) time.Time {
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || timestamp <= 0 {
+		return time.Time{}
+	}
+
+	switch {
+	case timestamp > 1e14:
+		return time.UnixMicro(timestamp)
+	case timestamp > 1e11:
+		return time.UnixMilli(timestamp)
+	default:
+		return time.Unix(timestamp, 0)
+	}
+}
@@ -0,0 +1,42 @@
+package sources
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetscapeBookmarks(t *testing.T) {
+	file, err := os.Open("testdata/bookmarks.html")
+	require.NoError(t, err)
+	defer file.Close()
+
+	bookmarks, err := ParseNetscapeBookmarks(file)
+	require.NoError(t, err)
+
+	expected := []NetscapeBookmark{
+		{
+			URL:     "https://go.dev/",
+			Title:   "The Go Programming Language",
+			Folders: []string{"Bookmarks bar"},
+			Added:   time.Unix(1700000100, 0),
+		},
+		{
+			URL:     "https://example.com/article?a=1&b=2",
+			Title:   "An article",
+			Folders: []string{"Bookmarks bar", "Reading & writing"},
+			Added:   time.Unix(1700000200, 0),
+			Tags:    []string{"go", "tools"},
+		},
+		{
+			URL:   "https://example.org/",
+			Title: "Unsorted",
+			Added: time.Unix(1700000300, 0),
+		},
+	}
+
+	assert.Equal(t, expected, bookmarks)
+}
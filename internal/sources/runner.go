@@ -0,0 +1,177 @@
+package sources
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ScheduleFunc schedules a snapshot of an item using the named strategy.
+type ScheduleFunc func(ctx context.Context, item Item, strategy string) error
+
+// RunnerSource is a source registered with a [Runner].
+type RunnerSource struct {
+	// ID uniquely identifies the source. It is used as the key for the source's
+	// persisted state.
+	ID       string
+	Source   Source
+	Strategy string
+	// Interval is the time between runs of the source. If zero, the source is
+	// only run once on start.
+	Interval time.Duration
+	// Recurring marks the source's items as due for a new snapshot every
+	// interval. Items of other sources are only scheduled the first time they
+	// are seen.
+	Recurring bool
+	// Untracked disables keeping track of the source's items, scheduling all
+	// items returned by the source. Useful for sources which consume their
+	// items.
+	Untracked bool
+}
+
+// Runner periodically runs sources and schedules their new or due items.
+type Runner struct {
+	store    *StateStore
+	schedule ScheduleFunc
+	sources  []RunnerSource
+}
+
+func NewRunner(store *StateStore, schedule ScheduleFunc) *Runner {
+	return &Runner{
+		store:    store,
+		schedule: schedule,
+		sources:  make([]RunnerSource, 0),
+	}
+}
+
+// Register registers a source. Sources must be registered before the runner
+// is started.
+func (r *Runner) Register(source RunnerSource) {
+	r.sources = append(r.sources, source)
+}
+
+// Run runs all registered sources until the context is cancelled.
+func (r *Runner) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, source := range r.sources {
+		wg.Go(func() {
+			r.poll(ctx, source)
+		})
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (r *Runner) poll(ctx context.Context, source RunnerSource) {
+	// Pick up where we left off before a restart
+	state := r.store.Get(source.ID)
+	if !state.LastRun.IsZero() && source.Interval > 0 {
+		wait := time.Until(state.LastRun.Add(source.Interval))
+		if wait > 0 {
+			slog.Debug("Waiting for source to be due", slog.String("source", source.ID), slog.Duration("wait", wait))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+
+	for {
+		if err := r.RunSource(ctx, source); err != nil {
+			slog.Warn("Failed to run source", slog.String("source", source.ID), slog.Any("error", err))
+		}
+
+		if source.Interval == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(source.Interval):
+		}
+	}
+}
+
+// RunSource runs a source once, scheduling all of its new or due items.
+func (r *Runner) RunSource(ctx context.Context, source RunnerSource) error {
+	state := r.store.Get(source.ID)
+	etag, lastModified := state.ETag, state.LastModified
+
+	items, err := source.Source.Items(ctx, &state)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	state.LastRun = now
+
+	if state.Seen == nil {
+		state.Seen = make(map[string]time.Time)
+	}
+
+	failed := make([]Item, 0)
+	for _, item := range items {
+		if item.ID == "" {
+			item.ID = item.URL
+		}
+
+		if !r.isDue(source, state, item, now) {
+			continue
+		}
+
+		slog.Debug("Scheduling item", slog.String("source", source.ID), slog.String("url", item.URL))
+		if err := r.schedule(ctx, item, source.Strategy); err != nil {
+			// Leave the item unseen so that it's retried next run
+			slog.Warn("Failed to schedule item", slog.String("source", source.ID), slog.String("url", item.URL), slog.Any("error", err))
+			failed = append(failed, item)
+			continue
+		}
+
+		if !source.Untracked {
+			state.Seen[item.ID] = now
+		}
+	}
+
+	// Keep the previous caching headers if any item failed, or the source would
+	// not return the failed items again until it's modified
+	if len(failed) > 0 {
+		state.ETag = etag
+		state.LastModified = lastModified
+	} else {
+		state.LastCompleteRun = now
+	}
+
+	if committer, ok := source.Source.(Committer); ok {
+		if err := committer.Commit(ctx, failed); err != nil {
+			slog.Warn("Failed to commit source run", slog.String("source", source.ID), slog.Any("error", err))
+		}
+	}
+
+	return r.store.Set(source.ID, state)
+}
+
+func (r *Runner) isDue(source RunnerSource, state State, item Item, now time.Time) bool {
+	if source.Untracked {
+		return true
+	}
+
+	lastScheduled, ok := state.Seen[item.ID]
+	if !ok {
+		return true
+	}
+
+	if !item.Modified.IsZero() && item.Modified.After(lastScheduled) {
+		return true
+	}
+
+	if source.Recurring && source.Interval > 0 {
+		// Allow for some drift between runs
+		return now.Sub(lastScheduled) >= source.Interval-time.Minute
+	}
+
+	return false
+}
@@ -0,0 +1,184 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSource struct {
+	items []Item
+	etag  string
+	// notModified makes the source return no items if the state's etag
+	// matches, like a conditional request
+	notModified bool
+}
+
+// Items implements Source.
+func (s *testSource) Items(ctx context.Context, state *State) ([]Item, error) {
+	if s.notModified && state.ETag == s.etag {
+		return nil, nil
+	}
+
+	state.ETag = s.etag
+	return s.items, nil
+}
+
+func TestRunnerRunSource(t *testing.T) {
+	store, err := NewStateStore(t.TempDir())
+	require.NoError(t, err)
+
+	var scheduled []string
+	runner := NewRunner(store, func(ctx context.Context, item Item, strategy string) error {
+		assert.Equal(t, "default", strategy)
+		scheduled = append(scheduled, item.URL)
+		return nil
+	})
+
+	source := &testSource{
+		items: []Item{
+			{URL: "https://example.com/a"},
+			{URL: "https://example.com/b"},
+		},
+		etag: `"1"`,
+	}
+
+	runnerSource := RunnerSource{
+		ID:       "test",
+		Source:   source,
+		Strategy: "default",
+	}
+
+	// New items are scheduled
+	require.NoError(t, runner.RunSource(context.Background(), runnerSource))
+	assert.Equal(t, []string{"https://example.com/a", "https://example.com/b"}, scheduled)
+
+	state := store.Get("test")
+	assert.False(t, state.LastRun.IsZero())
+	assert.Equal(t, `"1"`, state.ETag)
+	assert.Len(t, state.Seen, 2)
+
+	// Seen items are not scheduled again
+	scheduled = nil
+	require.NoError(t, runner.RunSource(context.Background(), runnerSource))
+	assert.Empty(t, scheduled)
+
+	// Modified items are scheduled again
+	source.items[0].Modified = time.Now().Add(time.Minute)
+	require.NoError(t, runner.RunSource(context.Background(), runnerSource))
+	assert.Equal(t, []string{"https://example.com/a"}, scheduled)
+}
+
+func TestRunnerRunSourceRetriesFailedItems(t *testing.T) {
+	store, err := NewStateStore(t.TempDir())
+	require.NoError(t, err)
+
+	var scheduled []string
+	fail := true
+	runner := NewRunner(store, func(ctx context.Context, item Item, strategy string) error {
+		if fail && item.URL == "https://example.com/b" {
+			return fmt.Errorf("failed")
+		}
+
+		scheduled = append(scheduled, item.URL)
+		return nil
+	})
+
+	source := &testSource{
+		items: []Item{
+			{URL: "https://example.com/a"},
+			{URL: "https://example.com/b"},
+		},
+		etag:        `"1"`,
+		notModified: true,
+	}
+
+	runnerSource := RunnerSource{
+		ID:     "test",
+		Source: source,
+	}
+
+	require.NoError(t, runner.RunSource(context.Background(), runnerSource))
+	assert.Equal(t, []string{"https://example.com/a"}, scheduled)
+
+	// The caching headers are not persisted, so that the source returns the
+	// failed item again
+	state := store.Get("test")
+	assert.Empty(t, state.ETag)
+	assert.True(t, state.LastCompleteRun.IsZero())
+	assert.Contains(t, state.Seen, "https://example.com/a")
+	assert.NotContains(t, state.Seen, "https://example.com/b")
+
+	scheduled = nil
+	fail = false
+	require.NoError(t, runner.RunSource(context.Background(), runnerSource))
+	assert.Equal(t, []string{"https://example.com/b"}, scheduled)
+
+	state = store.Get("test")
+	assert.Equal(t, `"1"`, state.ETag)
+	assert.False(t, state.LastCompleteRun.IsZero())
+	assert.Contains(t, state.Seen, "https://example.com/b")
+}
+
+func TestRunnerIsDue(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		Name     string
+		Source   RunnerSource
+		Seen     map[string]time.Time
+		Item     Item
+		Expected bool
+	}{
+		{
+			Name:     "New item",
+			Item:     Item{ID: "a"},
+			Expected: true,
+		},
+		{
+			Name:     "Seen item",
+			Seen:     map[string]time.Time{"a": now.Add(-time.Hour)},
+			Item:     Item{ID: "a"},
+			Expected: false,
+		},
+		{
+			Name:     "Modified item",
+			Seen:     map[string]time.Time{"a": now.Add(-time.Hour)},
+			Item:     Item{ID: "a", Modified: now.Add(-time.Minute)},
+			Expected: true,
+		},
+		{
+			Name:     "Untracked source",
+			Source:   RunnerSource{Untracked: true},
+			Seen:     map[string]time.Time{"a": now},
+			Item:     Item{ID: "a"},
+			Expected: true,
+		},
+		{
+			Name:     "Recurring source, due",
+			Source:   RunnerSource{Recurring: true, Interval: time.Hour},
+			Seen:     map[string]time.Time{"a": now.Add(-time.Hour)},
+			Item:     Item{ID: "a"},
+			Expected: true,
+		},
+		{
+			Name:     "Recurring source, not due",
+			Source:   RunnerSource{Recurring: true, Interval: time.Hour},
+			Seen:     map[string]time.Time{"a": now.Add(-30 * time.Minute)},
+			Item:     Item{ID: "a"},
+			Expected: false,
+		},
+	}
+
+	runner := &Runner{}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			actual := runner.isDue(testCase.Source, State{Seen: testCase.Seen}, testCase.Item, now)
+			assert.Equal(t, testCase.Expected, actual)
+		})
+	}
+}
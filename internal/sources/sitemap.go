@@ -0,0 +1,183 @@
+package sources
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var _ Source = (*SitemapSource)(nil)
+
+const (
+	// maxSitemapDepth is the maximum depth of nested sitemap indexes to follow.
+	maxSitemapDepth = 3
+	// maxSitemapSize is the maximum (uncompressed) size of a sitemap, as
+	// specified by the protocol.
+	maxSitemapSize = 50 * 1024 * 1024
+)
+
+// SitemapSource is a source of the pages of a sitemap, recursively following
+// sitemap indexes.
+//
+// SEE: https://www.sitemaps.org/protocol.html.
+type SitemapSource struct {
+	URL    string
+	Client *http.Client
+}
+
+// Items implements Source.
+func (s *SitemapSource) Items(ctx context.Context, state *State) ([]Item, error) {
+	items := make([]Item, 0)
+	visited := make(map[string]struct{})
+
+	err := s.walk(ctx, s.URL, state.LastCompleteRun, 0, visited, func(entry SitemapEntry) {
+		items = append(items, Item{
+			ID:       entry.URL,
+			URL:      entry.URL,
+			Modified: entry.Modified,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (s *SitemapSource) walk(ctx context.Context, url string, lastRun time.Time, depth int, visited map[string]struct{}, yield func(SitemapEntry)) error {
+	if _, ok := visited[url]; ok {
+		return nil
+	}
+	visited[url] = struct{}{}
+
+	sitemap, err := s.fetch(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range sitemap.URLs {
+		yield(entry)
+	}
+
+	for _, entry := range sitemap.Sitemaps {
+		if depth+1 >= maxSitemapDepth {
+			slog.Warn("Skipping sitemap nested too deep", slog.String("url", entry.URL))
+			continue
+		}
+
+		// No need to fetch sitemaps which have not changed since the last run
+		// that scheduled all of their pages
+		if !entry.Modified.IsZero() && !lastRun.IsZero() && entry.Modified.Before(lastRun) {
+			continue
+		}
+
+		if err := s.walk(ctx, entry.URL, lastRun, depth+1, visited, yield); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SitemapSource) fetch(ctx context.Context, url string) (*Sitemap, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	return ParseSitemap(res.Body)
+}
+
+// Sitemap is either a set of URLs or an index of other sitemaps.
+type Sitemap struct {
+	URLs     []SitemapEntry
+	Sitemaps []SitemapEntry
+}
+
+type SitemapEntry struct {
+	URL string
+	// Modified is the time the entry was last modified, if known.
+	Modified time.Time
+}
+
+type sitemapDocument struct {
+	URLs []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"sitemap"`
+}
+
+// ParseSitemap parses a sitemap or a sitemap index. The document may be
+// gzip-compressed.
+func ParseSitemap(r io.Reader) (*Sitemap, error) {
+	reader := bufio.NewReader(r)
+
+	// Servers rarely specify a content encoding for .xml.gz files, so sniff it
+	magic, _ := reader.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sitemap: %w", err)
+		}
+		defer gzipReader.Close()
+
+		r = gzipReader
+	} else {
+		r = reader
+	}
+
+	decoder := xml.NewDecoder(io.LimitReader(r, maxSitemapSize))
+	decoder.CharsetReader = charsetReader
+
+	var document sitemapDocument
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("invalid sitemap: %w", err)
+	}
+
+	sitemap := &Sitemap{
+		URLs:     make([]SitemapEntry, 0, len(document.URLs)),
+		Sitemaps: make([]SitemapEntry, 0, len(document.Sitemaps)),
+	}
+
+	for _, url := range document.URLs {
+		sitemap.URLs = append(sitemap.URLs, SitemapEntry{
+			URL:      strings.TrimSpace(url.Loc),
+			Modified: parseDate(url.LastMod),
+		})
+	}
+
+	for _, url := range document.Sitemaps {
+		sitemap.Sitemaps = append(sitemap.Sitemaps, SitemapEntry{
+			URL:      strings.TrimSpace(url.Loc),
+			Modified: parseDate(url.LastMod),
+		})
+	}
+
+	return sitemap, nil
+}
@@ -0,0 +1,126 @@
+package sources
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSitemap(t *testing.T) {
+	file, err := os.Open("testdata/sitemap-pages.xml")
+	require.NoError(t, err)
+	defer file.Close()
+
+	sitemap, err := ParseSitemap(file)
+	require.NoError(t, err)
+
+	assert.Equal(t, &Sitemap{
+		URLs: []SitemapEntry{
+			{URL: "https://example.com/", Modified: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)},
+			{URL: "https://example.com/about/"},
+		},
+		Sitemaps: []SitemapEntry{},
+	}, sitemap)
+}
+
+func TestParseSitemapGzip(t *testing.T) {
+	content, err := os.ReadFile("testdata/sitemap-posts.xml")
+	require.NoError(t, err)
+
+	sitemap, err := ParseSitemap(bytes.NewReader(gzipContent(t, content)))
+	require.NoError(t, err)
+
+	require.Len(t, sitemap.URLs, 1)
+	assert.Equal(t, "https://example.com/posts/first/", sitemap.URLs[0].URL)
+}
+
+func TestSitemapSource(t *testing.T) {
+	var server *httptest.Server
+	requests := make([]string, 0)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+
+		name, compressed := strings.CutSuffix(r.URL.Path, ".gz")
+		content, err := os.ReadFile("testdata" + name)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Sitemap indexes link to absolute URLs
+		content = bytes.ReplaceAll(content, []byte("{{server}}"), []byte(server.URL))
+		if compressed {
+			content = gzipContent(t, content)
+		}
+
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	source := &SitemapSource{
+		URL:    server.URL + "/sitemap.xml",
+		Client: server.Client(),
+	}
+
+	testCases := []struct {
+		Name             string
+		LastCompleteRun  time.Time
+		ExpectedRequests []string
+		ExpectedURLs     []string
+	}{
+		{
+			Name: "First run",
+			// The index links to itself, but is only fetched once
+			ExpectedRequests: []string{"/sitemap.xml", "/sitemap-pages.xml", "/sitemap-posts.xml.gz"},
+			ExpectedURLs: []string{
+				"https://example.com/",
+				"https://example.com/about/",
+				"https://example.com/posts/first/",
+			},
+		},
+		{
+			Name:             "Skips unmodified sitemaps",
+			LastCompleteRun:  time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC),
+			ExpectedRequests: []string{"/sitemap.xml", "/sitemap-posts.xml.gz"},
+			ExpectedURLs: []string{
+				"https://example.com/posts/first/",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			requests = requests[:0]
+
+			items, err := source.Items(context.Background(), &State{LastCompleteRun: testCase.LastCompleteRun})
+			require.NoError(t, err)
+
+			urls := make([]string, 0)
+			for _, item := range items {
+				assert.Equal(t, item.URL, item.ID)
+				urls = append(urls, item.URL)
+			}
+
+			assert.Equal(t, testCase.ExpectedRequests, requests)
+			assert.Equal(t, testCase.ExpectedURLs, urls)
+		})
+	}
+}
+
+func gzipContent(t *testing.T, content []byte) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
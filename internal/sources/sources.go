@@ -1,15 +1,45 @@
 package sources
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type Source interface {
-	URLs(context.Context) ([]string, error)
+	// Items returns the source's current items. The state is the one persisted
+	// after the source's previous run and may be updated by the source, for
+	// example to keep track of caching headers.
+	Items(context.Context, *State) ([]Item, error)
 }
 
+// Committer is implemented by sources which need to know the outcome of a
+// run, such as to clean up after their items.
+type Committer interface {
+	// Commit is called after a run with the items which failed to be
+	// scheduled.
+	Commit(context.Context, []Item) error
+}
+
+// Item is a single entry of a source.
+type Item struct {
+	// ID uniquely identifies the item within the source. Defaults to the URL.
+	ID  string
+	URL string
+	// Modified is the time the item was last modified, if known. Items which
+	// have been modified since they were last scheduled are scheduled again.
+	Modified time.Time
+	// Annotations holds additional annotations to record for the item's
+	// snapshot.
+	Annotations map[string]string
+}
+
+var _ Source = (*URLSource)(nil)
+
 type URLSource struct {
 	URL string
 }
 
-func (u *URLSource) URLs(ctx context.Context) ([]string, error) {
-	return []string{u.URL}, nil
+// Items implements Source.
+func (u *URLSource) Items(ctx context.Context, state *State) ([]Item, error) {
+	return []Item{{ID: u.URL, URL: u.URL}}, nil
 }
@@ -0,0 +1,104 @@
+package sources
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State is the state of a source, persisted between runs.
+type State struct {
+	// LastRun is the time the source was last run.
+	LastRun time.Time `json:"lastRun,omitzero"`
+	// LastCompleteRun is the time the source was last run without failing to
+	// schedule any item. Sources may skip content unmodified since then.
+	LastCompleteRun time.Time `json:"lastCompleteRun,omitzero"`
+	// ETag is the entity tag of the source's last response, if any.
+	ETag string `json:"etag,omitempty"`
+	// LastModified is the Last-Modified header of the source's last response,
+	// if any.
+	LastModified string `json:"lastModified,omitempty"`
+	// Seen holds the time each item, by id, was last scheduled.
+	// TODO: Prune items that have not been returned by the source in a long
+	// time?
+	Seen map[string]time.Time `json:"seen,omitempty"`
+}
+
+// StateStore persists the state of sources in a single JSON file.
+type StateStore struct {
+	mutex  sync.Mutex
+	path   string
+	states map[string]State
+}
+
+// NewStateStore opens the state store at the given directory, creating it if
+// necessary.
+func NewStateStore(basePath string) (*StateStore, error) {
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(basePath, "sources.json")
+
+	states := make(map[string]State)
+	file, err := os.Open(path)
+	if err == nil {
+		err = json.NewDecoder(file).Decode(&states)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return &StateStore{
+		path:   path,
+		states: states,
+	}, nil
+}
+
+// Get returns the state of a source. The returned state is a copy and can be
+// modified freely.
+func (s *StateStore) Get(id string) State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state := s.states[id]
+	state.Seen = maps.Clone(state.Seen)
+	return state
+}
+
+// Set stores the state of a source and persists all states to disk.
+func (s *StateStore) Set(id string, state State) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.states[id] = state
+
+	// Write to a temporary file first in order to never leave a partially
+	// written state behind
+	file, err := os.CreateTemp(filepath.Dir(s.path), ".sources-*.json")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s.states); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), s.path)
+}
@@ -0,0 +1,55 @@
+package sources
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateStore(t *testing.T) {
+	basePath := filepath.Join(t.TempDir(), "state")
+
+	store, err := NewStateStore(basePath)
+	require.NoError(t, err)
+
+	// Unknown sources have an empty state
+	assert.Equal(t, State{}, store.Get("feed"))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	expected := State{
+		LastRun: now,
+		ETag:    `"1"`,
+		Seen: map[string]time.Time{
+			"https://example.com": now,
+		},
+	}
+	require.NoError(t, store.Set("feed", expected))
+
+	// Returned states are copies
+	state := store.Get("feed")
+	state.Seen["https://example.org"] = now
+	assert.Equal(t, expected, store.Get("feed"))
+
+	// States are persisted
+	store, err = NewStateStore(basePath)
+	require.NoError(t, err)
+	assert.Equal(t, expected, store.Get("feed"))
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(basePath)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "sources.json", entries[0].Name())
+}
+
+func TestStateStoreInvalid(t *testing.T) {
+	basePath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(basePath, "sources.json"), []byte("{"), 0644))
+
+	_, err := NewStateStore(basePath)
+	assert.Error(t, err)
+}
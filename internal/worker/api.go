@@ -2,9 +2,11 @@ package worker
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
 
 	"github.com/AlexGustafsson/larch/internal/libraries"
@@ -62,9 +64,9 @@ func NewAPI(scheduler *Scheduler, libraryWriters map[string]libraries.LibraryWri
 		// TODO: Auth
 
 		name := r.Header.Get("X-Larch-Name")
-		// TODO: Check if file already exists, no need to send it or write it to
-		// disk then
-		// digest := r.Header.Get("X-Larch-Digest")
+		// The digest is optional, but verified if supplied. Workers may check
+		// whether blobs already exist before sending them, see below
+		expectedDigest := r.Header.Get("X-Larch-Digest")
 
 		library, ok := libraryWriters[libraryID]
 		if !ok {
@@ -72,7 +74,6 @@ func NewAPI(scheduler *Scheduler, libraryWriters map[string]libraries.LibraryWri
 			return
 		}
 
-		// TODO: Support multiple libraries
 		// TODO: Return conflict if already open?
 		snapshotWriter, err := library.WriteSnapshot(r.Context(), origin, snapshotID)
 		if err != nil {
@@ -80,21 +81,62 @@ func NewAPI(scheduler *Scheduler, libraryWriters map[string]libraries.LibraryWri
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
+		defer snapshotWriter.Close()
 
-		writer, err := snapshotWriter.NextArtifactWriter(r.Context(), name)
+		artifactWriter, err := snapshotWriter.NextArtifactWriter(r.Context(), name)
 		if err != nil {
 			slog.Error("Failed to get artifact writer", slog.Any("error", err))
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
+		// Tee the artifact to the snapshot's replicas
+		writer := newTeeWriter(artifactWriter)
+		for replica, replicaWriter := range scheduler.replicaWriters(r.Context(), libraryID, origin, snapshotID) {
+			defer func() {
+				if err := replicaWriter.Close(); err != nil {
+					scheduler.setReplicaError(libraryID, origin, snapshotID, replica, err)
+				}
+			}()
+
+			replicaArtifactWriter, err := replicaWriter.NextArtifactWriter(r.Context(), name)
+			if err != nil {
+				scheduler.setReplicaError(libraryID, origin, snapshotID, replica, err)
+				continue
+			}
+
+			writer.replicas[replica] = replicaArtifactWriter
+		}
+
 		size, err := io.Copy(writer, r.Body)
 		if err != nil {
 			slog.Error("Failed to write artifact", slog.Any("error", err))
+			if err := writer.Abort(); err != nil {
+				slog.Warn("Failed to abort artifact", slog.Any("error", err))
+			}
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
+		// Verify the digest before the artifact is stored, mismatching artifacts
+		// are neither stored nor accounted for
+		if expectedDigest != "" && expectedDigest != writer.Sum() {
+			slog.Warn("Artifact digest does not match supplied digest", slog.String("expected", expectedDigest), slog.String("actual", writer.Sum()))
+			if err := writer.Abort(); err != nil {
+				slog.Warn("Failed to abort artifact", slog.Any("error", err))
+			}
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		// Blobs already stored are deduplicated when the writer is closed, only
+		// newly stored bytes count towards the libraries' usage
+		stored := make(map[string]bool)
+		stored[libraryID] = scheduler.blobStored(r.Context(), libraryID, writer.Sum())
+		for replica := range writer.replicas {
+			stored[replica] = scheduler.blobStored(r.Context(), replica, writer.Sum())
+		}
+
 		err = writer.Close()
 		if err != nil {
 			slog.Error("Failed to close artifact", slog.Any("error", err))
@@ -102,13 +144,75 @@ func NewAPI(scheduler *Scheduler, libraryWriters map[string]libraries.LibraryWri
 			return
 		}
 
-		digest := writer.Digest()
+		for replica, err := range writer.Errors() {
+			scheduler.setReplicaError(libraryID, origin, snapshotID, replica, err)
+		}
+
+		if !stored[libraryID] {
+			scheduler.accountWrite(libraryID, size)
+		}
+		for replica := range writer.replicas {
+			if _, ok := writer.Errors()[replica]; !ok && !stored[replica] {
+				scheduler.accountWrite(replica, size)
+			}
+		}
 
 		w.Header().Set("X-Larch-Size", strconv.FormatInt(size, 10))
-		w.Header().Set("X-Larch-Digest", digest)
+		w.Header().Set("X-Larch-Digest", writer.Digest())
 		w.WriteHeader(http.StatusCreated)
 	})
 
+	// Link an already stored blob as an artifact of the snapshot. Responds with
+	// not found if the blob is not stored, in which case it must be sent
+	// TODO: Why have these fields in the URL if the worker is only supposed to
+	// access one snapshot?
+	mux.HandleFunc("PUT /api/v1/libraries/{library}/snapshots/{origin}/{snapshot}/artifacts/{algorithm}/{digest}", func(w http.ResponseWriter, r *http.Request) {
+		origin := r.PathValue("origin")
+		snapshotID := r.PathValue("snapshot")
+		libraryID := r.PathValue("library")
+		digest := r.PathValue("algorithm") + ":" + r.PathValue("digest")
+
+		// TODO: Auth
+
+		name := r.Header.Get("X-Larch-Name")
+
+		library, ok := libraryWriters[libraryID]
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		snapshotWriter, err := library.WriteSnapshot(r.Context(), origin, snapshotID)
+		if err != nil {
+			slog.Error("Failed to get snapshot writer", slog.Any("error", err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer snapshotWriter.Close()
+
+		// Libraries not sharing blobs always require the artifact to be sent
+		linkingWriter, ok := snapshotWriter.(libraries.LinkingSnapshotWriter)
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		err = linkingWriter.LinkArtifact(r.Context(), name, digest)
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.Error("Failed to link artifact", slog.Any("error", err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		scheduler.linkReplicas(r.Context(), libraryID, origin, snapshotID, name, digest)
+
+		w.Header().Set("X-Larch-Digest", digest)
+		w.WriteHeader(http.StatusOK)
+	})
+
 	// TODO: Why have these fields in the URL if the worker is only supposed to
 	// access one snapshot?
 	mux.HandleFunc("POST /api/v1/libraries/{library}/snapshots/{origin}/{snapshot}/manifests", func(w http.ResponseWriter, r *http.Request) {
@@ -130,7 +234,6 @@ func NewAPI(scheduler *Scheduler, libraryWriters map[string]libraries.LibraryWri
 			return
 		}
 
-		// TODO: Support multiple libraries (or library from strategy)
 		// TODO: Return conflict if already open?
 		snapshotWriter, err := library.WriteSnapshot(r.Context(), origin, snapshotID)
 		if err != nil {
@@ -138,6 +241,7 @@ func NewAPI(scheduler *Scheduler, libraryWriters map[string]libraries.LibraryWri
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
+		defer snapshotWriter.Close()
 
 		err = snapshotWriter.WriteArtifactManifest(r.Context(), manifest)
 		if err != nil {
@@ -146,6 +250,8 @@ func NewAPI(scheduler *Scheduler, libraryWriters map[string]libraries.LibraryWri
 			return
 		}
 
+		scheduler.writeReplicaManifests(r.Context(), libraryID, origin, snapshotID, manifest)
+
 		w.WriteHeader(http.StatusCreated)
 	})
 
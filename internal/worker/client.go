@@ -3,12 +3,17 @@ package worker
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
 
 	"github.com/AlexGustafsson/larch/internal/libraries"
 )
@@ -44,7 +49,7 @@ func (c *Client) GetJobRequest(ctx context.Context) (*JobRequest, error) {
 	return &jobRequest, nil
 }
 
-var _ libraries.SnapshotWriter = (*JobClient)(nil)
+var _ libraries.LinkingSnapshotWriter = (*JobClient)(nil)
 
 type JobClient struct {
 	LibraryID  string
@@ -85,6 +90,11 @@ func (c *JobClient) UpdateJob(ctx context.Context, job Job) error {
 
 // NextArtifactWriter implements libraries.SnapshotWriter.
 func (c *JobClient) NextArtifactWriter(ctx context.Context, name string) (libraries.ArtifactWriter, error) {
+	return c.nextArtifactWriter(ctx, name, "")
+}
+
+// nextArtifactWriter returns a writer of an artifact. The digest is optional.
+func (c *JobClient) nextArtifactWriter(ctx context.Context, name string, digest string) (libraries.ArtifactWriter, error) {
 	slog.Debug("Requesting artifact writer")
 	reader, writer := io.Pipe()
 
@@ -96,6 +106,9 @@ func (c *JobClient) NextArtifactWriter(ctx context.Context, name string) (librar
 	req.Header.Set("Authorization", "Bearer "+c.Token)
 
 	req.Header.Set("X-Larch-Name", name)
+	if digest != "" {
+		req.Header.Set("X-Larch-Digest", digest)
+	}
 
 	errCh := make(chan error)
 	digestCh := make(chan string)
@@ -128,8 +141,18 @@ func (c *JobClient) NextArtifactWriter(ctx context.Context, name string) (librar
 
 // WriteArtifact implements libraries.SnapshotWriter.
 func (c *JobClient) WriteArtifact(ctx context.Context, name string, data []byte) (int64, string, error) {
+	// Don't send blobs that are already stored
+	hash := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+	err := c.LinkArtifact(ctx, name, digest)
+	if err == nil {
+		return int64(len(data)), digest, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, "", err
+	}
+
 	slog.Debug("Writing artifact")
-	w, err := c.NextArtifactWriter(ctx, name)
+	w, err := c.nextArtifactWriter(ctx, name, digest)
 	if err != nil {
 		return 0, "", err
 	}
@@ -144,8 +167,40 @@ func (c *JobClient) WriteArtifact(ctx context.Context, name string, data []byte)
 		return n, "", err
 	}
 
-	digest := w.Digest()
-	return n, digest, nil
+	return n, w.Digest(), nil
+}
+
+// LinkArtifact implements libraries.LinkingSnapshotWriter.
+func (c *JobClient) LinkArtifact(ctx context.Context, name string, digest string) error {
+	slog.Debug("Linking artifact")
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok {
+		return fmt.Errorf("invalid digest")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("%s/api/v1/libraries/%s/snapshots/%s/%s/artifacts/%s/%s", c.Endpoint, c.LibraryID, c.Origin, c.SnapshotID, url.PathEscape(algorithm), url.PathEscape(encoded)), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	req.Header.Set("X-Larch-Name", name)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	default:
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
 }
 
 // WriteArtifactManifest implements libraries.SnapshotWriter.
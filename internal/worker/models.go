@@ -8,6 +8,10 @@ import (
 	"time"
 )
 
+var (
+	ErrNotFound = errors.New("not found")
+)
+
 type JobRequest struct {
 	Token    string
 	Archiver Archiver
@@ -15,8 +19,11 @@ type JobRequest struct {
 }
 
 type Job struct {
-	ID         string
+	ID string
+	// Library is the library the worker writes to. Writes are replicated to the
+	// job's other libraries, see [Replica].
 	Library    string
+	Libraries  []string
 	Deadline   time.Time
 	URL        string
 	Origin     string
@@ -29,6 +36,17 @@ type Job struct {
 	Error      string
 }
 
+// ScheduledSnapshot is a snapshot which has been scheduled, along with the
+// jobs requested to archive it.
+type ScheduledSnapshot struct {
+	Library    string
+	Libraries  []string
+	URL        string
+	Origin     string
+	SnapshotID string
+	Jobs       []Job
+}
+
 type Archiver struct {
 	ChromeArchiver     *ChromeArchiver
 	ArchiveOrgArchiver *ArchiveOrgArchiver
@@ -59,6 +77,11 @@ type ArchiveOrgArchiver struct{}
 type OpenGraphArchiver struct{}
 
 type Strategy struct {
-	Library   string
+	// ID identifies the strategy. Snapshots are annotated with the strategy they
+	// were scheduled with.
+	ID string
+	// Libraries holds the libraries to write snapshots to. Workers write to the
+	// first library, writes are replicated to the other libraries.
+	Libraries []string
 	Archivers []Archiver
 }
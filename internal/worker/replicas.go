@@ -0,0 +1,289 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/replication"
+)
+
+// Replica is a library a snapshot is written to in addition to the library
+// workers write to, the first library of the strategy.
+type Replica struct {
+	Library string
+	// Error is the error of the last failed write, if any. Failed replicas are
+	// no longer written to until retried, see [Scheduler.RetryReplicas].
+	Error string
+}
+
+func replicaKey(library string, origin string, snapshotID string) string {
+	return library + "/" + origin + "/" + snapshotID
+}
+
+// GetReplicas returns the replicas of a snapshot written to the library.
+// Replicas are forgotten once all of the snapshot's jobs have ended and all
+// replicas were written.
+func (s *Scheduler) GetReplicas(library string, origin string, snapshotID string) []Replica {
+	replicas := s.replicas.Get(replicaKey(library, origin, snapshotID))
+	if replicas == nil {
+		replicas = make([]Replica, 0)
+	}
+	return replicas
+}
+
+// setReplicas records the replicas of a snapshot.
+func (s *Scheduler) setReplicas(library string, origin string, snapshotID string, replicas []Replica) {
+	err := s.replicas.Update(replicaKey(library, origin, snapshotID), func([]Replica) []Replica {
+		return replicas
+	})
+	if err != nil {
+		slog.Warn("Failed to store replicas", slog.Any("error", err))
+	}
+}
+
+// pruneReplicas forgets the replicas of a snapshot once all were written.
+// Failed replicas are kept to be retried.
+func (s *Scheduler) pruneReplicas(library string, origin string, snapshotID string) {
+	err := s.replicas.Update(replicaKey(library, origin, snapshotID), func(replicas []Replica) []Replica {
+		for _, replica := range replicas {
+			if replica.Error != "" {
+				return replicas
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Failed to store replicas", slog.Any("error", err))
+	}
+}
+
+// setReplicaError records the result of a write to a replica.
+func (s *Scheduler) setReplicaError(library string, origin string, snapshotID string, replica string, err error) {
+	if err != nil {
+		slog.Warn("Failed to write to replica", slog.String("library", replica), slog.String("origin", origin), slog.String("snapshotId", snapshotID), slog.Any("error", err))
+	}
+
+	updateErr := s.replicas.Update(replicaKey(library, origin, snapshotID), func(replicas []Replica) []Replica {
+		for i := range replicas {
+			if replicas[i].Library == replica {
+				if err == nil {
+					replicas[i].Error = ""
+				} else {
+					replicas[i].Error = err.Error()
+				}
+			}
+		}
+		return replicas
+	})
+	if updateErr != nil {
+		slog.Warn("Failed to store replicas", slog.Any("error", updateErr))
+	}
+}
+
+// replicaWriters returns writers of a snapshot's replicas which have not
+// failed, by library. The writers must be closed.
+func (s *Scheduler) replicaWriters(ctx context.Context, library string, origin string, snapshotID string) map[string]libraries.SnapshotWriter {
+	writers := make(map[string]libraries.SnapshotWriter)
+	for _, replica := range s.GetReplicas(library, origin, snapshotID) {
+		if replica.Error != "" {
+			continue
+		}
+
+		libraryWriter, ok := s.libraryWriters[replica.Library]
+		if !ok {
+			s.setReplicaError(library, origin, snapshotID, replica.Library, fmt.Errorf("no such library"))
+			continue
+		}
+
+		snapshotWriter, err := libraryWriter.WriteSnapshot(ctx, origin, snapshotID)
+		if err != nil {
+			s.setReplicaError(library, origin, snapshotID, replica.Library, err)
+			continue
+		}
+
+		writers[replica.Library] = snapshotWriter
+	}
+
+	return writers
+}
+
+// writeReplicas calls fn for each of the snapshot's replicas which have not
+// failed. Replicas for which fn fails are marked as failed.
+func (s *Scheduler) writeReplicas(ctx context.Context, library string, origin string, snapshotID string, fn func(replica string, snapshotWriter libraries.SnapshotWriter) error) {
+	for replica, snapshotWriter := range s.replicaWriters(ctx, library, origin, snapshotID) {
+		err := fn(replica, snapshotWriter)
+		if err == nil {
+			err = snapshotWriter.Close()
+		} else {
+			snapshotWriter.Close()
+		}
+
+		if err != nil {
+			s.setReplicaError(library, origin, snapshotID, replica, err)
+		}
+	}
+}
+
+// linkReplicas writes an artifact already written to the library to the
+// snapshot's replicas. Blobs not stored in a replica are copied from the
+// library.
+func (s *Scheduler) linkReplicas(ctx context.Context, library string, origin string, snapshotID string, name string, digest string) {
+	libraryReader, ok := s.libraryReaders[library]
+	if !ok {
+		return
+	}
+
+	s.writeReplicas(ctx, library, origin, snapshotID, func(replica string, snapshotWriter libraries.SnapshotWriter) error {
+		_, n, err := replication.CopyArtifact(ctx, libraryReader, snapshotWriter, name, digest)
+		s.accountWrite(replica, n)
+		return err
+	})
+}
+
+// writeReplicaManifests writes an artifact manifest to the snapshot's
+// replicas.
+func (s *Scheduler) writeReplicaManifests(ctx context.Context, library string, origin string, snapshotID string, manifest libraries.ArtifactManifest) {
+	// The content encoding is specific to how the library stores the blob
+	manifest.ContentEncoding = ""
+
+	s.writeReplicas(ctx, library, origin, snapshotID, func(replica string, snapshotWriter libraries.SnapshotWriter) error {
+		return snapshotWriter.WriteArtifactManifest(ctx, manifest)
+	})
+}
+
+// RetryReplicas retries writing a snapshot to its failed replicas, without
+// re-running any archivers. The snapshot is replicated from the library.
+func (s *Scheduler) RetryReplicas(ctx context.Context, library string, origin string, snapshotID string) error {
+	libraryReader, ok := s.libraryReaders[library]
+	if !ok {
+		return fmt.Errorf("no such library")
+	}
+
+	var errs []error
+	for _, replica := range s.GetReplicas(library, origin, snapshotID) {
+		if replica.Error == "" {
+			continue
+		}
+
+		target, ok := s.libraryWriters[replica.Library].(replication.Target)
+		if !ok {
+			errs = append(errs, fmt.Errorf("no such library: %s", replica.Library))
+			continue
+		}
+
+		if err := s.checkQuota(replica.Library); err != nil {
+			s.setReplicaError(library, origin, snapshotID, replica.Library, err)
+			errs = append(errs, err)
+			continue
+		}
+
+		slog.Debug("Retrying replica", slog.String("library", replica.Library), slog.String("origin", origin), slog.String("snapshotId", snapshotID))
+		report, err := replication.ReplicateSnapshot(ctx, libraryReader, target, origin, snapshotID)
+		s.setReplicaError(library, origin, snapshotID, replica.Library, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", replica.Library, err))
+		} else {
+			s.accountWrite(replica.Library, report.Bytes)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+var _ libraries.ArtifactWriter = (*teeWriter)(nil)
+
+// teeWriter writes an artifact to a library and its replicas. Replicas
+// failing to write are dropped rather than failing the write.
+type teeWriter struct {
+	libraries.ArtifactWriter
+	replicas map[string]libraries.ArtifactWriter
+	errors   map[string]error
+	hash     hash.Hash
+}
+
+func newTeeWriter(writer libraries.ArtifactWriter) *teeWriter {
+	return &teeWriter{
+		ArtifactWriter: writer,
+		replicas:       make(map[string]libraries.ArtifactWriter),
+		errors:         make(map[string]error),
+		hash:           sha256.New(),
+	}
+}
+
+// Write implements libraries.ArtifactWriter.
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.ArtifactWriter.Write(p)
+	if err != nil {
+		return n, err
+	}
+	t.hash.Write(p[:n])
+
+	for replica, writer := range t.replicas {
+		if _, err := writer.Write(p); err != nil {
+			writer.Close()
+			t.errors[replica] = err
+			delete(t.replicas, replica)
+		}
+	}
+
+	return n, nil
+}
+
+// Close implements libraries.ArtifactWriter. Replicas are closed and verified
+// to have been written the same content, see [teeWriter.Errors].
+func (t *teeWriter) Close() error {
+	if err := t.ArtifactWriter.Close(); err != nil {
+		for _, writer := range t.replicas {
+			writer.Close()
+		}
+		return err
+	}
+
+	for replica, writer := range t.replicas {
+		err := writer.Close()
+		if err == nil && writer.Digest() != t.Digest() {
+			err = fmt.Errorf("digest mismatch: expected %s, got %s", t.Digest(), writer.Digest())
+		}
+
+		if err != nil {
+			t.errors[replica] = err
+		}
+	}
+
+	return nil
+}
+
+// Abort implements libraries.AbortableArtifactWriter. Nothing is stored in the
+// library nor in its replicas.
+func (t *teeWriter) Abort() error {
+	var errs []error
+	if err := libraries.AbortArtifact(t.ArtifactWriter); err != nil {
+		errs = append(errs, err)
+	}
+
+	for replica, writer := range t.replicas {
+		if err := libraries.AbortArtifact(writer); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", replica, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Sum returns the digest of the content written so far. Unlike
+// [teeWriter.Digest], it's available before the writer is closed.
+func (t *teeWriter) Sum() string {
+	return "sha256:" + hex.EncodeToString(t.hash.Sum(nil))
+}
+
+// Errors returns the errors of replicas which failed to be written, by
+// library.
+func (t *teeWriter) Errors() map[string]error {
+	return t.errors
+}
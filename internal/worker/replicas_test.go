@@ -0,0 +1,176 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/AlexGustafsson/larch/internal/indexers"
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/libraries/disk"
+	"github.com/AlexGustafsson/larch/internal/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicas(t *testing.T) {
+	local, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer local.Close()
+
+	offsite, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer offsite.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": local, "offsite": offsite}
+	libraryWriters := map[string]libraries.LibraryWriter{"local": local, "offsite": offsite}
+
+	statePath := t.TempDir()
+	replicas, err := NewReplicaStore(statePath)
+	require.NoError(t, err)
+
+	scheduler := NewScheduler(indexers.NewInMemoryIndex(), libraryReaders, libraryWriters, nil, replicas)
+
+	server := httptest.NewServer(NewAPI(scheduler, libraryWriters))
+	defer server.Close()
+
+	scheduled, err := scheduler.ScheduleSnapshot(context.TODO(), "https://example.com", &Strategy{
+		Libraries: []string{"local", "offsite"},
+		Archivers: []Archiver{{OpenGraphArchiver: &OpenGraphArchiver{}}},
+	}, nil)
+	require.NoError(t, err)
+	require.Len(t, scheduled.Jobs, 1)
+
+	client := &JobClient{
+		LibraryID:  scheduled.Library,
+		Origin:     scheduled.Origin,
+		SnapshotID: scheduled.SnapshotID,
+		Endpoint:   server.URL,
+		Client:     http.DefaultClient,
+	}
+
+	write := func(name string, data []byte) string {
+		size, digest, err := client.WriteArtifact(context.TODO(), name, data)
+		require.NoError(t, err)
+		require.NoError(t, client.WriteArtifactManifest(context.TODO(), libraries.ArtifactManifest{
+			ContentType: "text/plain",
+			Digest:      digest,
+			Size:        size,
+		}))
+		return digest
+	}
+
+	digests := func(library libraries.LibraryReader) []string {
+		snapshotReader, err := library.ReadSnapshot(context.TODO(), scheduled.Origin, scheduled.SnapshotID)
+		require.NoError(t, err)
+		defer snapshotReader.Close()
+
+		digests := make([]string, 0)
+		for _, artifact := range snapshotReader.Index().Artifacts {
+			digests = append(digests, artifact.Digest)
+		}
+		return digests
+	}
+
+	first := write("first.txt", []byte("first"))
+	// Already stored, linked rather than sent
+	write("copy.txt", []byte("first"))
+
+	expected := []string{libraries.EmptyDigest, first, first}
+	assert.Equal(t, expected, digests(local))
+	assert.Equal(t, expected, digests(offsite))
+	assert.Equal(t, []Replica{{Library: "offsite"}}, scheduler.GetReplicas("local", scheduled.Origin, scheduled.SnapshotID))
+
+	// Failed replicas are no longer written to
+	scheduler.setReplicaError("local", scheduled.Origin, scheduled.SnapshotID, "offsite", errors.New("unavailable"))
+	second := write("second.txt", []byte("second"))
+
+	assert.Equal(t, append(expected, second), digests(local))
+	assert.Equal(t, expected, digests(offsite))
+	assert.Equal(t, []Replica{{Library: "offsite", Error: "unavailable"}}, scheduler.GetReplicas("local", scheduled.Origin, scheduled.SnapshotID))
+
+	// Failed replicas are kept after the snapshot's jobs have ended and are
+	// persisted
+	job := scheduled.Jobs[0]
+	job.Status = "succeeded"
+	require.NoError(t, scheduler.UpdateJob(context.TODO(), job))
+	assert.Equal(t, []Replica{{Library: "offsite", Error: "unavailable"}}, scheduler.GetReplicas("local", scheduled.Origin, scheduled.SnapshotID))
+
+	reopened, err := NewReplicaStore(statePath)
+	require.NoError(t, err)
+	assert.Equal(t, []Replica{{Library: "offsite", Error: "unavailable"}}, reopened.Get(replicaKey("local", scheduled.Origin, scheduled.SnapshotID)))
+
+	require.NoError(t, scheduler.RetryReplicas(context.TODO(), "local", scheduled.Origin, scheduled.SnapshotID))
+
+	assert.Equal(t, append(expected, second), digests(offsite))
+	assert.Equal(t, []Replica{{Library: "offsite"}}, scheduler.GetReplicas("local", scheduled.Origin, scheduled.SnapshotID))
+
+	// Replicas are forgotten once written
+	require.NoError(t, scheduler.UpdateJob(context.TODO(), job))
+	assert.Empty(t, scheduler.GetReplicas("local", scheduled.Origin, scheduled.SnapshotID))
+
+	reopened, err = NewReplicaStore(statePath)
+	require.NoError(t, err)
+	assert.Empty(t, reopened.Get(replicaKey("local", scheduled.Origin, scheduled.SnapshotID)))
+}
+
+func TestWriteArtifactDigestMismatch(t *testing.T) {
+	local, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer local.Close()
+
+	offsite, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer offsite.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": local, "offsite": offsite}
+	libraryWriters := map[string]libraries.LibraryWriter{"local": local, "offsite": offsite}
+
+	quotas := quota.NewTracker(libraryReaders, map[string]quota.Quota{})
+	require.NoError(t, quotas.Refresh(context.TODO()))
+
+	scheduler := NewScheduler(indexers.NewInMemoryIndex(), libraryReaders, libraryWriters, quotas, nil)
+
+	server := httptest.NewServer(NewAPI(scheduler, libraryWriters))
+	defer server.Close()
+
+	scheduled, err := scheduler.ScheduleSnapshot(context.TODO(), "https://example.com", &Strategy{
+		Libraries: []string{"local", "offsite"},
+		Archivers: []Archiver{{OpenGraphArchiver: &OpenGraphArchiver{}}},
+	}, nil)
+	require.NoError(t, err)
+
+	client := &JobClient{
+		LibraryID:  scheduled.Library,
+		Origin:     scheduled.Origin,
+		SnapshotID: scheduled.SnapshotID,
+		Endpoint:   server.URL,
+		Client:     http.DefaultClient,
+	}
+
+	data := []byte("content")
+	digest := "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	writer, err := client.nextArtifactWriter(context.TODO(), "artifact.txt", digest)
+	require.NoError(t, err)
+	_, err = writer.Write(data)
+	require.NoError(t, err)
+	assert.ErrorContains(t, writer.Close(), "unexpected status code: 400")
+
+	// Mismatching artifacts are neither stored nor accounted for
+	hash := sha256.Sum256(data)
+	for libraryID, library := range libraryReaders {
+		_, err := library.ReadArtifact(context.TODO(), "sha256:"+hex.EncodeToString(hash[:]))
+		assert.ErrorIs(t, err, os.ErrNotExist, libraryID)
+
+		status, ok := quotas.Status(libraryID)
+		require.True(t, ok)
+		assert.Equal(t, int64(0), status.Usage.Bytes, libraryID)
+	}
+	assert.Equal(t, []Replica{{Library: "offsite"}}, scheduler.GetReplicas("local", scheduled.Origin, scheduled.SnapshotID))
+}
@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// ReplicaStore holds the replicas of snapshots being written, by library,
+// origin and snapshot. If opened using [NewReplicaStore], replicas are
+// persisted in a single JSON file so that failed replicas can be retried after
+// a restart.
+type ReplicaStore struct {
+	mutex sync.Mutex
+	// path is empty if replicas are not persisted
+	path     string
+	replicas map[string][]Replica
+}
+
+// NewReplicaStore opens the replica store at the given directory, creating it
+// if necessary.
+func NewReplicaStore(basePath string) (*ReplicaStore, error) {
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(basePath, "replicas.json")
+
+	replicas := make(map[string][]Replica)
+	file, err := os.Open(path)
+	if err == nil {
+		err = json.NewDecoder(file).Decode(&replicas)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return &ReplicaStore{
+		path:     path,
+		replicas: replicas,
+	}, nil
+}
+
+// newMemoryReplicaStore returns a replica store which is not persisted.
+func newMemoryReplicaStore() *ReplicaStore {
+	return &ReplicaStore{
+		replicas: make(map[string][]Replica),
+	}
+}
+
+// Get returns the replicas of a snapshot. The returned replicas are a copy and
+// can be modified freely.
+func (s *ReplicaStore) Get(key string) []Replica {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return slices.Clone(s.replicas[key])
+}
+
+// Update replaces the replicas of a snapshot with the result of fn and
+// persists all replicas to disk. Snapshots without replicas are removed.
+func (s *ReplicaStore) Update(key string, fn func([]Replica) []Replica) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	replicas := fn(slices.Clone(s.replicas[key]))
+	if len(replicas) == 0 {
+		if _, ok := s.replicas[key]; !ok {
+			return nil
+		}
+		delete(s.replicas, key)
+	} else {
+		s.replicas[key] = replicas
+	}
+
+	if s.path == "" {
+		return nil
+	}
+
+	// Write to a temporary file first in order to never leave a partially
+	// written state behind
+	file, err := os.CreateTemp(filepath.Dir(s.path), ".replicas-*.json")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s.replicas); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), s.path)
+}
@@ -5,12 +5,14 @@ import (
 	"crypto/rand"
 	"fmt"
 	"log/slog"
+	"maps"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/AlexGustafsson/larch/internal/indexers"
 	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/quota"
 	"github.com/google/uuid"
 
 	urlpkg "net/url"
@@ -24,39 +26,97 @@ type RemoteWorker struct {
 type Scheduler struct {
 	mutex    sync.Mutex
 	requests chan JobRequest
-	// NOTE: No reason for these to persist - upon restart, simply reschedule jobs
+	// reserved holds an entry for each reserved slot of the requests queue,
+	// see [Scheduler.reserve]
+	reserved chan struct{}
+	// NOTE: No reason for this to persist - upon restart, simply reschedule jobs
 	// and handle them anew.
-	inflight       map[string]Job
+	inflight map[string]Job
+	// replicas are persisted for failed replicas to be retried after a restart
+	replicas *ReplicaStore
+	// partial holds the libraries in which snapshots have been marked as
+	// partial, by replica key
+	partial        map[string][]string
 	secret         []byte
 	indexer        indexers.Indexer
 	libraryReaders map[string]libraries.LibraryReader
 	libraryWriters map[string]libraries.LibraryWriter
+	// quotas is optional
+	quotas *quota.Tracker
 }
 
-func NewScheduler(indexer indexers.Indexer, libraryReaders map[string]libraries.LibraryReader, libraryWriters map[string]libraries.LibraryWriter) *Scheduler {
+// NewScheduler returns a new scheduler. Quotas are optional, if set jobs
+// targeting libraries over their hard quota are refused. Replicas are
+// optional, if set the status of replicas is persisted.
+func NewScheduler(indexer indexers.Indexer, libraryReaders map[string]libraries.LibraryReader, libraryWriters map[string]libraries.LibraryWriter, quotas *quota.Tracker, replicas *ReplicaStore) *Scheduler {
 	var secret [32]byte
 	if _, err := rand.Read(secret[:]); err != nil {
 		panic(err)
 	}
 
+	if replicas == nil {
+		replicas = newMemoryReplicaStore()
+	}
+
 	s := &Scheduler{
 		requests:       make(chan JobRequest, 32),
+		reserved:       make(chan struct{}, 32),
 		inflight:       make(map[string]Job),
+		replicas:       replicas,
+		partial:        make(map[string][]string),
 		secret:         secret[:],
 		indexer:        indexer,
 		libraryReaders: libraryReaders,
 		libraryWriters: libraryWriters,
+		quotas:         quotas,
 	}
 
 	return s
 }
 
+// checkQuota returns an error if the library is over its hard quota.
+func (s *Scheduler) checkQuota(libraryID string) error {
+	if s.quotas == nil {
+		return nil
+	}
+
+	return s.quotas.Check(libraryID)
+}
+
+// accountWrite accounts for bytes newly stored in a library. Blobs already
+// stored must not be accounted for, see [Scheduler.blobStored].
+func (s *Scheduler) accountWrite(libraryID string, n int64) {
+	if s.quotas != nil {
+		s.quotas.Add(libraryID, n)
+	}
+}
+
+// blobStored returns whether or not the blob of the given digest is stored in
+// the library.
+func (s *Scheduler) blobStored(ctx context.Context, libraryID string, digest string) bool {
+	library, ok := s.libraryReaders[libraryID]
+	if !ok {
+		return false
+	}
+
+	reader, err := library.ReadArtifact(ctx, digest)
+	if err != nil {
+		return false
+	}
+	reader.Close()
+	return true
+}
+
 func (s *Scheduler) UpdateJob(ctx context.Context, job Job) error {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
 	// TODO: E-Tag?
 	s.inflight[job.ID] = job
+	ended := s.snapshotEnded(job)
+	s.mutex.Unlock()
+
+	if ended {
+		s.endSnapshot(job.Library, job.Origin, job.SnapshotID)
+	}
 
 	// TODO: Debounce
 	go func() {
@@ -87,6 +147,97 @@ func (s *Scheduler) UpdateJob(ctx context.Context, job Job) error {
 	return nil
 }
 
+// ended returns whether or not the job has ended.
+func (j Job) ended() bool {
+	return j.Status == "succeeded" || j.Status == "failed" || j.Status == "refused"
+}
+
+// snapshotEnded returns whether or not all jobs of the job's snapshot have
+// ended. Expects the lock to be held.
+//
+// NOTE: Jobs abandoned by workers never end, their snapshots are left partial.
+func (s *Scheduler) snapshotEnded(job Job) bool {
+	for _, other := range s.inflight {
+		if other.Library == job.Library && other.Origin == job.Origin && other.SnapshotID == job.SnapshotID && !other.ended() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// markPartial marks a snapshot as partial in the library until the snapshot
+// has ended, if supported by the library. See [libraries.PartialSnapshotMarker].
+func (s *Scheduler) markPartial(ctx context.Context, libraryID string, library string, origin string, snapshotID string) error {
+	marker, ok := s.libraryWriters[library].(libraries.PartialSnapshotMarker)
+	if !ok {
+		return nil
+	}
+
+	if err := marker.MarkPartial(ctx, origin, snapshotID); err != nil {
+		return err
+	}
+
+	key := replicaKey(libraryID, origin, snapshotID)
+	s.mutex.Lock()
+	s.partial[key] = append(s.partial[key], library)
+	s.mutex.Unlock()
+	return nil
+}
+
+// endSnapshot is called once all jobs of a snapshot have ended. The snapshot's
+// partial marks are cleared and its replicas are pruned.
+func (s *Scheduler) endSnapshot(libraryID string, origin string, snapshotID string) {
+	key := replicaKey(libraryID, origin, snapshotID)
+
+	// Snapshots only end once, even if jobs are updated after having ended
+	s.mutex.Lock()
+	marked := s.partial[key]
+	delete(s.partial, key)
+	s.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, library := range marked {
+		marker := s.libraryWriters[library].(libraries.PartialSnapshotMarker)
+		if err := marker.ClearPartial(ctx, origin, snapshotID); err != nil {
+			slog.Warn("Failed to clear partial mark of snapshot", slog.String("library", library), slog.String("origin", origin), slog.String("snapshotId", snapshotID), slog.Any("error", err))
+		}
+	}
+
+	s.pruneReplicas(libraryID, origin, snapshotID)
+}
+
+// reserve reserves room in the job queue for n job requests, waiting until
+// there's room or the context is done. Room is reserved before a snapshot is
+// written, so that no snapshot is left behind if the queue is full. Reserved
+// room is freed as job requests are taken from the queue, or by
+// [Scheduler.release] if unused.
+func (s *Scheduler) reserve(ctx context.Context, n int) error {
+	if n > cap(s.reserved) {
+		return fmt.Errorf("too many jobs: the queue holds at most %d", cap(s.reserved))
+	}
+
+	for i := range n {
+		select {
+		case s.reserved <- struct{}{}:
+		case <-ctx.Done():
+			s.release(i)
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// release frees n slots of room reserved in the job queue.
+func (s *Scheduler) release(n int) {
+	for range n {
+		<-s.reserved
+	}
+}
+
 type GetJobOptions struct {
 }
 
@@ -102,63 +253,199 @@ func (s *Scheduler) GetJobRequest(ctx context.Context, options *GetJobOptions) (
 			return nil, fmt.Errorf("closed")
 		}
 
+		s.release(1)
 		return &job, nil
 	}
 }
 
-// TODO: Support multiple libraries? What's the use case?
-func (s *Scheduler) ScheduleSnapshot(ctx context.Context, url string, strategy *Strategy) error {
+type ScheduleSnapshotOptions struct {
+	// Annotations holds additional annotations to record for the snapshot.
+	Annotations map[string]string
+	// OriginalURL is the URL as supplied, before it was rewritten, if any.
+	OriginalURL string
+}
+
+// GetJob returns an inflight job by id.
+func (s *Scheduler) GetJob(ctx context.Context, id string) (*Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.inflight[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &job, nil
+}
+
+// ScheduleSnapshot schedules a snapshot of the URL using the strategy. The
+// snapshot is written to all of the strategy's libraries.
+//
+// If the library is over its hard quota, the snapshot is returned with its
+// refused jobs along with a [*quota.ExceededError]. Waits for room in the job
+// queue until the context is done, in which case nothing is written.
+func (s *Scheduler) ScheduleSnapshot(ctx context.Context, url string, strategy *Strategy, options *ScheduleSnapshotOptions) (*ScheduledSnapshot, error) {
 	u, err := urlpkg.Parse(url)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	origin := u.Host
 	snapshotID := strconv.FormatInt(time.Now().UnixMilli(), 10)
 
-	uuid, err := uuid.NewRandom()
-	if err != nil {
-		return err
+	if len(strategy.Libraries) == 0 {
+		return nil, fmt.Errorf("no library")
 	}
 
-	id := uuid.String()
+	for _, libraryID := range strategy.Libraries {
+		if _, ok := s.libraryWriters[libraryID]; !ok {
+			return nil, fmt.Errorf("no such library")
+		}
+	}
 
-	library, ok := s.libraryWriters[strategy.Library]
-	if !ok {
-		return fmt.Errorf("no such library")
+	libraryID := strategy.Libraries[0]
+
+	scheduled := &ScheduledSnapshot{
+		Library:    libraryID,
+		Libraries:  strategy.Libraries,
+		URL:        url,
+		Origin:     origin,
+		SnapshotID: snapshotID,
+		Jobs:       make([]Job, 0),
 	}
 
-	snapshotWriter, err := library.WriteSnapshot(ctx, origin, snapshotID)
+	// Jobs targeting a library over its hard quota are refused. The snapshot is
+	// not written, but the jobs are kept to make the refusal visible
+	if err := s.checkQuota(libraryID); err != nil {
+		slog.Warn("Refusing to schedule snapshot", slog.String("url", url), slog.Any("error", err))
+		for range strategy.Archivers {
+			job := Job{
+				ID:         uuid.NewString(),
+				Library:    libraryID,
+				Libraries:  strategy.Libraries,
+				URL:        url,
+				Origin:     origin,
+				SnapshotID: snapshotID,
+				Status:     "refused",
+				Requested:  time.Now(),
+				Ended:      time.Now(),
+				Error:      err.Error(),
+			}
+
+			s.mutex.Lock()
+			s.inflight[job.ID] = job
+			s.mutex.Unlock()
+
+			scheduled.Jobs = append(scheduled.Jobs, job)
+		}
+
+		return scheduled, err
+	}
+
+	// Wait for room in the job queue before writing anything. Unused room is
+	// freed on failure
+	if err := s.reserve(ctx, len(strategy.Archivers)); err != nil {
+		return nil, err
+	}
+	unused := len(strategy.Archivers)
+	defer func() { s.release(unused) }()
+
+	// The snapshot is partial until all of its jobs have ended
+	if err := s.markPartial(ctx, libraryID, libraryID, origin, snapshotID); err != nil {
+		return nil, err
+	}
+
+	snapshotWriter, err := s.libraryWriters[libraryID].WriteSnapshot(ctx, origin, snapshotID)
 	if err != nil {
-		return err
+		s.endSnapshot(libraryID, origin, snapshotID)
+		return nil, err
+	}
+
+	annotations := make(map[string]string)
+	if options != nil {
+		maps.Copy(annotations, options.Annotations)
+	}
+	annotations["larch.snapshot.url"] = url
+	if options != nil && options.OriginalURL != "" {
+		annotations["larch.snapshot.originalUrl"] = options.OriginalURL
+	}
+	annotations["larch.snapshot.date"] = time.Now().Format(time.RFC3339)
+	if strategy.ID != "" {
+		annotations["larch.snapshot.strategy"] = strategy.ID
 	}
 
 	// TODO: Include all jobs / "provenance"?
-	err = snapshotWriter.WriteArtifactManifest(ctx, libraries.ArtifactManifest{
+	manifest := libraries.ArtifactManifest{
 		ContentType: "application/vnd.larch.snapshot.manifest.v1+json",
-		Digest:      "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		Digest:      libraries.EmptyDigest,
 		Size:        0,
-		Annotations: map[string]string{
-			"larch.snapshot.url":  url,
-			"larch.snapshot.date": time.Now().Format(time.RFC3339),
-		},
-	})
+		Annotations: annotations,
+	}
+
+	err = snapshotWriter.WriteArtifactManifest(ctx, manifest)
 	if err != nil {
 		snapshotWriter.Close()
-		return err
+		s.endSnapshot(libraryID, origin, snapshotID)
+		return nil, err
 	}
 
 	if err := snapshotWriter.Close(); err != nil {
-		return err
+		s.endSnapshot(libraryID, origin, snapshotID)
+		return nil, err
+	}
+
+	// A failing replica does not fail the snapshot, it can be retried later.
+	// Replicas over their hard quota are failed from the start
+	replicas := make([]Replica, 0)
+	for _, replica := range strategy.Libraries[1:] {
+		if err := s.checkQuota(replica); err != nil {
+			slog.Warn("Not writing to replica", slog.String("library", replica), slog.Any("error", err))
+			replicas = append(replicas, Replica{Library: replica, Error: err.Error()})
+			continue
+		}
+
+		if err := s.markPartial(ctx, libraryID, replica, origin, snapshotID); err != nil {
+			slog.Warn("Not writing to replica", slog.String("library", replica), slog.Any("error", err))
+			replicas = append(replicas, Replica{Library: replica, Error: err.Error()})
+			continue
+		}
+
+		replicas = append(replicas, Replica{Library: replica})
+	}
+	s.setReplicas(libraryID, origin, snapshotID, replicas)
+
+	s.writeReplicaManifests(ctx, libraryID, origin, snapshotID, manifest)
+
+	// Index the snapshot right away to make it available even before any job
+	// has completed
+	if libraryReader, ok := s.libraryReaders[libraryID]; ok {
+		snapshotReader, err := libraryReader.ReadSnapshot(ctx, origin, snapshotID)
+		if err == nil {
+			err = s.indexer.IndexSnapshot(ctx, libraryID, origin, snapshotID, snapshotReader)
+			snapshotReader.Close()
+		}
+		if err != nil {
+			slog.Warn("Failed to index scheduled snapshot", slog.Any("error", err))
+		}
 	}
 
+	// All jobs are inflight before any is requested, for the snapshot not to be
+	// considered ended once the first job ends
+	requests := make([]JobRequest, 0, len(strategy.Archivers))
 	for _, archiver := range strategy.Archivers {
-		request := JobRequest{
+		uuid, err := uuid.NewRandom()
+		if err != nil {
+			s.endSnapshot(libraryID, origin, snapshotID)
+			return nil, err
+		}
+
+		requests = append(requests, JobRequest{
 			Token:    "", // TODO: JWT which points to snapshot and everything?
 			Archiver: archiver,
 			Job: Job{
-				ID:      id,
-				Library: strategy.Library,
+				ID:        uuid.String(),
+				Library:   libraryID,
+				Libraries: strategy.Libraries,
 				// TODO: Once this has expired, both parties understand that the job
 				// will be assumed abandoned and will be re-requested again.
 				// TODO: Match this with the token, so no further requests can be made
@@ -171,18 +458,32 @@ func (s *Scheduler) ScheduleSnapshot(ctx context.Context, url string, strategy *
 				Status:     "requested",
 				Requested:  time.Now(),
 			},
-		}
+		})
+	}
 
-		slog.Debug("Requesting job", slog.String("origin", origin), slog.String("snapshotId", snapshotID))
-		s.mutex.Lock()
+	s.mutex.Lock()
+	for _, request := range requests {
 		s.inflight[request.Job.ID] = request.Job
-		s.mutex.Unlock()
+	}
+	s.mutex.Unlock()
+
+	for _, request := range requests {
+		slog.Debug("Requesting job", slog.String("origin", origin), slog.String("snapshotId", snapshotID))
 
 		// TODO: Should these be persisted instead of just a channel?
 		// Could then be polled / initially built from a stateful source and then
 		// event-driven
+		// NOTE: Room is reserved, requesting a job never blocks
 		s.requests <- request
+		unused--
+
+		scheduled.Jobs = append(scheduled.Jobs, request.Job)
 	}
 
-	return nil
+	// Without jobs, there's nothing more to write
+	if len(scheduled.Jobs) == 0 {
+		s.endSnapshot(libraryID, origin, snapshotID)
+	}
+
+	return scheduled, nil
 }
@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/AlexGustafsson/larch/internal/indexers"
+	"github.com/AlexGustafsson/larch/internal/libraries"
+	"github.com/AlexGustafsson/larch/internal/libraries/disk"
+	"github.com/AlexGustafsson/larch/internal/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleSnapshotOverQuota(t *testing.T) {
+	local, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer local.Close()
+
+	offsite, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer offsite.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": local, "offsite": offsite}
+	libraryWriters := map[string]libraries.LibraryWriter{"local": local, "offsite": offsite}
+
+	quotas := quota.NewTracker(libraryReaders, map[string]quota.Quota{"local": {Hard: 1}, "offsite": {Hard: 1}})
+	require.NoError(t, quotas.Refresh(context.TODO()))
+	quotas.Add("offsite", 1)
+
+	scheduler := NewScheduler(indexers.NewInMemoryIndex(), libraryReaders, libraryWriters, quotas, nil)
+
+	// Replicas over quota are failed from the start
+	scheduled, err := scheduler.ScheduleSnapshot(context.TODO(), "https://example.com", &Strategy{
+		Libraries: []string{"local", "offsite"},
+		Archivers: []Archiver{{OpenGraphArchiver: &OpenGraphArchiver{}}},
+	}, nil)
+	require.NoError(t, err)
+	require.Len(t, scheduled.Jobs, 1)
+	assert.Equal(t, "requested", scheduled.Jobs[0].Status)
+
+	replicas := scheduler.GetReplicas("local", scheduled.Origin, scheduled.SnapshotID)
+	require.Len(t, replicas, 1)
+	assert.Contains(t, replicas[0].Error, "over its hard quota")
+
+	// Jobs targeting a library over quota are refused
+	quotas.Add("local", 1)
+	scheduled, err = scheduler.ScheduleSnapshot(context.TODO(), "https://example.org", &Strategy{
+		Libraries: []string{"local"},
+		Archivers: []Archiver{{OpenGraphArchiver: &OpenGraphArchiver{}}},
+	}, nil)
+	var exceeded *quota.ExceededError
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, "local", exceeded.Library)
+	require.Len(t, scheduled.Jobs, 1)
+
+	job, err := scheduler.GetJob(context.TODO(), scheduled.Jobs[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "refused", job.Status)
+	assert.Contains(t, job.Error, "over its hard quota")
+
+	_, err = local.ReadSnapshot(context.TODO(), scheduled.Origin, scheduled.SnapshotID)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestScheduleSnapshotPartial(t *testing.T) {
+	local, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer local.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": local}
+	libraryWriters := map[string]libraries.LibraryWriter{"local": local}
+
+	scheduler := NewScheduler(indexers.NewInMemoryIndex(), libraryReaders, libraryWriters, nil, nil)
+
+	scheduled, err := scheduler.ScheduleSnapshot(context.TODO(), "https://example.com", &Strategy{
+		Libraries: []string{"local"},
+		Archivers: []Archiver{{OpenGraphArchiver: &OpenGraphArchiver{}}, {ArchiveOrgArchiver: &ArchiveOrgArchiver{}}},
+	}, nil)
+	require.NoError(t, err)
+	require.Len(t, scheduled.Jobs, 2)
+
+	partial := func() bool {
+		snapshotReader, err := local.ReadSnapshot(context.TODO(), scheduled.Origin, scheduled.SnapshotID)
+		require.NoError(t, err)
+		defer snapshotReader.Close()
+		return snapshotReader.Index().Partial
+	}
+
+	// The snapshot is partial until all of its jobs have ended
+	assert.True(t, partial())
+
+	job := scheduled.Jobs[0]
+	job.Status = "succeeded"
+	require.NoError(t, scheduler.UpdateJob(context.TODO(), job))
+	assert.True(t, partial())
+
+	job = scheduled.Jobs[1]
+	job.Status = "failed"
+	require.NoError(t, scheduler.UpdateJob(context.TODO(), job))
+	assert.False(t, partial())
+
+	// Jobs updated after the snapshot has ended don't affect it
+	require.NoError(t, scheduler.UpdateJob(context.TODO(), job))
+	assert.False(t, partial())
+
+	// Snapshots without jobs end right away
+	scheduled, err = scheduler.ScheduleSnapshot(context.TODO(), "https://example.org", &Strategy{
+		Libraries: []string{"local"},
+	}, nil)
+	require.NoError(t, err)
+	assert.False(t, partial())
+}
+
+func TestScheduleSnapshotUsage(t *testing.T) {
+	local, err := disk.NewLibrary(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer local.Close()
+
+	libraryReaders := map[string]libraries.LibraryReader{"local": local}
+	libraryWriters := map[string]libraries.LibraryWriter{"local": local}
+
+	quotas := quota.NewTracker(libraryReaders, map[string]quota.Quota{})
+	require.NoError(t, quotas.Refresh(context.TODO()))
+
+	scheduler := NewScheduler(indexers.NewInMemoryIndex(), libraryReaders, libraryWriters, quotas, nil)
+
+	server := httptest.NewServer(NewAPI(scheduler, libraryWriters))
+	defer server.Close()
+
+	scheduled, err := scheduler.ScheduleSnapshot(context.TODO(), "https://example.com", &Strategy{
+		Libraries: []string{"local"},
+		Archivers: []Archiver{{OpenGraphArchiver: &OpenGraphArchiver{}}},
+	}, nil)
+	require.NoError(t, err)
+
+	client := &JobClient{
+		LibraryID:  scheduled.Library,
+		Origin:     scheduled.Origin,
+		SnapshotID: scheduled.SnapshotID,
+		Endpoint:   server.URL,
+		Client:     http.DefaultClient,
+	}
+
+	bytes := func() int64 {
+		status, ok := quotas.Status("local")
+		require.True(t, ok)
+		return status.Usage.Bytes
+	}
+
+	send := func(name string, data []byte) {
+		writer, err := client.NextArtifactWriter(context.TODO(), name)
+		require.NoError(t, err)
+		_, err = writer.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+	}
+
+	// Only newly stored bytes are accounted for, sending a stored blob again
+	// doesn't store it again
+	send("first.txt", []byte("first"))
+	assert.Equal(t, int64(5), bytes())
+
+	send("copy.txt", []byte("first"))
+	assert.Equal(t, int64(5), bytes())
+}